@@ -116,8 +116,8 @@ var _ controller.Provisioner = &efsProvisioner{}
 
 // Provision creates a storage asset and returns a PV object representing it.
 func (p *efsProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
-	if options.PVC.Spec.Selector != nil {
-		return nil, fmt.Errorf("claim.Spec.Selector is not supported")
+	if err := controller.ValidateSelector(options.SelectorRequirements); err != nil {
+		return nil, err
 	}
 
 	gid, err := p.allocator.AllocateNext(options)
@@ -229,6 +229,7 @@ func (p *efsProvisioner) getLocalPathToDelete(nfs *v1.NFSVolumeSource) (string,
 }
 
 func main() {
+	healthPort := flag.Int("health-port", 0, "Port to serve liveness and readiness checks on, at /healthz and /readyz. Not served if 0")
 	flag.Parse()
 	flag.Set("logtostderr", "true")
 
@@ -266,6 +267,7 @@ func main() {
 		provisionerName,
 		efsProvisioner,
 		serverVersion.GitVersion,
+		controller.HealthPort(*healthPort),
 	)
 
 	pc.Run(wait.NeverStop)
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -24,12 +25,14 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
 	"k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -43,6 +46,8 @@ const (
 	provisionerIDAnn   = "cephFSProvisionerIdentity"
 	cephShareAnn       = "cephShare"
 	provisionerNameKey = "PROVISIONER_NAME"
+	// How long HealthCheck waits for a cluster to answer
+	healthCheckTimeout = 5 * time.Second
 )
 
 type provisionOutput struct {
@@ -57,33 +62,65 @@ type cephFSProvisioner struct {
 	// Identity of this cephFSProvisioner, generated. Used to identify "this"
 	// provisioner's PVs.
 	identity string
+	// Clusters this provisioner has parsed from classes, keyed by monitors,
+	// for HealthCheck to check
+	clusters      map[string]cephFSCluster
+	clustersMutex *sync.Mutex
+}
+
+// cephFSCluster holds what HealthCheck needs to reach a cluster
+type cephFSCluster struct {
+	adminID     string
+	adminSecret string
+	mon         []string
 }
 
 func newCephFSProvisioner(client kubernetes.Interface, id string) controller.Provisioner {
 	return &cephFSProvisioner{
-		client:   client,
-		identity: id,
+		client:        client,
+		identity:      id,
+		clusters:      make(map[string]cephFSCluster),
+		clustersMutex: &sync.Mutex{},
 	}
 }
 
 var _ controller.Provisioner = &cephFSProvisioner{}
+var _ controller.HealthChecker = &cephFSProvisioner{}
+var _ controller.Exister = &cephFSProvisioner{}
 
 // Provision creates a storage asset and returns a PV object representing it.
 func (p *cephFSProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
-	if options.PVC.Spec.Selector != nil {
-		return nil, fmt.Errorf("claim Selector is not supported")
+	if err := controller.ValidateSelector(options.SelectorRequirements); err != nil {
+		return nil, err
 	}
+	return p.provisionShare(options, false)
+}
+
+// Exists returns a PV object representing the share named after the claim if
+// an earlier Provision created it, or nil if it doesn't exist.
+func (p *cephFSProvisioner) Exists(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	return p.provisionShare(options, true)
+}
+
+// provisionShare creates the share named after the claim and its user, or if
+// existing is set only looks them up, and returns a PV object representing
+// the share. Creating is idempotent: an existing share or user is returned as
+// is.
+func (p *cephFSProvisioner) provisionShare(options controller.VolumeOptions, existing bool) (*v1.PersistentVolume, error) {
 	cluster, adminID, adminSecret, mon, err := p.parseParameters(options.Parameters)
 	if err != nil {
 		return nil, err
 	}
-	// create random share name
-	share := fmt.Sprintf("kubernetes-dynamic-pvc-%s", uuid.NewUUID())
-	// create random user id
-	user := fmt.Sprintf("kubernetes-dynamic-user-%s", uuid.NewUUID())
+	// name the share & user after the claim, so a retry finds them with Exists
+	share := options.AssetName
+	user := fmt.Sprintf("kubernetes-dynamic-user-%s", options.PVC.UID)
+	args := []string{"-n", share, "-u", user}
+	if existing {
+		args = append(args, "-e")
+	}
 	// provision share
 	// create cmd
-	cmd := exec.Command(provisionCmd, "-n", share, "-u", user)
+	cmd := exec.Command(provisionCmd, args...)
 	// set env
 	cmd.Env = []string{
 		"CEPH_CLUSTER_NAME=" + cluster,
@@ -99,6 +136,9 @@ func (p *cephFSProvisioner) Provision(options controller.VolumeOptions) (*v1.Per
 	// validate output
 	res := &provisionOutput{}
 	json.Unmarshal([]byte(output), &res)
+	if existing && res.Path == "" {
+		return nil, nil
+	}
 	if res.User == "" || res.Secret == "" || res.Path == "" {
 		return nil, fmt.Errorf("invalid provisioner output")
 	}
@@ -117,7 +157,7 @@ func (p *cephFSProvisioner) Provision(options controller.VolumeOptions) (*v1.Per
 	}
 
 	_, err = p.client.Core().Secrets(nameSpace).Create(secret)
-	if err != nil {
+	if err != nil && !apierrs.IsAlreadyExists(err) {
 		glog.Errorf("Cephfs Provisioner: create volume failed, err: %v", err)
 		return nil, fmt.Errorf("failed to create secret")
 	}
@@ -198,6 +238,34 @@ func (p *cephFSProvisioner) Delete(volume *v1.PersistentVolume) error {
 	return nil
 }
 
+// HealthCheck checks that the Ceph clusters of the classes this provisioner
+// has served can be reached, by listing their filesystems. Until it has served
+// a class there is nothing to check.
+func (p *cephFSProvisioner) HealthCheck() error {
+	p.clustersMutex.Lock()
+	clusters := make([]cephFSCluster, 0, len(p.clusters))
+	for _, cluster := range p.clusters {
+		clusters = append(clusters, cluster)
+	}
+	p.clustersMutex.Unlock()
+
+	for _, cluster := range clusters {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		mon := strings.Join(cluster.mon, ",")
+		cmd := exec.CommandContext(ctx, "ceph", "fs", "ls", "-m", mon, "--id", cluster.adminID, "--key="+cluster.adminSecret)
+		output, err := cmd.CombinedOutput()
+		timedOut := ctx.Err() != nil
+		cancel()
+		if timedOut {
+			return fmt.Errorf("failed to reach ceph cluster %s: timed out after %v", mon, healthCheckTimeout)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to reach ceph cluster %s: %v, output: %s", mon, err, string(output))
+		}
+	}
+	return nil
+}
+
 func (p *cephFSProvisioner) parseParameters(parameters map[string]string) (string, string, string, []string, error) {
 	var (
 		err                                                                  error
@@ -238,6 +306,11 @@ func (p *cephFSProvisioner) parseParameters(parameters map[string]string) (strin
 	if len(mon) < 1 {
 		return "", "", "", nil, fmt.Errorf("missing Ceph monitors")
 	}
+
+	p.clustersMutex.Lock()
+	p.clusters[strings.Join(mon, ",")] = cephFSCluster{adminID: adminID, adminSecret: adminSecret, mon: mon}
+	p.clustersMutex.Unlock()
+
 	return cluster, adminID, adminSecret, mon, nil
 }
 
@@ -261,6 +334,7 @@ var (
 	master     = flag.String("master", "", "Master URL")
 	kubeconfig = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
 	id         = flag.String("id", "", "Unique provisioner identity")
+	healthPort = flag.Int("health-port", 0, "Port to serve liveness and readiness checks on, at /healthz and /readyz. Not served if 0")
 )
 
 func main() {
@@ -315,6 +389,7 @@ func main() {
 		prName,
 		cephFSProvisioner,
 		serverVersion.GitVersion,
+		controller.HealthPort(*healthPort),
 	)
 
 	pc.Run(wait.NeverStop)
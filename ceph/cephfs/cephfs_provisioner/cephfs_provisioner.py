@@ -24,9 +24,11 @@ import json
 CEPH_CLUSTER_NAME=test CEPH_MON=172.24.0.4 CEPH_AUTH_ID=admin CEPH_AUTH_KEY=AQCMpH9YM4Q1BhAAXGNQyyOne8ZsXqWGon/dIQ== cephfs_provisioner.py -n foo -u bar
 """
 try:
+    import cephfs
     import ceph_volume_client
     ceph_module_found = True
 except ImportError as e:
+    cephfs = None
     ceph_volume_client = None
     ceph_module_found = False
 
@@ -224,6 +226,17 @@ class CephFSNativeDriver(object):
         return json.dumps(ret)
 
 
+    def share_exists(self, path):
+        """Check whether a CephFS volume exists.
+        """
+        volume_path = ceph_volume_client.VolumePath(VOlUME_GROUP, path)
+        try:
+            self.volume_client.fs.stat(self.volume_client._get_path(volume_path))
+        except cephfs.ObjectNotFound:
+            return False
+        return True
+
+
     def delete_share(self, path, user_id):
         volume_path = ceph_volume_client.VolumePath(VOlUME_GROUP, path)
         self.volume_client._deauthorize(volume_path, user_id)
@@ -237,13 +250,14 @@ class CephFSNativeDriver(object):
 
 def main():
     create = True
+    existing = False
     share = ""
     user = ""
-    cephfs = CephFSNativeDriver()
+    driver = CephFSNativeDriver()
     try:
-        opts, args = getopt.getopt(sys.argv[1:], "rn:u:", ["remove"])
+        opts, args = getopt.getopt(sys.argv[1:], "ren:u:", ["remove", "existing"])
     except getopt.GetoptError:
-        print "Usage: " + sys.argv[0] + " --remove -n share_name -u ceph_user_id"
+        print "Usage: " + sys.argv[0] + " [--remove|--existing] -n share_name -u ceph_user_id"
         sys.exit(1)
 
     for opt, arg in opts:
@@ -253,15 +267,20 @@ def main():
             user = arg
         elif opt in ("-r", "--remove"):
             create = False
+        elif opt in ("-e", "--existing"):
+            existing = True
 
     if share == "" or user == "":
-        print "Usage: " + sys.argv[0] + " --remove -n share_name -u ceph_user_id"
+        print "Usage: " + sys.argv[0] + " [--remove|--existing] -n share_name -u ceph_user_id"
         sys.exit(1)
 
-    if create == True:
-        print cephfs.create_share(share, user)    
+    if not create:
+        driver.delete_share(share, user)
+    elif existing and not driver.share_exists(share):
+        # print an empty result if the share doesn't exist
+        print json.dumps({})
     else:
-        cephfs.delete_share(share, user)    
+        print driver.create_share(share, user)
         
         
 if __name__ == "__main__":
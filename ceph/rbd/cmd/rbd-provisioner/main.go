@@ -30,9 +30,11 @@ import (
 )
 
 var (
-	master     = flag.String("master", "", "Master URL")
-	kubeconfig = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
-	id         = flag.String("id", "", "Unique provisioner identity")
+	master       = flag.String("master", "", "Master URL")
+	kubeconfig   = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
+	id           = flag.String("id", "", "Unique provisioner identity")
+	addFinalizer = flag.Bool("add-finalizer", false, "Add a finalizer to provisioned PVs so that deleting a PV directly does not leak its RBD image")
+	healthPort   = flag.Int("health-port", 0, "Port to serve liveness and readiness checks on, at /healthz and /readyz. Not served if 0")
 )
 
 const (
@@ -91,6 +93,8 @@ func main() {
 		prName,
 		rbdProvisioner,
 		serverVersion.GitVersion,
+		controller.AddFinalizer(*addFinalizer),
+		controller.HealthPort(*healthPort),
 	)
 
 	pc.Run(wait.NeverStop)
@@ -20,10 +20,10 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
-	"github.com/pborman/uuid"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -39,6 +39,9 @@ const (
 	// Each provisioner have a identify string to distinguish with others. This
 	// identify string will be added in PV annoations under this key.
 	provisionerIDAnn = "rbdProvisionerIdentity"
+	// Claims can select the pool of their image with a selector on this label,
+	// overriding the StorageClass's pool. PVs are labeled with their pool.
+	poolLabel = "ceph.com/rbd-pool"
 
 	secretKeyName   = "key" // key name used in secret
 	rbdImageFormat1 = "1"
@@ -67,18 +70,33 @@ type rbdProvisioner struct {
 	// provisioner's PVs.
 	identity string
 	rbdUtil  *RBDUtil
+	// Clusters & pools this provisioner has parsed from classes, keyed by
+	// monitors & pool, for HealthCheck to check
+	clusters      map[string]rbdProvisionOptions
+	clustersMutex *sync.Mutex
 }
 
 // NewRBDProvisioner creates a Provisioner that provisions Ceph RBD PVs backed by Ceph RBD images.
 func NewRBDProvisioner(client kubernetes.Interface, id string) controller.Provisioner {
 	return &rbdProvisioner{
-		client:   client,
-		identity: id,
-		rbdUtil:  &RBDUtil{},
+		client:        client,
+		identity:      id,
+		rbdUtil:       &RBDUtil{},
+		clusters:      make(map[string]rbdProvisionOptions),
+		clustersMutex: &sync.Mutex{},
 	}
 }
 
 var _ controller.Provisioner = &rbdProvisioner{}
+var _ controller.BlockProvisioner = &rbdProvisioner{}
+var _ controller.Cloner = &rbdProvisioner{}
+var _ controller.Exister = &rbdProvisioner{}
+var _ controller.HealthChecker = &rbdProvisioner{}
+
+// SupportsBlock returns true: RBD images can be consumed as raw block devices.
+func (p *rbdProvisioner) SupportsBlock() bool {
+	return true
+}
 
 // getAccessModes returns access modes RBD volume supported.
 func (p *rbdProvisioner) getAccessModes() []v1.PersistentVolumeAccessMode {
@@ -93,15 +111,16 @@ func (p *rbdProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 	if !AccessModesContainedInAll(p.getAccessModes(), options.PVC.Spec.AccessModes) {
 		return nil, fmt.Errorf("invalid AccessModes %v: only AccessModes %v are supported", options.PVC.Spec.AccessModes, p.getAccessModes())
 	}
-	if options.PVC.Spec.Selector != nil {
-		return nil, fmt.Errorf("claim Selector is not supported")
+	if err := controller.ValidateSelector(options.SelectorRequirements, poolLabel); err != nil {
+		return nil, err
 	}
 	opts, err := p.parseParameters(options.Parameters)
 	if err != nil {
 		return nil, err
 	}
-	// create random image name
-	image := fmt.Sprintf("kubernetes-dynamic-pvc-%s", uuid.NewUUID())
+	selectPool(opts, options)
+	// name the image after the claim, so a retry finds it with Exists
+	image := options.AssetName
 	rbd, sizeMB, err := p.rbdUtil.CreateImage(image, opts, options)
 	if err != nil {
 		glog.Errorf("rbd: create volume failed, err: %v", err)
@@ -109,6 +128,58 @@ func (p *rbdProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 	}
 	glog.Infof("successfully created rbd image %q", image)
 
+	return p.newPV(options, opts, rbd, sizeMB), nil
+}
+
+// Clone creates a storage asset with a copy of the source volume's image and
+// returns a PV object representing it.
+func (p *rbdProvisioner) Clone(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if !AccessModesContainedInAll(p.getAccessModes(), options.PVC.Spec.AccessModes) {
+		return nil, fmt.Errorf("invalid AccessModes %v: only AccessModes %v are supported", options.PVC.Spec.AccessModes, p.getAccessModes())
+	}
+	if err := controller.ValidateSelector(options.SelectorRequirements, poolLabel); err != nil {
+		return nil, err
+	}
+	source := options.DataSource.PV.Spec.PersistentVolumeSource.RBD
+	if source == nil {
+		return nil, fmt.Errorf("source volume %q is not an RBD volume", options.DataSource.PV.Name)
+	}
+	opts, err := p.parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	selectPool(opts, options)
+	// name the image after the claim, so a retry finds it with Exists
+	image := options.AssetName
+	rbd, sizeMB, err := p.rbdUtil.CopyImage(image, source, opts, options)
+	if err != nil {
+		glog.Errorf("rbd: copy volume failed, err: %v", err)
+		return nil, err
+	}
+	glog.Infof("successfully copied rbd image %q to %q", source.RBDImage, image)
+
+	return p.newPV(options, opts, rbd, sizeMB), nil
+}
+
+// Exists returns a PV object representing the image named after the claim if
+// an earlier Provision or Clone created it, or nil if it doesn't exist.
+func (p *rbdProvisioner) Exists(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	opts, err := p.parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	selectPool(opts, options)
+	rbd, sizeMB, err := p.rbdUtil.ImageInfo(options.AssetName, opts)
+	if err != nil || rbd == nil {
+		return nil, err
+	}
+	glog.Infof("rbd image %q already exists", options.AssetName)
+
+	return p.newPV(options, opts, rbd, sizeMB), nil
+}
+
+// newPV returns a PV object representing the given image
+func (p *rbdProvisioner) newPV(options controller.VolumeOptions, opts *rbdProvisionOptions, rbd *v1.RBDVolumeSource, sizeMB int) *v1.PersistentVolume {
 	rbd.SecretRef = new(v1.LocalObjectReference)
 	rbd.SecretRef.Name = opts.userSecretName
 	rbd.RadosUser = opts.userID
@@ -116,6 +187,9 @@ func (p *rbdProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 	pv := &v1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: options.PVName,
+			Labels: map[string]string{
+				poolLabel: rbd.RBDPool,
+			},
 			Annotations: map[string]string{
 				provisionerIDAnn: p.identity,
 			},
@@ -137,7 +211,7 @@ func (p *rbdProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 		pv.Spec.AccessModes = p.getAccessModes()
 	}
 
-	return pv, nil
+	return pv
 }
 
 // Delete removes the storage asset that was created by Provision represented
@@ -160,10 +234,50 @@ func (p *rbdProvisioner) Delete(volume *v1.PersistentVolume) error {
 	if err != nil {
 		return err
 	}
+	// The claim may have selected another pool than the class's
+	if pool := volume.Spec.PersistentVolumeSource.RBD.RBDPool; pool != "" {
+		opts.pool = pool
+	}
 	image := volume.Spec.PersistentVolumeSource.RBD.RBDImage
 	return p.rbdUtil.DeleteImage(image, opts)
 }
 
+// HealthCheck checks that the Ceph clusters of the classes this provisioner
+// has served can be reached, by listing the images of their pools. Until it
+// has served a class there is nothing to check.
+func (p *rbdProvisioner) HealthCheck() error {
+	p.clustersMutex.Lock()
+	clusters := make([]rbdProvisionOptions, 0, len(p.clusters))
+	for _, opts := range p.clusters {
+		clusters = append(clusters, opts)
+	}
+	p.clustersMutex.Unlock()
+
+	for i := range clusters {
+		if err := p.rbdUtil.ListImages(&clusters[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectPool overrides the pool of opts with the one the claim's selector
+// selects on poolLabel, if any. Of several pools the class's is preferred,
+// otherwise the first is used.
+func selectPool(opts *rbdProvisionOptions, options controller.VolumeOptions) {
+	pools, ok := controller.SelectorValues(options.SelectorRequirements, poolLabel)
+	if !ok || len(pools) == 0 {
+		return
+	}
+	for _, pool := range pools {
+		if pool == opts.pool {
+			return
+		}
+	}
+	glog.V(4).Infof("rbd: claim selects pool %s instead of the class's %s", pools[0], opts.pool)
+	opts.pool = pools[0]
+}
+
 func (p *rbdProvisioner) parseParameters(parameters map[string]string) (*rbdProvisionOptions, error) {
 	// options with default values
 	opts := &rbdProvisionOptions{
@@ -246,6 +360,10 @@ func (p *rbdProvisioner) parseParameters(parameters map[string]string) (*rbdProv
 		opts.userID = opts.adminID
 	}
 
+	p.clustersMutex.Lock()
+	p.clusters[strings.Join(opts.monitors, ",")+"/"+opts.pool] = *opts
+	p.clustersMutex.Unlock()
+
 	return opts, nil
 }
 
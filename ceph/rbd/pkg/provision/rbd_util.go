@@ -17,10 +17,13 @@ limitations under the License.
 package provision
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
@@ -29,7 +32,10 @@ import (
 )
 
 const (
-	imageWatcherStr = "watcher="
+	imageWatcherStr  = "watcher="
+	imageNotFoundStr = "No such file or directory"
+	// How long ListImages may take, for health checks to return promptly
+	listImagesTimeout = 5 * time.Second
 )
 
 // RBDUtil is the utility structure to interact with the RBD.
@@ -86,6 +92,138 @@ func (u *RBDUtil) CreateImage(image string, pOpts *rbdProvisionOptions, options
 	}, sz, nil
 }
 
+// CopyImage creates a new ceph image with a copy of the given source image's
+// contents, grown to the requested size if the source image is smaller. The
+// copy doesn't depend on the source image, which can be deleted independently.
+func (u *RBDUtil) CopyImage(image string, source *v1.RBDVolumeSource, pOpts *rbdProvisionOptions, options controller.VolumeOptions) (*v1.RBDVolumeSource, int, error) {
+	var output []byte
+	var err error
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	sourceCapacity := options.DataSource.PV.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	// convert to MB that rbd defaults on
+	sz := int(util.RoundUpSize(capacity.Value(), 1024*1024))
+	sourceSz := int(util.RoundUpSize(sourceCapacity.Value(), 1024*1024))
+	if sz <= 0 {
+		return nil, 0, fmt.Errorf("invalid storage '%s' requested for RBD provisioner, it must greater than zero", capacity.String())
+	}
+	volSz := fmt.Sprintf("%d", sz)
+	src := source.RBDPool + "/" + source.RBDImage
+	dst := pOpts.pool + "/" + image
+	// rbd cp
+	l := len(pOpts.monitors)
+	// pick a mon randomly
+	start := rand.Int() % l
+	// iterate all monitors until copy succeeds.
+	for i := start; i < start+l; i++ {
+		mon := pOpts.monitors[i%l]
+		glog.V(4).Infof("rbd: cp %s %s using mon %s, id %s key %s", src, dst, mon, pOpts.adminID, pOpts.adminSecret)
+		args := []string{"cp", src, dst, "--id", pOpts.adminID, "-m", mon, "--key=" + pOpts.adminSecret}
+		output, err = u.execCommand("rbd", args)
+		if err != nil {
+			glog.Warningf("failed to copy rbd image, output %v", string(output))
+			continue
+		}
+		if sz > sourceSz {
+			glog.V(4).Infof("rbd: resize %s size %s using mon %s, id %s key %s", dst, volSz, mon, pOpts.adminID, pOpts.adminSecret)
+			args = []string{"resize", dst, "--size", volSz, "--id", pOpts.adminID, "-m", mon, "--key=" + pOpts.adminSecret}
+			output, err = u.execCommand("rbd", args)
+			if err != nil {
+				// The copy exists, so don't try to copy again
+				return nil, 0, fmt.Errorf("failed to resize copied rbd image %s: %v, command output: %s", dst, err, string(output))
+			}
+		} else {
+			sz = sourceSz
+		}
+		break
+	}
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to copy rbd image %s: %v, command output: %s", src, err, string(output))
+	}
+
+	return &v1.RBDVolumeSource{
+		CephMonitors: pOpts.monitors,
+		RBDImage:     image,
+		RBDPool:      pOpts.pool,
+	}, sz, nil
+}
+
+// ImageInfo returns the volume source and size in MB of the given image, or
+// nil if it doesn't exist.
+func (u *RBDUtil) ImageInfo(image string, pOpts *rbdProvisionOptions) (*v1.RBDVolumeSource, int, error) {
+	var output []byte
+	var err error
+
+	// rbd info
+	l := len(pOpts.monitors)
+	// pick a mon randomly
+	start := rand.Int() % l
+	// iterate all monitors until info succeeds.
+	for i := start; i < start+l; i++ {
+		mon := pOpts.monitors[i%l]
+		glog.V(4).Infof("rbd: info %s using mon %s, pool %s id %s key %s", image, mon, pOpts.pool, pOpts.adminID, pOpts.adminSecret)
+		args := []string{"info", image, "--pool", pOpts.pool, "--id", pOpts.adminID, "-m", mon, "--key=" + pOpts.adminSecret, "--format", "json"}
+		output, err = u.execCommand("rbd", args)
+		if err == nil {
+			break
+		}
+		if strings.Contains(string(output), imageNotFoundStr) {
+			return nil, 0, nil
+		}
+		glog.Warningf("failed to get rbd image info, output %v", string(output))
+	}
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get rbd image info: %v, command output: %s", err, string(output))
+	}
+	info := struct {
+		Size int64 `json:"size"`
+	}{}
+	if err = json.Unmarshal(output, &info); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse rbd image info: %v, command output: %s", err, string(output))
+	}
+
+	return &v1.RBDVolumeSource{
+		CephMonitors: pOpts.monitors,
+		RBDImage:     image,
+		RBDPool:      pOpts.pool,
+	}, int(util.RoundUpSize(info.Size, 1024*1024)), nil
+}
+
+// ListImages lists the images of the pool to check that the cluster can be
+// reached, giving up after listImagesTimeout.
+func (u *RBDUtil) ListImages(pOpts *rbdProvisionOptions) error {
+	var output []byte
+	var err error
+
+	ctx, cancel := context.WithTimeout(context.Background(), listImagesTimeout)
+	defer cancel()
+	// rbd ls
+	l := len(pOpts.monitors)
+	// pick a mon randomly
+	start := rand.Int() % l
+	// iterate all monitors until ls succeeds.
+	for i := start; i < start+l; i++ {
+		mon := pOpts.monitors[i%l]
+		glog.V(4).Infof("rbd: ls using mon %s, pool %s id %s", mon, pOpts.pool, pOpts.adminID)
+		args := []string{"ls", "--pool", pOpts.pool, "--id", pOpts.adminID, "-m", mon, "--key=" + pOpts.adminSecret}
+		output, err = exec.CommandContext(ctx, "rbd", args...).CombinedOutput()
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		glog.Warningf("failed to list rbd images, output %v", string(output))
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("failed to list rbd images of pool %s: timed out after %v", pOpts.pool, listImagesTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list rbd images of pool %s: %v, command output: %s", pOpts.pool, err, string(output))
+	}
+	return nil
+}
+
 // rbdStatus checks if there is watcher on the image.
 // It returns true if there is a watcher onthe image, otherwise returns false.
 func (u *RBDUtil) rbdStatus(image string, pOpts *rbdProvisionOptions) (bool, error) {
@@ -19,7 +19,9 @@ package main
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"os/exec"
 	"path"
 	"time"
 
@@ -66,6 +68,7 @@ func NewHostPathProvisioner() controller.Provisioner {
 }
 
 var _ controller.Provisioner = &hostPathProvisioner{}
+var _ controller.Cloner = &hostPathProvisioner{}
 
 // Provision creates a storage asset and returns a PV object representing it.
 func (p *hostPathProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
@@ -99,6 +102,29 @@ func (p *hostPathProvisioner) Provision(options controller.VolumeOptions) (*v1.P
 	return pv, nil
 }
 
+// Clone creates a storage asset with a copy of the source volume's contents and
+// returns a PV object representing it.
+func (p *hostPathProvisioner) Clone(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	ann, ok := options.DataSource.PV.Annotations["hostPathProvisionerIdentity"]
+	if !ok || ann != p.identity {
+		return nil, errors.New("identity annotation on source PV does not match ours")
+	}
+
+	pv, err := p.Provision(options)
+	if err != nil {
+		return nil, err
+	}
+
+	sourcePath := path.Join(p.pvDir, options.DataSource.PV.Name)
+	path := pv.Spec.PersistentVolumeSource.HostPath.Path
+	if out, err := exec.Command("cp", "-a", sourcePath+"/.", path).CombinedOutput(); err != nil {
+		os.RemoveAll(path)
+		return nil, fmt.Errorf("error copying %s: %v, output: %s", sourcePath, err, out)
+	}
+
+	return pv, nil
+}
+
 // Delete removes the storage asset that was created by Provision represented
 // by the given PV.
 func (p *hostPathProvisioner) Delete(volume *v1.PersistentVolume) error {
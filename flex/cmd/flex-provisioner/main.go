@@ -36,6 +36,7 @@ var (
 	master      = flag.String("master", "", "Master URL to build a client config from. Either this or kubeconfig needs to be set if the provisioner is being run out of cluster.")
 	kubeconfig  = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Either this or master needs to be set if the provisioner is being run out of cluster.")
 	execCommand = flag.String("execCommand", "/opt/storage/flex-provision.sh", "The provisioner executable.")
+	healthPort  = flag.Int("health-port", 0, "The port to serve liveness and readiness checks on, at /healthz and /readyz. If 0, they are not served.")
 )
 
 func main() {
@@ -86,6 +87,7 @@ func main() {
 		*provisioner,
 		flexProvisioner,
 		serverVersion.GitVersion,
+		controller.HealthPort(*healthPort),
 	)
 
 	pc.Run(wait.NeverStop)
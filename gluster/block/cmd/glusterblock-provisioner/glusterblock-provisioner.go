@@ -33,7 +33,6 @@ import (
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -54,6 +53,10 @@ const (
 	blockVolPrefix     = "blockvol_"
 	heketiOpmode       = "heketi"
 	glusterBlockOpmode = "gluster-block"
+	// gluster-block targets' IQN prefix & portal port, which its info command
+	// doesn't report
+	glusterBlockIqnPrefix = "iqn.2016-12.org.gluster-block:"
+	glusterBlockPort      = "3260"
 )
 
 type glusterBlockProvisioner struct {
@@ -113,6 +116,13 @@ type glusterBlockExecVolRes struct {
 	Paths   int      `json:"HA"`
 }
 
+// glusterBlockExecInfoRes is the output of the gluster-block info command
+type glusterBlockExecInfoRes struct {
+	GBID     string   `json:"GBID"`
+	Password string   `json:"PASSWORD"`
+	Hosts    []string `json:"EXPORTED ON"`
+}
+
 type heketiBlockVolRes struct {
 	ID      string   `json:"id"`
 	Portals []string `json:"hosts"`
@@ -149,13 +159,21 @@ func NewGlusterBlockProvisioner(client kubernetes.Interface, id string) controll
 }
 
 var _ controller.Provisioner = &glusterBlockProvisioner{}
+var _ controller.BlockProvisioner = &glusterBlockProvisioner{}
+var _ controller.Exister = &glusterBlockProvisioner{}
+
+// SupportsBlock returns true: gluster-block volumes are iSCSI LUNs that can be
+// consumed as raw block devices.
+func (p *glusterBlockProvisioner) SupportsBlock() bool {
+	return true
+}
 
 // Provision creates a storage asset and returns a PV object representing it.
 func (p *glusterBlockProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
 
 	var err error
-	if options.PVC.Spec.Selector != nil {
-		return nil, fmt.Errorf("glusterblock: claim Selector is not supported")
+	if err = controller.ValidateSelector(options.SelectorRequirements); err != nil {
+		return nil, fmt.Errorf("glusterblock: %v", err)
 	}
 
 	glog.V(4).Infof("glusterblock: VolumeOptions %v", options)
@@ -176,13 +194,42 @@ func (p *glusterBlockProvisioner) Provision(options controller.VolumeOptions) (*
 	// Create gluster block Volume
 	blockVolName := ""
 	if cfg.opMode == glusterBlockOpmode {
-		blockVolName = blockVolPrefix + string(uuid.NewUUID())
+		// Name the volume after the claim, so a retry finds it with Exists
+		blockVolName = options.AssetName
 	}
 	blockVol, createErr := p.createVolume(volszInt, blockVolName, cfg)
 	if createErr != nil {
 		return nil, fmt.Errorf("glusterblock: failed to create volume: %v", createErr)
 	}
 
+	return p.newPV(options, cfg, blockVol, blockVolName)
+}
+
+// Exists returns a PV object representing the block volume named after the
+// claim if an earlier Provision created it, or nil if it doesn't exist. Only
+// volumes of the gluster-block opmode can be found: heketi names the volumes
+// it creates itself.
+func (p *glusterBlockProvisioner) Exists(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	cfg, parseErr := parseClassParameters(options.Parameters, p.client)
+	if parseErr != nil {
+		return nil, fmt.Errorf("glusterblock: failed to parse class parameters: %v", parseErr)
+	}
+	if cfg.opMode != glusterBlockOpmode {
+		return nil, nil
+	}
+
+	blockVol, err := p.getVolume(options.AssetName, cfg)
+	if err != nil || blockVol == nil {
+		return nil, err
+	}
+	glog.V(1).Infof("glusterblock: volume %v already exists", options.AssetName)
+
+	return p.newPV(options, cfg, blockVol, options.AssetName)
+}
+
+// newPV returns a PV object representing the given block volume
+func (p *glusterBlockProvisioner) newPV(options controller.VolumeOptions, cfg *provisionerConfig, blockVol *glusterBlockVolume, blockVolName string) (*v1.PersistentVolume, error) {
+	var err error
 	iscsiVol := &iscsiSpec{}
 	if blockVol != nil {
 		blockVol.iscsiSpec = iscsiVol
@@ -417,6 +464,42 @@ func (p *glusterBlockProvisioner) createVolume(volSizeInt int, blockVol string,
 	return blockRes, nil
 }
 
+// getVolume returns the gluster block volume with the given name, or nil if it
+// doesn't exist.
+func (p *glusterBlockProvisioner) getVolume(blockVol string, config *provisionerConfig) (*glusterBlockVolume, error) {
+	cmd := exec.Command(
+		config.opMode, "info", config.blockModeArgs["glustervol"]+"/"+blockVol, "--json")
+
+	out, cmdErr := cmd.CombinedOutput()
+	if cmdErr != nil {
+		if dstrings.Contains(string(out), "doesn't exist") || dstrings.Contains(string(out), "does not exist") {
+			return nil, nil
+		}
+		glog.Errorf("glusterblock: command [%v] failed: %v", cmd, cmdErr)
+		return nil, fmt.Errorf("glusterblock: gluster block command failed")
+	}
+
+	info := &glusterBlockExecInfoRes{}
+	if unmarshErr := json.Unmarshal([]byte(out), info); unmarshErr != nil {
+		return nil, fmt.Errorf("glusterblock: failed to unmarshal gluster-block info command response")
+	}
+
+	// The info command reports the target's hosts & ID rather than its
+	// portals & IQN. With CHAP authentication enabled, the user is the ID.
+	execBlockRes := &glusterBlockExecVolRes{
+		Iqn:  glusterBlockIqnPrefix + info.GBID,
+		Name: blockVol,
+	}
+	for _, host := range info.Hosts {
+		execBlockRes.Portals = append(execBlockRes.Portals, host+":"+glusterBlockPort)
+	}
+	if config.chapAuthEnabled {
+		execBlockRes.User = info.GBID
+		execBlockRes.AuthKey = info.Password
+	}
+	return &glusterBlockVolume{glusterBlockExecVolRes: execBlockRes}, nil
+}
+
 // Delete removes the storage asset that was created by Provision represented
 // by the given PV.
 func (p *glusterBlockProvisioner) Delete(volume *v1.PersistentVolume) error {
@@ -714,6 +797,7 @@ var (
 	master     = flag.String("master", "", "Master URL")
 	kubeconfig = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
 	id         = flag.String("id", "", "Unique provisioner identity")
+	healthPort = flag.Int("health-port", 0, "Port to serve liveness and readiness checks on, at /healthz and /readyz. Not served if 0")
 )
 
 func main() {
@@ -771,6 +855,7 @@ func main() {
 		prName,
 		glusterBlockProvisioner,
 		serverVersion.GitVersion,
+		controller.HealthPort(*healthPort),
 	)
 
 	pc.Run(wait.NeverStop)
@@ -77,8 +77,8 @@ type glusterBrick struct {
 var _ controller.Provisioner = &glusterfsProvisioner{}
 
 func (p *glusterfsProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
-	if options.PVC.Spec.Selector != nil {
-		return nil, fmt.Errorf("claim Selector is not supported")
+	if err := controller.ValidateSelector(options.SelectorRequirements); err != nil {
+		return nil, err
 	}
 	glog.V(4).Infof("Start Provisioning volume: VolumeOptions %v", options)
 
@@ -78,7 +78,8 @@ to quickly create a Cobra application.`,
 
 		iscsiProvisioner := provisioner.NewiscsiProvisioner(url)
 		log.Debugln("iscsi provisioner created")
-		pc := controller.NewProvisionController(kubernetesClientSet, viper.GetString("provisioner-name"), iscsiProvisioner, serverVersion.GitVersion)
+		pc := controller.NewProvisionController(kubernetesClientSet, viper.GetString("provisioner-name"), iscsiProvisioner, serverVersion.GitVersion,
+			controller.HealthPort(viper.GetInt("health-port")))
 		//		pc := controller.NewProvisionController(kubernetesClientSet, viper.GetDuration("resync-period"), viper.GetString("provisioner-name"), iscsiProvisioner, serverVersion.GitVersion,
 		//			viper.GetBool("exponential-backoff-on-error"), viper.GetInt("fail-retry-threshold"), viper.GetDuration("lease-period"),
 		//			viper.GetDuration("renew-deadline"), viper.GetDuration("retry-priod"), viper.GetDuration("term-limit"))
@@ -113,6 +114,8 @@ func init() {
 	viper.BindPFlag("retry-period", startcontrollerCmd.Flags().Lookup("retry-period"))
 	startcontrollerCmd.Flags().Duration("term-limit", controller.DefaultTermLimit, "TermLimit is the maximum duration that a leader may remain the leader to complete the task before it must give up its leadership. 0 for forever or indefinite.")
 	viper.BindPFlag("term-limit", startcontrollerCmd.Flags().Lookup("term-limit"))
+	startcontrollerCmd.Flags().Int("health-port", controller.DefaultHealthPort, "port on which to serve liveness and readiness checks, at /healthz and /readyz, 0 to not serve them. The liveness check fails if targetd can't be reached")
+	viper.BindPFlag("health-port", startcontrollerCmd.Flags().Lookup("health-port"))
 	startcontrollerCmd.Flags().String("targetd-scheme", "http", "scheme of the targetd connection, can be http or https")
 	viper.BindPFlag("targetd-scheme", startcontrollerCmd.Flags().Lookup("targetd-scheme"))
 	startcontrollerCmd.Flags().String("targetd-username", "admin", "username for the targetd connection")
@@ -61,6 +61,9 @@ type iscsiProvisioner struct {
 	targetdURL string
 }
 
+var _ controller.HealthChecker = &iscsiProvisioner{}
+var _ controller.BlockProvisioner = &iscsiProvisioner{}
+
 type export struct {
 	InitiatorWwn string `json:"initiator_wwn"`
 	Lun          int32  `json:"lun"`
@@ -159,6 +162,18 @@ func (p *iscsiProvisioner) Delete(volume *v1.PersistentVolume) error {
 	return nil
 }
 
+// SupportsBlock returns true: the LUNs exported by targetd can be consumed as
+// raw block devices.
+func (p *iscsiProvisioner) SupportsBlock() bool {
+	return true
+}
+
+// HealthCheck checks that targetd can be reached by listing its exports.
+func (p *iscsiProvisioner) HealthCheck() error {
+	_, err := p.exportList()
+	return err
+}
+
 func initLog() {
 	var err error
 	log.Level, err = logrus.ParseLevel(viper.GetString("log-level"))
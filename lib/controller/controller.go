@@ -17,23 +17,31 @@ limitations under the License.
 package controller
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os/exec"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/external-storage/lib/leaderelection"
 	rl "github.com/kubernetes-incubator/external-storage/lib/leaderelection/resourcelock"
+	"github.com/kubernetes-incubator/external-storage/lib/workqueue"
 	"k8s.io/api/core/v1"
 	storage "k8s.io/api/storage/v1"
 	storagebeta "k8s.io/api/storage/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -64,6 +72,49 @@ const annDynamicallyProvisioned = "pv.kubernetes.io/provisioned-by"
 
 const annStorageProvisioner = "volume.beta.kubernetes.io/storage-provisioner"
 
+// annMountOptions annotation represents the mount options a PV should be
+// mounted with. It is how Kubernetes versions without PV.Spec.MountOptions
+// learn of a StorageClass's mount options.
+const annMountOptions = "volume.beta.kubernetes.io/mount-options"
+
+// annSelectedNode annotation is added to a PVC by the scheduler when the
+// claim's StorageClass has delayed binding, i.e. volumeBindingMode
+// WaitForFirstConsumer. Its value is the name of the node selected for the
+// pod using the claim.
+const annSelectedNode = "volume.kubernetes.io/selected-node"
+
+const volumeBindingWaitForFirstConsumer = "WaitForFirstConsumer"
+
+// finalizerPV is added to the PVs the controller provisions if option function
+// AddFinalizer is set. It keeps a PV object that is deleted directly from
+// disappearing before its storage asset has been deleted.
+const finalizerPV = "external-provisioner.volume.kubernetes.io/finalizer"
+
+// These annotations record on a PVC the number of consecutive failed
+// provisions for it and the last error, and likewise on a PV for deletions.
+// Once the number reaches failedProvisionThreshold/failedDeleteThreshold the
+// controller stops retrying; removing the number annotation requests a retry.
+const (
+	annProvisionFailures  = "controller.external-storage.incubator.kubernetes.io/provision-failures"
+	annProvisionLastError = "controller.external-storage.incubator.kubernetes.io/provision-last-error"
+	annDeleteFailures     = "controller.external-storage.incubator.kubernetes.io/delete-failures"
+	annDeleteLastError    = "controller.external-storage.incubator.kubernetes.io/delete-last-error"
+)
+
+// annProvisionFinalError records on a PVC the FinalError provisioning for it
+// failed with. The controller doesn't retry, whatever failedProvisionThreshold
+// is, until the annotation is removed.
+const annProvisionFinalError = "controller.external-storage.incubator.kubernetes.io/provision-final-error"
+
+// annProvisionHandle records on a PVC the handle of the asynchronous provision,
+// started by an AsyncProvisioner, the controller is polling for it
+const annProvisionHandle = "controller.external-storage.incubator.kubernetes.io/provision-handle"
+
+// annClaimLabels records on a PV the labels its claim had on the keys the
+// ClaimLabelSelector of the controller that provisioned it selects on, so that
+// only controllers whose ClaimLabelSelector matches them delete the PV
+const annClaimLabels = "controller.external-storage.incubator.kubernetes.io/claim-labels"
+
 // ProvisionController is a controller that provisions PersistentVolumes for
 // PersistentVolumeClaims.
 type ProvisionController struct {
@@ -71,14 +122,16 @@ type ProvisionController struct {
 
 	// The name of the provisioner for which this controller dynamically
 	// provisions volumes. The value of annDynamicallyProvisioned and
-	// annStorageProvisioner to set & watch for, respectively
+	// annStorageProvisioner to set & watch for, respectively. Also names the
+	// controller, e.g. its leader election lock, when it serves more than one.
 	provisionerName string
 
-	// The provisioner the controller will use to provision and delete volumes.
-	// Presumably this implementer of Provisioner carries its own
-	// volume-specific options and such that it needs in order to provision
-	// volumes.
-	provisioner Provisioner
+	// The provisioners the controller will use to provision and delete
+	// volumes, keyed by the provisioner names they serve: provisionerName's &
+	// any added with option function AdditionalProvisioner. Presumably each
+	// implementer of Provisioner carries its own volume-specific options and
+	// such that it needs in order to provision volumes.
+	provisioners map[string]Provisioner
 
 	// Kubernetes cluster server version:
 	// * 1.4: storage classes introduced as beta. Technically out-of-tree dynamic
@@ -95,10 +148,21 @@ type ProvisionController struct {
 	classSource      cache.ListerWatcher
 	classReflector   *cache.Reflector
 
+	// Shared informers passed with option functions ClaimsInformer etc. & run
+	// by the caller, used instead of the controllers & reflector above
+	claimInformer  cache.SharedInformer
+	volumeInformer cache.SharedInformer
+	classInformer  cache.SharedInformer
+
 	volumes cache.Store
 	claims  cache.Store
 	classes cache.Store
 
+	// Policies of StorageClasses decoded from raw objects, keyed by class name
+	// and reused until the cached class's resourceVersion changes
+	classPolicies      map[string]*storageClassPolicy
+	classPoliciesMutex *sync.Mutex
+
 	// Identity of this controller, generated at creation time and not persisted
 	// across restarts. Useful only for debugging, for seeing the source of
 	// events. controller.provisioner may have its own, different notion of
@@ -108,15 +172,27 @@ type ProvisionController struct {
 
 	resyncPeriod time.Duration
 
-	// Map of scheduled/running operations.
+	// Queues of claim & volume keys to sync and the number of workers
+	// processing each. A key is never processed by more than one worker at a
+	// time and failed keys are re-queued with exponential backoff, if enabled.
+	claimQueue                workqueue.RateLimitingInterface
+	volumeQueue               workqueue.RateLimitingInterface
+	threadiness               int
+	exponentialBackOffOnError bool
+	// Keys of each queue waiting out a retry delay, which resyncs must not add
+	// back before the delay is up
+	retryingKeys      map[workqueue.Interface]map[string]bool
+	retryingKeysMutex *sync.Mutex
+
+	// Map of scheduled/running leader election operations.
 	runningOperations goroutinemap.GoRoutineMap
 
 	createProvisionedPVRetryCount int
 	createProvisionedPVInterval   time.Duration
 
-	failedProvisionThreshold, failedDeleteThreshold int
-	// Map of failed claims to provisions/volumes to deletes
-	failedProvisionStats, failedDeleteStats           map[types.UID]int
+	// Failure counts are recorded in PVC/PV annotations so that they persist
+	// across restarts & are shared by all controllers
+	failedProvisionThreshold, failedDeleteThreshold   int
 	failedProvisionStatsMutex, failedDeleteStatsMutex *sync.Mutex
 
 	// Parameters of leaderelection.LeaderElectionConfig. Leader election is for
@@ -128,15 +204,105 @@ type ProvisionController struct {
 	leaderElectors      map[types.UID]*leaderelection.LeaderElector
 	leaderElectorsMutex *sync.Mutex
 
+	// Whether controllers race to lock every claim or elect a single leader
+	// that alone provisions & deletes. In the latter case, the namespace & type
+	// of the lock object
+	leaderElectionMode      LeaderElectionMode
+	leaderElectionNamespace string
+	leaderElectionLockType  string
+	// Whether this controller is the leader, in LeaderElectionController mode
+	leading      bool
+	leadingMutex *sync.Mutex
+
+	// Port & path on which to serve metrics. Metrics are not served if the port
+	// is 0
+	metricsPort int
+	metricsPath string
+	metrics     *controllerMetrics
+
+	// Port on which to serve health checks. They are not served if the port is
+	// 0, and are served alongside metrics if it is the same as metricsPort.
+	healthPort int
+
+	// Whether to only log & emit events describing what would be provisioned,
+	// deleted or resized instead of doing it. Each event is emitted once per
+	// object, keyed by reason & UID in dryRunEvents, and afterwards only logged.
+	dryRun            bool
+	dryRunEvents      map[string]bool
+	dryRunEventsMutex *sync.Mutex
+
+	// Whether to add finalizerPV to provisioned PVs
+	addFinalizer bool
+
+	// How often to look for storage assets whose PV no longer exists, if the
+	// provisioner implements Lister, and whether to delete them. Assets found
+	// in the previous pass are kept in orphans, only assets found in two
+	// consecutive passes are reported & deleted.
+	orphanReconcilePeriod time.Duration
+	deleteOrphans         bool
+	orphans               map[string]bool
+
+	// The namespaces, all if empty, and label selector of the claims to serve
+	namespaces         []string
+	claimLabelSelector string
+
+	// Storage reserved against namespaces' StorageClass quotas by claims being
+	// provisioned for, keyed by PV name
+	quotaReservations map[string]quotaReservation
+	quotaMutex        *sync.Mutex
+
+	// How long Run waits after stopCh is closed for in-flight operations to
+	// finish or roll back. stopCtx is done once stopCh is closed, i.e. no new
+	// operations may start, and terminateCtx once they have finished or the
+	// grace period has run out.
+	shutdownGracePeriod time.Duration
+	stopCtx             context.Context
+	stop                context.CancelFunc
+	terminateCtx        context.Context
+	terminate           context.CancelFunc
+
 	hasRun     bool
 	hasRunLock *sync.Mutex
 }
 
+// LeaderElectionMode determines how controllers serving the same claims avoid
+// provisioning more than one volume per claim.
+type LeaderElectionMode string
+
+const (
+	// LeaderElectionPerClaim mode: every controller races to lock (lead) every
+	// claim by writing an annotation on it, and the leader of a claim
+	// provisions for it or resizes its volume. Every controller deletes
+	// volumes.
+	LeaderElectionPerClaim LeaderElectionMode = "PerClaim"
+	// LeaderElectionController mode: controllers elect a single leader using a
+	// ConfigMap or Endpoints lock, and only the leader provisions & deletes.
+	// Claims are not annotated and the others sit idle until they become leader.
+	LeaderElectionController LeaderElectionMode = "Controller"
+)
+
+// Backoff of failed claim & volume syncs, the same as goroutinemap's
+const (
+	initialDurationBeforeRetry = 500 * time.Millisecond
+	maxDurationBeforeRetry     = 2*time.Minute + 2*time.Second
+)
+
+// Default intervals to retry claims after a TransientError & to poll claims
+// after an InProgressError that doesn't set RetryAfter, and the interval to
+// retry claims whose namespace is over quota
+const (
+	transientErrorRetryInterval = 5 * time.Second
+	inProgressPollInterval      = 10 * time.Second
+	quotaExceededRetryInterval  = time.Minute
+)
+
 const (
 	// DefaultResyncPeriod is used when option function ResyncPeriod is omitted
 	DefaultResyncPeriod = 15 * time.Second
 	// DefaultExponentialBackOffOnError is used when option function ExponentialBackOffOnError is omitted
 	DefaultExponentialBackOffOnError = true
+	// DefaultThreadiness is used when option function Threadiness is omitted
+	DefaultThreadiness = 4
 	// DefaultCreateProvisionedPVRetryCount is used when option function CreateProvisionedPVRetryCount is omitted
 	DefaultCreateProvisionedPVRetryCount = 5
 	// DefaultCreateProvisionedPVInterval is used when option function CreateProvisionedPVInterval is omitted
@@ -153,6 +319,30 @@ const (
 	DefaultRetryPeriod = 2 * time.Second
 	// DefaultTermLimit is used when option function TermLimit is omitted
 	DefaultTermLimit = 30 * time.Second
+	// DefaultLeaderElectionMode is used when option function LeaderElection is omitted
+	DefaultLeaderElectionMode = LeaderElectionPerClaim
+	// DefaultLeaderElectionNamespace is used when option function LeaderElectionNamespace is omitted
+	DefaultLeaderElectionNamespace = "kube-system"
+	// DefaultLeaderElectionLockType is used when option function LeaderElectionLockType is omitted
+	DefaultLeaderElectionLockType = rl.ConfigMapsResourceLock
+	// DefaultMetricsPort is used when option function MetricsPort is omitted
+	DefaultMetricsPort = 0
+	// DefaultMetricsPath is used when option function MetricsPath is omitted
+	DefaultMetricsPath = "/metrics"
+	// DefaultHealthPort is used when option function HealthPort is omitted
+	DefaultHealthPort = 0
+	// DefaultDryRun is used when option function DryRun is omitted
+	DefaultDryRun = false
+	// DefaultAddFinalizer is used when option function AddFinalizer is omitted
+	DefaultAddFinalizer = false
+	// DefaultOrphanReconcilePeriod is used when option function OrphanReconcilePeriod is omitted
+	DefaultOrphanReconcilePeriod = 0
+	// DefaultDeleteOrphans is used when option function DeleteOrphans is omitted
+	DefaultDeleteOrphans = false
+	// DefaultClaimLabelSelector is used when option function ClaimLabelSelector is omitted
+	DefaultClaimLabelSelector = ""
+	// DefaultShutdownGracePeriod is used when option function ShutdownGracePeriod is omitted
+	DefaultShutdownGracePeriod = 25 * time.Second
 )
 
 var errRuntime = fmt.Errorf("cannot call option functions after controller has Run")
@@ -178,7 +368,23 @@ func ExponentialBackOffOnError(exponentialBackOffOnError bool) func(*ProvisionCo
 		if c.HasRun() {
 			return errRuntime
 		}
-		c.runningOperations = goroutinemap.NewGoRoutineMap(exponentialBackOffOnError)
+		c.exponentialBackOffOnError = exponentialBackOffOnError
+		return nil
+	}
+}
+
+// Threadiness is the number of claim and volume workers each to run, i.e. the
+// maximum number of concurrent provision and delete operations each. Defaults
+// to 4.
+func Threadiness(threadiness int) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		if threadiness < 1 {
+			return fmt.Errorf("threadiness must be at least 1, got %d", threadiness)
+		}
+		c.threadiness = threadiness
 		return nil
 	}
 }
@@ -264,7 +470,8 @@ func RetryPeriod(retryPeriod time.Duration) func(*ProvisionController) error {
 
 // TermLimit is the maximum duration that a leader may remain the leader
 // to complete the task before it must give up its leadership. 0 for forever
-// or indefinite. Defaults to 30 seconds.
+// or indefinite. Defaults to 30 seconds. Ignored in LeaderElectionController
+// mode, where the leader leads for as long as it can renew its lease.
 func TermLimit(termLimit time.Duration) func(*ProvisionController) error {
 	return func(c *ProvisionController) error {
 		if c.HasRun() {
@@ -275,6 +482,253 @@ func TermLimit(termLimit time.Duration) func(*ProvisionController) error {
 	}
 }
 
+// LeaderElection sets the LeaderElectionMode. Defaults to
+// LeaderElectionPerClaim.
+func LeaderElection(mode LeaderElectionMode) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		if mode != LeaderElectionPerClaim && mode != LeaderElectionController {
+			return fmt.Errorf("invalid leader election mode %q", mode)
+		}
+		c.leaderElectionMode = mode
+		return nil
+	}
+}
+
+// LeaderElectionNamespace is the namespace of the lock object in
+// LeaderElectionController mode. The lock object is named after the
+// provisioner. Defaults to "kube-system".
+func LeaderElectionNamespace(leaderElectionNamespace string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.leaderElectionNamespace = leaderElectionNamespace
+		return nil
+	}
+}
+
+// LeaderElectionLockType is the type of the lock object in
+// LeaderElectionController mode: "configmaps" or "endpoints". Defaults to
+// "configmaps".
+func LeaderElectionLockType(leaderElectionLockType string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		if leaderElectionLockType != rl.ConfigMapsResourceLock && leaderElectionLockType != rl.EndpointsResourceLock {
+			return fmt.Errorf("invalid leader election lock type %q", leaderElectionLockType)
+		}
+		c.leaderElectionLockType = leaderElectionLockType
+		return nil
+	}
+}
+
+// AdditionalProvisioner makes the controller also provision volumes for the
+// given provisioner name using the given Provisioner. Claims & volumes of every
+// name share the controller's informers, workers and leader election. May be
+// passed more than once, for different names.
+func AdditionalProvisioner(provisionerName string, provisioner Provisioner) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		if _, found := c.provisioners[provisionerName]; found {
+			return fmt.Errorf("provisioner %q already registered", provisionerName)
+		}
+		c.provisioners[provisionerName] = provisioner
+		return nil
+	}
+}
+
+// MetricsPort sets the port that metrics are served on. Defaults to 0, meaning
+// metrics are not served.
+func MetricsPort(metricsPort int) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.metricsPort = metricsPort
+		return nil
+	}
+}
+
+// MetricsPath sets the path that metrics are served on. Defaults to
+// "/metrics".
+func MetricsPath(metricsPath string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.metricsPath = metricsPath
+		return nil
+	}
+}
+
+// HealthPort sets the port that health checks are served on, at /healthz for
+// liveness & /readyz for readiness. If it is the same as MetricsPort, they are
+// served alongside metrics. Defaults to 0, i.e. they are not served.
+func HealthPort(healthPort int) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.healthPort = healthPort
+		return nil
+	}
+}
+
+// DryRun determines whether the controller runs in dry-run mode: it watches
+// claims and volumes and decides what to provision, delete and resize as
+// usual, but instead of calling the provisioner it only logs and emits events
+// describing what it would do, each event once per claim or volume. It never
+// modifies claims or volumes, nor takes part in leader election, so it can
+// run alongside the controllers actually serving the claims. Defaults to
+// false.
+func DryRun(dryRun bool) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.dryRun = dryRun
+		return nil
+	}
+}
+
+// AddFinalizer determines whether to add a finalizer to the PVs the controller
+// provisions. If a PV is deleted directly, e.g. with kubectl, the finalizer
+// keeps the PV object around until it is Released and the controller has
+// deleted its storage asset, if its reclaim policy is Delete, instead of
+// leaking the asset. The provisioner must be running for such PVs to be
+// deleted. Defaults to false.
+func AddFinalizer(addFinalizer bool) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.addFinalizer = addFinalizer
+		return nil
+	}
+}
+
+// OrphanReconcilePeriod is how often to look for storage assets whose PV no
+// longer exists, if the provisioner implements Lister. An asset is reported as
+// orphaned, and deleted if DeleteOrphans is set, once it has been found in two
+// consecutive passes, so the period should be longer than it takes to save a
+// PV for a provisioned asset. Defaults to 0, i.e. never.
+func OrphanReconcilePeriod(orphanReconcilePeriod time.Duration) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.orphanReconcilePeriod = orphanReconcilePeriod
+		return nil
+	}
+}
+
+// DeleteOrphans determines whether to delete orphaned storage assets found
+// every OrphanReconcilePeriod or only report them. Orphans are only deleted in
+// LeaderElectionController mode, by the leader, never by the racing
+// controllers of PerClaim mode. Defaults to false.
+func DeleteOrphans(deleteOrphans bool) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.deleteOrphans = deleteOrphans
+		return nil
+	}
+}
+
+// Namespaces restricts the controller to claims in the given namespaces, which
+// are listed & watched separately so the controller only needs RBAC
+// permissions on claims in them. Volumes bound to claims in other namespaces
+// are not deleted. Defaults to nil, i.e. all namespaces.
+func Namespaces(namespaces []string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.namespaces = namespaces
+		return nil
+	}
+}
+
+// ClaimLabelSelector restricts the controller to claims matching the given
+// label selector, e.g. "tenant=a". Only matching claims are cached. Defaults
+// to "", i.e. all claims.
+func ClaimLabelSelector(claimLabelSelector string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		if _, err := labels.Parse(claimLabelSelector); err != nil {
+			return err
+		}
+		c.claimLabelSelector = claimLabelSelector
+		return nil
+	}
+}
+
+// ShutdownGracePeriod is how long Run waits after its stopCh is closed for
+// in-flight provisions, deletes & resizes to finish, or roll back, before
+// returning. It should be shorter than the pod's termination grace period.
+// Defaults to 25s.
+func ShutdownGracePeriod(shutdownGracePeriod time.Duration) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.shutdownGracePeriod = shutdownGracePeriod
+		return nil
+	}
+}
+
+// ClaimsInformer sets the informer of PersistentVolumeClaims the controller
+// uses instead of creating its own, so that it can be shared with the
+// Provisioner or other controllers in the process. The caller must run the
+// informer. Option functions Namespaces and ClaimLabelSelector do not apply to
+// it, and its resync period is at least 1s even if ResyncPeriod is shorter.
+// Defaults to nil, i.e. the controller creates & runs its own.
+func ClaimsInformer(informer cache.SharedInformer) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.claimInformer = informer
+		return nil
+	}
+}
+
+// VolumesInformer sets the informer of PersistentVolumes the controller uses
+// instead of creating its own. The caller must run the informer. Defaults to
+// nil, i.e. the controller creates & runs its own.
+func VolumesInformer(informer cache.SharedInformer) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.volumeInformer = informer
+		return nil
+	}
+}
+
+// ClassesInformer sets the informer of StorageClasses the controller uses
+// instead of creating its own. It must be of storage/v1 StorageClasses for
+// Kubernetes 1.6+ and storage/v1beta1 ones before. The caller must run the
+// informer. Defaults to nil, i.e. the controller creates & runs its own.
+func ClassesInformer(informer cache.SharedInformer) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.classInformer = informer
+		return nil
+	}
+}
+
 // NewProvisionController creates a new provision controller
 func NewProvisionController(
 	client kubernetes.Interface,
@@ -300,18 +754,18 @@ func NewProvisionController(
 	controller := &ProvisionController{
 		client:                        client,
 		provisionerName:               provisionerName,
-		provisioner:                   provisioner,
+		provisioners:                  map[string]Provisioner{provisionerName: provisioner},
 		kubeVersion:                   utilversion.MustParseSemantic(kubeVersion),
 		identity:                      identity,
 		eventRecorder:                 eventRecorder,
 		resyncPeriod:                  DefaultResyncPeriod,
-		runningOperations:             goroutinemap.NewGoRoutineMap(DefaultExponentialBackOffOnError),
+		runningOperations:             goroutinemap.NewGoRoutineMap(false),
+		threadiness:                   DefaultThreadiness,
+		exponentialBackOffOnError:     DefaultExponentialBackOffOnError,
 		createProvisionedPVRetryCount: DefaultCreateProvisionedPVRetryCount,
 		createProvisionedPVInterval:   DefaultCreateProvisionedPVInterval,
 		failedProvisionThreshold:      DefaultFailedProvisionThreshold,
 		failedDeleteThreshold:         DefaultFailedDeleteThreshold,
-		failedProvisionStats:          make(map[types.UID]int),
-		failedDeleteStats:             make(map[types.UID]int),
 		failedProvisionStatsMutex:     &sync.Mutex{},
 		failedDeleteStatsMutex:        &sync.Mutex{},
 		leaseDuration:                 DefaultLeaseDuration,
@@ -320,6 +774,28 @@ func NewProvisionController(
 		termLimit:                     DefaultTermLimit,
 		leaderElectors:                make(map[types.UID]*leaderelection.LeaderElector),
 		leaderElectorsMutex:           &sync.Mutex{},
+		leaderElectionMode:            DefaultLeaderElectionMode,
+		leaderElectionNamespace:       DefaultLeaderElectionNamespace,
+		leaderElectionLockType:        DefaultLeaderElectionLockType,
+		leading:                       false,
+		leadingMutex:                  &sync.Mutex{},
+		metricsPort:                   DefaultMetricsPort,
+		metricsPath:                   DefaultMetricsPath,
+		healthPort:                    DefaultHealthPort,
+		dryRun:                        DefaultDryRun,
+		dryRunEvents:                  make(map[string]bool),
+		dryRunEventsMutex:             &sync.Mutex{},
+		addFinalizer:                  DefaultAddFinalizer,
+		orphanReconcilePeriod:         DefaultOrphanReconcilePeriod,
+		deleteOrphans:                 DefaultDeleteOrphans,
+		orphans:                       make(map[string]bool),
+		claimLabelSelector:            DefaultClaimLabelSelector,
+		quotaReservations:             make(map[string]quotaReservation),
+		quotaMutex:                    &sync.Mutex{},
+		classPolicies:                 make(map[string]*storageClassPolicy),
+		classPoliciesMutex:            &sync.Mutex{},
+		retryingKeysMutex:             &sync.Mutex{},
+		shutdownGracePeriod:           DefaultShutdownGracePeriod,
 		hasRun:                        false,
 		hasRunLock:                    &sync.Mutex{},
 	}
@@ -328,77 +804,97 @@ func NewProvisionController(
 		option(controller)
 	}
 
-	controller.claimSource = &cache.ListWatch{
-		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			return client.Core().PersistentVolumeClaims(v1.NamespaceAll).List(options)
-		},
-		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			return client.Core().PersistentVolumeClaims(v1.NamespaceAll).Watch(options)
-		},
-	}
-	controller.claims, controller.claimController = cache.NewInformer(
-		controller.claimSource,
-		&v1.PersistentVolumeClaim{},
-		controller.resyncPeriod,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc:    controller.addClaim,
-			UpdateFunc: controller.updateClaim,
-			DeleteFunc: nil,
-		},
-	)
+	controller.stopCtx, controller.stop = context.WithCancel(context.Background())
+	controller.terminateCtx, controller.terminate = context.WithCancel(context.Background())
 
-	controller.volumeSource = &cache.ListWatch{
-		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			return client.Core().PersistentVolumes().List(options)
-		},
-		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			return client.Core().PersistentVolumes().Watch(options)
-		},
+	controller.metrics = newControllerMetrics(controller)
+	controller.claimQueue = workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(initialDurationBeforeRetry, maxDurationBeforeRetry))
+	controller.volumeQueue = workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(initialDurationBeforeRetry, maxDurationBeforeRetry))
+	controller.retryingKeys = map[workqueue.Interface]map[string]bool{
+		controller.claimQueue:  make(map[string]bool),
+		controller.volumeQueue: make(map[string]bool),
 	}
-	controller.volumes, controller.volumeController = cache.NewInformer(
-		controller.volumeSource,
-		&v1.PersistentVolume{},
-		controller.resyncPeriod,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc:    nil,
-			UpdateFunc: controller.updateVolume,
-			DeleteFunc: nil,
-		},
-	)
 
-	controller.classes = cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
-	if controller.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.6.0")) {
-		controller.classSource = &cache.ListWatch{
-			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-				return client.StorageV1().StorageClasses().List(options)
-			},
-			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-				return client.StorageV1().StorageClasses().Watch(options)
-			},
-		}
-		controller.classReflector = cache.NewReflector(
-			controller.classSource,
-			&storage.StorageClass{},
-			controller.classes,
+	controller.claimSource = newClaimListWatch(client, controller.namespaces, controller.claimLabelSelector)
+	claimHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    controller.addClaim,
+		UpdateFunc: controller.updateClaim,
+		DeleteFunc: nil,
+	}
+	if controller.claimInformer != nil {
+		controller.claimInformer.AddEventHandlerWithResyncPeriod(claimHandler, controller.resyncPeriod)
+		controller.claims = controller.claimInformer.GetStore()
+	} else {
+		controller.claims, controller.claimController = cache.NewInformer(
+			controller.claimSource,
+			&v1.PersistentVolumeClaim{},
 			controller.resyncPeriod,
+			claimHandler,
 		)
+	}
+
+	volumeHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    nil,
+		UpdateFunc: controller.updateVolume,
+		DeleteFunc: nil,
+	}
+	if controller.volumeInformer != nil {
+		controller.volumeInformer.AddEventHandlerWithResyncPeriod(volumeHandler, controller.resyncPeriod)
+		controller.volumes = controller.volumeInformer.GetStore()
 	} else {
-		controller.classSource = &cache.ListWatch{
+		controller.volumeSource = &cache.ListWatch{
 			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-				return client.StorageV1beta1().StorageClasses().List(options)
+				return client.Core().PersistentVolumes().List(options)
 			},
 			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-				return client.StorageV1beta1().StorageClasses().Watch(options)
+				return client.Core().PersistentVolumes().Watch(options)
 			},
 		}
-		controller.classReflector = cache.NewReflector(
-			controller.classSource,
-			&storagebeta.StorageClass{},
-			controller.classes,
+		controller.volumes, controller.volumeController = cache.NewInformer(
+			controller.volumeSource,
+			&v1.PersistentVolume{},
 			controller.resyncPeriod,
+			volumeHandler,
 		)
 	}
 
+	if controller.classInformer != nil {
+		controller.classes = controller.classInformer.GetStore()
+	} else {
+		controller.classes = cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+		if controller.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.6.0")) {
+			controller.classSource = &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					return client.StorageV1().StorageClasses().List(options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					return client.StorageV1().StorageClasses().Watch(options)
+				},
+			}
+			controller.classReflector = cache.NewReflector(
+				controller.classSource,
+				&storage.StorageClass{},
+				controller.classes,
+				controller.resyncPeriod,
+			)
+		} else {
+			controller.classSource = &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					return client.StorageV1beta1().StorageClasses().List(options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					return client.StorageV1beta1().StorageClasses().Watch(options)
+				},
+			}
+			controller.classReflector = cache.NewReflector(
+				controller.classSource,
+				&storagebeta.StorageClass{},
+				controller.classes,
+				controller.resyncPeriod,
+			)
+		}
+	}
+
 	return controller
 }
 
@@ -408,10 +904,105 @@ func (ctrl *ProvisionController) Run(stopCh <-chan struct{}) {
 	ctrl.hasRunLock.Lock()
 	ctrl.hasRun = true
 	ctrl.hasRunLock.Unlock()
-	go ctrl.claimController.Run(stopCh)
-	go ctrl.volumeController.Run(stopCh)
-	go ctrl.classReflector.RunUntil(stopCh)
+	if ctrl.claimController != nil {
+		go ctrl.claimController.Run(stopCh)
+	}
+	if ctrl.volumeController != nil {
+		go ctrl.volumeController.Run(stopCh)
+	}
+	if ctrl.classReflector != nil {
+		go ctrl.classReflector.RunUntil(stopCh)
+	}
+	if ctrl.metricsPort > 0 {
+		go ctrl.serveMetrics()
+	}
+	if ctrl.healthPort > 0 && ctrl.healthPort != ctrl.metricsPort {
+		go ctrl.serveHealth()
+	}
+	if ctrl.dryRun {
+		glog.Infof("Running in dry-run mode, no volumes will be provisioned, deleted or resized")
+		// Act as the leader without taking the lock from the controllers
+		// actually serving the claims
+		ctrl.setLeading(true)
+	} else if ctrl.leaderElectionMode == LeaderElectionController {
+		// Keep leading until in-flight operations are done so that no other
+		// controller starts operating on the same claims & volumes
+		go ctrl.runLeaderElection(ctrl.terminateCtx.Done())
+	}
+	var workers sync.WaitGroup
+	runUntil := func(f func(), period time.Duration) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			wait.Until(f, period, stopCh)
+		}()
+	}
+	for i := 0; i < ctrl.threadiness; i++ {
+		runUntil(ctrl.runClaimWorker, time.Second)
+		runUntil(ctrl.runVolumeWorker, time.Second)
+	}
+	if ctrl.hasLister() && ctrl.orphanReconcilePeriod > 0 {
+		if ctrl.deleteOrphans && ctrl.leaderElectionMode != LeaderElectionController {
+			glog.Warningf("Orphaned storage assets are only deleted in %s leader election mode, they will only be reported", LeaderElectionController)
+		}
+		runUntil(ctrl.reconcileOrphans, ctrl.orphanReconcilePeriod)
+	}
 	<-stopCh
+	ctrl.shutDown(&workers)
+}
+
+// shutDown stops the controller from starting new operations and waits up to
+// shutdownGracePeriod for the workers & lock operations to finish theirs.
+func (ctrl *ProvisionController) shutDown(workers *sync.WaitGroup) {
+	glog.Infof("Stopping provisioner controller %s, waiting up to %v for in-flight operations", string(ctrl.identity), ctrl.shutdownGracePeriod)
+	ctrl.stop()
+	ctrl.claimQueue.ShutDown()
+	ctrl.volumeQueue.ShutDown()
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		ctrl.runningOperations.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		glog.Infof("Stopped provisioner controller %s", string(ctrl.identity))
+	case <-time.After(ctrl.shutdownGracePeriod):
+		glog.Warningf("Stopped provisioner controller %s with operations still in flight after %v", string(ctrl.identity), ctrl.shutdownGracePeriod)
+	}
+	ctrl.terminate()
+}
+
+// sleep waits for d, returning true, or until ctx is done, returning false
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ClaimLister returns the cache of PersistentVolumeClaims the controller
+// keeps, e.g. for the Provisioner to get claims from rather than watching them
+// itself. Objects in it must not be modified.
+func (ctrl *ProvisionController) ClaimLister() cache.Store {
+	return ctrl.claims
+}
+
+// VolumeLister returns the cache of PersistentVolumes the controller keeps.
+// Objects in it must not be modified.
+func (ctrl *ProvisionController) VolumeLister() cache.Store {
+	return ctrl.volumes
+}
+
+// ClassLister returns the cache of StorageClasses the controller keeps.
+// Objects in it must not be modified.
+func (ctrl *ProvisionController) ClassLister() cache.Store {
+	return ctrl.classes
 }
 
 // HasRun returns whether the controller has Run
@@ -435,43 +1026,18 @@ func (ctrl *ProvisionController) SetFailedDeleteThreshold(threshold int) {
 	ctrl.failedDeleteStatsMutex.Unlock()
 }
 
-// On add claim, check if the added claim should have a volume provisioned for
-// it and provision one if so.
+// On add claim, queue the claim to be synced.
 func (ctrl *ProvisionController) addClaim(obj interface{}) {
-	claim, ok := obj.(*v1.PersistentVolumeClaim)
-	if !ok {
-		glog.Errorf("Expected PersistentVolumeClaim but addClaim received %+v", obj)
-		return
-	}
-
-	if ctrl.shouldProvision(claim) {
-		ctrl.leaderElectorsMutex.Lock()
-		le, ok := ctrl.leaderElectors[claim.UID]
-		ctrl.leaderElectorsMutex.Unlock()
-		if ok && le.IsLeader() {
-			opName := fmt.Sprintf("provision-%s[%s]", claimToClaimKey(claim), string(claim.UID))
-			ctrl.scheduleOperation(opName, func() error {
-				err := ctrl.provisionClaimOperation(claim)
-				ctrl.updateProvisionStats(claim, err)
-				return err
-			})
-		} else {
-			opName := fmt.Sprintf("lock-provision-%s[%s]", claimToClaimKey(claim), string(claim.UID))
-			ctrl.scheduleOperation(opName, func() error {
-				ctrl.lockProvisionClaimOperation(claim)
-				return nil
-			})
-		}
-	}
+	ctrl.enqueueWork(ctrl.claimQueue, obj)
 }
 
-// On update claim, pass the new claim to addClaim. Updates occur at least every
-// resyncPeriod.
+// On update claim, queue the new claim to be synced. Updates occur at least
+// every resyncPeriod.
 func (ctrl *ProvisionController) updateClaim(oldObj, newObj interface{}) {
 	// If they are exactly the same it must be a forced resync (every
 	// resyncPeriod).
 	if reflect.DeepEqual(oldObj, newObj) {
-		ctrl.addClaim(newObj)
+		ctrl.resyncWork(ctrl.claimQueue, newObj)
 		return
 	}
 
@@ -494,47 +1060,292 @@ func (ctrl *ProvisionController) updateClaim(oldObj, newObj interface{}) {
 		return
 	}
 
-	if !skipAddClaim {
-		ctrl.addClaim(newObj)
+	if skipAddClaim {
+		return
 	}
-}
 
-// On update volume, check if the updated volume should be deleted and delete if
-// so. Updates occur at least every resyncPeriod.
-func (ctrl *ProvisionController) updateVolume(oldObj, newObj interface{}) {
-	volume, ok := newObj.(*v1.PersistentVolume)
-	if !ok {
-		glog.Errorf("Expected PersistentVolume but handler received %#v", newObj)
+	skipAddClaim, err = isOnlyFailureUpdate(oldClaim, newClaim, annProvisionFailures, annProvisionLastError)
+	if err != nil {
+		glog.Errorf("Error checking if only failures were updated in claim: %v", oldClaim)
 		return
 	}
 
-	if ctrl.shouldDelete(volume) {
-		opName := fmt.Sprintf("delete-%s[%s]", volume.Name, string(volume.UID))
-		ctrl.scheduleOperation(opName, func() error {
-			err := ctrl.deleteVolumeOperation(volume)
-			ctrl.updateDeleteStats(volume, err)
-			return err
-		})
+	if skipAddClaim {
+		return
 	}
-}
 
-// isOnlyRecordUpdate checks if the only update between the old & new claim is
-// the leader election record annotation.
-func (ctrl *ProvisionController) isOnlyRecordUpdate(oldClaim, newClaim *v1.PersistentVolumeClaim) (bool, error) {
-	old, err := ctrl.removeRecord(oldClaim)
+	skipAddClaim, err = isOnlyHandleUpdate(oldClaim, newClaim)
 	if err != nil {
-		return false, err
+		glog.Errorf("Error checking if only provision handle was updated in claim: %v", oldClaim)
+		return
 	}
-	new, err := ctrl.removeRecord(newClaim)
-	if err != nil {
-		return false, err
+
+	if !skipAddClaim {
+		ctrl.addClaim(newObj)
 	}
-	return reflect.DeepEqual(old, new), nil
 }
 
-// removeRecord returns a claim with its leader election record annotation and
-// ResourceVersion set blank
-func (ctrl *ProvisionController) removeRecord(claim *v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error) {
+// On update volume, queue the new volume to be synced. Updates occur at least
+// every resyncPeriod.
+func (ctrl *ProvisionController) updateVolume(oldObj, newObj interface{}) {
+	// If they are exactly the same it must be a forced resync (every
+	// resyncPeriod).
+	if reflect.DeepEqual(oldObj, newObj) {
+		ctrl.resyncWork(ctrl.volumeQueue, newObj)
+		return
+	}
+
+	skipUpdateVolume, err := isOnlyFailureUpdate(oldObj, newObj, annDeleteFailures, annDeleteLastError)
+	if err != nil {
+		glog.Errorf("Error checking if only failures were updated in volume: %v", oldObj)
+		return
+	}
+
+	if !skipUpdateVolume {
+		ctrl.enqueueWork(ctrl.volumeQueue, newObj)
+	}
+}
+
+// enqueueWork adds the key of obj to queue
+func (ctrl *ProvisionController) enqueueWork(queue workqueue.Interface, obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		glog.Errorf("Error getting key of %#v: %v", obj, err)
+		return
+	}
+	queue.Add(key)
+}
+
+// resyncWork adds the key of obj to queue on a forced resync, unless the key
+// is waiting out a retry delay: adding it would retry it early.
+func (ctrl *ProvisionController) resyncWork(queue workqueue.Interface, obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		glog.Errorf("Error getting key of %#v: %v", obj, err)
+		return
+	}
+	ctrl.retryingKeysMutex.Lock()
+	retrying := ctrl.retryingKeys[queue][key]
+	ctrl.retryingKeysMutex.Unlock()
+	if retrying {
+		glog.V(4).Infof("Not resyncing %q, it is waiting to be retried", key)
+		return
+	}
+	queue.Add(key)
+}
+
+// setRetrying records whether key is waiting out a retry delay in queue
+func (ctrl *ProvisionController) setRetrying(queue workqueue.Interface, key string, retrying bool) {
+	ctrl.retryingKeysMutex.Lock()
+	defer ctrl.retryingKeysMutex.Unlock()
+	if retrying {
+		ctrl.retryingKeys[queue][key] = true
+	} else {
+		delete(ctrl.retryingKeys[queue], key)
+	}
+}
+
+// runClaimWorker processes claim keys until the claim queue is shut down
+func (ctrl *ProvisionController) runClaimWorker() {
+	for ctrl.processNextWorkItem(ctrl.claimQueue, ctrl.syncClaimHandler) {
+	}
+}
+
+// runVolumeWorker processes volume keys until the volume queue is shut down
+func (ctrl *ProvisionController) runVolumeWorker() {
+	for ctrl.processNextWorkItem(ctrl.volumeQueue, ctrl.syncVolumeHandler) {
+	}
+}
+
+// processNextWorkItem syncs the next key in queue with syncHandler. If the
+// sync fails the key is re-queued with exponential backoff, if enabled,
+// otherwise it is retried only when the object is next updated or resynced.
+// Resyncs don't cut short the delay of a key waiting to be retried.
+// Returns false if the queue has been shut down.
+func (ctrl *ProvisionController) processNextWorkItem(queue workqueue.RateLimitingInterface, syncHandler func(key string) error) bool {
+	obj, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(obj)
+
+	// The controller is shutting down, leave the remaining keys be
+	if ctrl.stopCtx.Err() != nil {
+		return false
+	}
+
+	key, ok := obj.(string)
+	if !ok {
+		glog.Errorf("Expected string key in work queue but got %#v", obj)
+		queue.Forget(obj)
+		return true
+	}
+	ctrl.setRetrying(queue, key, false)
+
+	atomic.AddInt64(&ctrl.metrics.operationsInFlight, 1)
+	err := syncHandler(key)
+	atomic.AddInt64(&ctrl.metrics.operationsInFlight, -1)
+	switch e := err.(type) {
+	case nil:
+	case *FinalError:
+		glog.Errorf("Error syncing %q, will not retry: %v", key, err)
+		queue.Forget(key)
+		return true
+	case *TransientError:
+		glog.Errorf("Error syncing %q, will retry: %v", key, err)
+		queue.Forget(key)
+		ctrl.setRetrying(queue, key, true)
+		queue.AddAfter(key, retryInterval(e.RetryAfter, transientErrorRetryInterval))
+		return true
+	case *InProgressError:
+		glog.V(4).Infof("Syncing %q: %v", key, err)
+		queue.Forget(key)
+		ctrl.setRetrying(queue, key, true)
+		queue.AddAfter(key, retryInterval(e.RetryAfter, inProgressPollInterval))
+		return true
+	default:
+		glog.Errorf("Error syncing %q: %v", key, err)
+		if ctrl.exponentialBackOffOnError {
+			ctrl.setRetrying(queue, key, true)
+			queue.AddRateLimited(key)
+		} else {
+			queue.Forget(key)
+		}
+		return true
+	}
+
+	queue.Forget(key)
+	return true
+}
+
+// retryInterval returns retryAfter if set, otherwise defaultInterval
+func retryInterval(retryAfter, defaultInterval time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	return defaultInterval
+}
+
+// syncClaimHandler gets the claim with the given key from the cache and syncs
+// it. A claim that no longer exists needs no syncing.
+func (ctrl *ProvisionController) syncClaimHandler(key string) error {
+	obj, exists, err := ctrl.claims.GetByKey(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	claim, ok := obj.(*v1.PersistentVolumeClaim)
+	if !ok {
+		return fmt.Errorf("expected PersistentVolumeClaim but got %+v", obj)
+	}
+	return ctrl.syncClaim(claim)
+}
+
+// syncVolumeHandler gets the volume with the given key from the cache and
+// syncs it. A volume that no longer exists needs no syncing.
+func (ctrl *ProvisionController) syncVolumeHandler(key string) error {
+	obj, exists, err := ctrl.volumes.GetByKey(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	volume, ok := obj.(*v1.PersistentVolume)
+	if !ok {
+		return fmt.Errorf("expected PersistentVolume but got %+v", obj)
+	}
+	return ctrl.syncVolume(volume)
+}
+
+// syncClaim checks if the claim should have a volume provisioned for it and
+// provisions one if so, or if its volume should be resized and resizes it if
+// so. In LeaderElectionPerClaim mode, provisioning & resizing wait until this
+// controller has become the leader of the claim, which re-queues the claim.
+func (ctrl *ProvisionController) syncClaim(claim *v1.PersistentVolumeClaim) error {
+	if ctrl.leaderElectionMode == LeaderElectionController && !ctrl.isLeading() {
+		return nil
+	}
+
+	if ctrl.shouldProvision(claim) {
+		if ctrl.leadsClaim(claim) {
+			// The cache has the failures recorded by the last sync, saveClaim
+			// stores the claims it saves, so the latest claim is got only to
+			// record this attempt's failure
+			err := ctrl.provisionClaimOperation(claim)
+			ctrl.updateProvisionStats(claim, err)
+			return err
+		}
+		opName := fmt.Sprintf("lock-provision-%s[%s]", claimToClaimKey(claim), string(claim.UID))
+		ctrl.scheduleOperation(opName, func() error {
+			ctrl.lockClaimOperation(claim, ctrl.watchProvisioning)
+			return nil
+		})
+	} else if ctrl.shouldResize(claim) {
+		if ctrl.leadsClaim(claim) {
+			return ctrl.resizeVolumeOperation(claim)
+		}
+		opName := fmt.Sprintf("lock-resize-%s[%s]", claimToClaimKey(claim), string(claim.UID))
+		ctrl.scheduleOperation(opName, func() error {
+			ctrl.lockClaimOperation(claim, ctrl.watchResizing)
+			return nil
+		})
+	}
+	return nil
+}
+
+// leadsClaim returns whether this controller may provision for or resize the
+// claim. In LeaderElectionController mode this controller is the leader, there
+// is no need to lock the claim. In dry-run mode the claim must not be locked,
+// i.e. annotated.
+func (ctrl *ProvisionController) leadsClaim(claim *v1.PersistentVolumeClaim) bool {
+	if ctrl.dryRun || ctrl.leaderElectionMode == LeaderElectionController {
+		return true
+	}
+	ctrl.leaderElectorsMutex.Lock()
+	le, ok := ctrl.leaderElectors[claim.UID]
+	ctrl.leaderElectorsMutex.Unlock()
+	return ok && le.IsLeader()
+}
+
+// syncVolume checks if the volume should be deleted and deletes it if so
+func (ctrl *ProvisionController) syncVolume(volume *v1.PersistentVolume) error {
+	if ctrl.leaderElectionMode == LeaderElectionController && !ctrl.isLeading() {
+		return nil
+	}
+
+	if ctrl.shouldDelete(volume) {
+		// The cache has the failures recorded by the last sync, saveVolume
+		// stores the volumes it saves, so the latest volume is got only to
+		// record this attempt's failure
+		err := ctrl.deleteVolumeOperation(volume)
+		ctrl.updateDeleteStats(volume, err)
+		return err
+	} else if ctrl.shouldRemoveFinalizer(volume) {
+		return ctrl.removeFinalizer(volume)
+	}
+	return nil
+}
+
+// isOnlyRecordUpdate checks if the only update between the old & new claim is
+// the leader election record annotation.
+func (ctrl *ProvisionController) isOnlyRecordUpdate(oldClaim, newClaim *v1.PersistentVolumeClaim) (bool, error) {
+	old, err := ctrl.removeRecord(oldClaim)
+	if err != nil {
+		return false, err
+	}
+	new, err := ctrl.removeRecord(newClaim)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(old, new), nil
+}
+
+// removeRecord returns a claim with its leader election record annotation and
+// ResourceVersion set blank
+func (ctrl *ProvisionController) removeRecord(claim *v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error) {
 	clone, err := scheme.Scheme.DeepCopy(claim)
 	if err != nil {
 		return nil, fmt.Errorf("Error cloning claim: %v", err)
@@ -556,36 +1367,51 @@ func (ctrl *ProvisionController) removeRecord(claim *v1.PersistentVolumeClaim) (
 
 func (ctrl *ProvisionController) shouldProvision(claim *v1.PersistentVolumeClaim) bool {
 	ctrl.failedProvisionStatsMutex.Lock()
-	if failureCount, exists := ctrl.failedProvisionStats[claim.UID]; exists == true {
-		if failureCount >= ctrl.failedProvisionThreshold && ctrl.failedProvisionThreshold > 0 {
-			glog.Errorf("Exceeded failedProvisionThreshold threshold: %d, for claim %q, provisioner will not attempt retries for this claim", ctrl.failedProvisionThreshold, claimToClaimKey(claim))
-			ctrl.failedProvisionStatsMutex.Unlock()
-			return false
-		}
-	}
+	failedProvisionThreshold := ctrl.failedProvisionThreshold
 	ctrl.failedProvisionStatsMutex.Unlock()
+	if failureCount := getFailureCount(claim.Annotations, annProvisionFailures); failureCount >= failedProvisionThreshold && failedProvisionThreshold > 0 {
+		glog.Errorf("Exceeded failedProvisionThreshold threshold: %d, for claim %q, provisioner will not attempt retries for this claim until annotation %q is removed", failedProvisionThreshold, claimToClaimKey(claim), annProvisionFailures)
+		return false
+	}
+	if _, found := claim.Annotations[annProvisionFinalError]; found {
+		glog.Errorf("Provisioning for claim %q failed with a final error, provisioner will not attempt retries for this claim until annotation %q is removed", claimToClaimKey(claim), annProvisionFinalError)
+		return false
+	}
 
 	if claim.Spec.VolumeName != "" {
 		return false
 	}
 
-	// Kubernetes 1.5 provisioning with annStorageProvisioner
+	claimClass := helper.GetPersistentVolumeClaimClass(claim)
 	if provisioner, found := claim.Annotations[annStorageProvisioner]; found {
-		if provisioner == ctrl.provisionerName {
-			return true
+		// Kubernetes 1.5 provisioning with annStorageProvisioner
+		if _, ok := ctrl.provisioners[provisioner]; !ok {
+			return false
+		}
+	} else {
+		// Kubernetes 1.4 provisioning, evaluating class.Provisioner
+		provisioner, _, err := ctrl.getStorageClassFields(claimClass)
+		if err != nil {
+			glog.Errorf("Error getting claim %q's StorageClass's fields: %v", claimToClaimKey(claim), err)
+			return false
+		}
+		if _, ok := ctrl.provisioners[provisioner]; !ok {
+			return false
 		}
-		return false
 	}
 
-	// Kubernetes 1.4 provisioning, evaluating class.Provisioner
-	claimClass := helper.GetPersistentVolumeClaimClass(claim)
-	provisioner, _, err := ctrl.getStorageClassFields(claimClass)
-	if err != nil {
-		glog.Errorf("Error getting claim %q's StorageClass's fields: %v", claimToClaimKey(claim), err)
-		return false
-	}
-	if provisioner != ctrl.provisionerName {
-		return false
+	// With delayed binding, wait for the scheduler to select a node for the
+	// claim's pod to run on
+	if _, found := claim.Annotations[annSelectedNode]; !found {
+		policy, err := ctrl.getStorageClassPolicy(claimClass)
+		if err != nil {
+			glog.Errorf("Error getting claim %q's StorageClass's volume binding mode: %v", claimToClaimKey(claim), err)
+			return false
+		}
+		if policy.VolumeBindingMode == volumeBindingWaitForFirstConsumer {
+			glog.V(4).Infof("Claim %q is waiting for a node to be selected", claimToClaimKey(claim))
+			return false
+		}
 	}
 
 	return true
@@ -593,14 +1419,12 @@ func (ctrl *ProvisionController) shouldProvision(claim *v1.PersistentVolumeClaim
 
 func (ctrl *ProvisionController) shouldDelete(volume *v1.PersistentVolume) bool {
 	ctrl.failedDeleteStatsMutex.Lock()
-	if failureCount, exists := ctrl.failedDeleteStats[volume.UID]; exists == true {
-		if failureCount >= ctrl.failedDeleteThreshold && ctrl.failedDeleteThreshold > 0 {
-			glog.Errorf("Exceeded failedDeleteThreshold threshold: %d, for volume %q, provisioner will not attempt retries for this volume", ctrl.failedDeleteThreshold, volume.Name)
-			ctrl.failedDeleteStatsMutex.Unlock()
-			return false
-		}
-	}
+	failedDeleteThreshold := ctrl.failedDeleteThreshold
 	ctrl.failedDeleteStatsMutex.Unlock()
+	if failureCount := getFailureCount(volume.Annotations, annDeleteFailures); failureCount >= failedDeleteThreshold && failedDeleteThreshold > 0 {
+		glog.Errorf("Exceeded failedDeleteThreshold threshold: %d, for volume %q, provisioner will not attempt retries for this volume until annotation %q is removed", failedDeleteThreshold, volume.Name, annDeleteFailures)
+		return false
+	}
 
 	// In 1.5+ we delete only if the volume is in state Released. In 1.4 we must
 	// delete if the volume is in state Failed too.
@@ -622,19 +1446,81 @@ func (ctrl *ProvisionController) shouldDelete(volume *v1.PersistentVolume) bool
 		return false
 	}
 
-	if ann := volume.Annotations[annDynamicallyProvisioned]; ann != ctrl.provisionerName {
+	if _, ok := ctrl.provisioners[volume.Annotations[annDynamicallyProvisioned]]; !ok {
+		return false
+	}
+
+	if volume.Spec.ClaimRef != nil && !ctrl.isNamespaceInScope(volume.Spec.ClaimRef.Namespace) {
+		return false
+	}
+
+	if !ctrl.isVolumeLabelInScope(volume) {
+		return false
+	}
+
+	return true
+}
+
+// shouldRemoveFinalizer returns whether the given volume is being deleted and
+// has finalizerPV but its storage asset is not to be deleted, because its
+// reclaim policy is not Delete.
+func (ctrl *ProvisionController) shouldRemoveFinalizer(volume *v1.PersistentVolume) bool {
+	if volume.DeletionTimestamp == nil || !hasFinalizer(volume.Finalizers, finalizerPV) {
+		return false
+	}
+
+	if volume.Spec.PersistentVolumeReclaimPolicy == v1.PersistentVolumeReclaimDelete {
+		return false
+	}
+
+	if _, ok := ctrl.provisioners[volume.Annotations[annDynamicallyProvisioned]]; !ok {
+		return false
+	}
+
+	if volume.Spec.ClaimRef != nil && !ctrl.isNamespaceInScope(volume.Spec.ClaimRef.Namespace) {
+		return false
+	}
+
+	if !ctrl.isVolumeLabelInScope(volume) {
 		return false
 	}
 
 	return true
 }
 
-// lockProvisionClaimOperation wraps provisionClaimOperation. In case other
-// controllers are serving the same claims, to prevent them all from creating
-// volumes for a claim & racing to submit their PV, each controller creates a
-// LeaderElector to instead race for the leadership (lock), where only the
-// leader is tasked with provisioning & may try to do so
-func (ctrl *ProvisionController) lockProvisionClaimOperation(claim *v1.PersistentVolumeClaim) {
+// shouldResize returns whether the given claim is bound to a volume this
+// controller provisioned, the provisioner implements Resizer, and the claim
+// requests more storage than the volume's capacity.
+func (ctrl *ProvisionController) shouldResize(claim *v1.PersistentVolumeClaim) bool {
+	if claim.Spec.VolumeName == "" {
+		return false
+	}
+
+	volumeObj, found, err := ctrl.volumes.GetByKey(claim.Spec.VolumeName)
+	if err != nil || !found {
+		return false
+	}
+	volume, ok := volumeObj.(*v1.PersistentVolume)
+	if !ok {
+		return false
+	}
+
+	if _, ok := ctrl.provisioners[volume.Annotations[annDynamicallyProvisioned]].(Resizer); !ok {
+		return false
+	}
+
+	requested := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	return requested.Cmp(capacity) > 0
+}
+
+// lockClaimOperation wraps provisionClaimOperation & resizeVolumeOperation. In
+// case other controllers are serving the same claims, to prevent them all from
+// creating volumes for a claim & racing to submit their PV, or resizing its
+// volume at once, each controller creates a LeaderElector to instead race for
+// the leadership (lock), where only the leader is tasked with provisioning or
+// resizing & may try to do so. watchTask reports the results of the task.
+func (ctrl *ProvisionController) lockClaimOperation(claim *v1.PersistentVolumeClaim, watchTask func(*v1.PersistentVolumeClaim, chan struct{}) (<-chan bool, error)) {
 	stoppedLeading := false
 	rl := rl.ProvisionPVCLock{
 		PVCMeta: claim.ObjectMeta,
@@ -643,6 +1529,7 @@ func (ctrl *ProvisionController) lockProvisionClaimOperation(claim *v1.Persisten
 			Identity:      string(ctrl.identity),
 			EventRecorder: ctrl.eventRecorder,
 		},
+		PatchAnnotation: ctrl.hasVolumeMode(),
 	}
 	le, err := leaderelection.NewLeaderElector(leaderelection.Config{
 		Lock:          &rl,
@@ -652,12 +1539,10 @@ func (ctrl *ProvisionController) lockProvisionClaimOperation(claim *v1.Persisten
 		TermLimit:     ctrl.termLimit,
 		Callbacks: leaderelection.LeaderCallbacks{
 			OnStartedLeading: func(_ <-chan struct{}) {
-				opName := fmt.Sprintf("provision-%s[%s]", claimToClaimKey(claim), string(claim.UID))
-				ctrl.scheduleOperation(opName, func() error {
-					err := ctrl.provisionClaimOperation(claim)
-					ctrl.updateProvisionStats(claim, err)
-					return err
-				})
+				ctrl.metrics.leaderElectionTotal.Inc(ctrl.provisionerName)
+				// Now that this controller is the leader, sync the claim again
+				// to provision for it or resize its volume
+				ctrl.addClaim(claim)
 			},
 			OnStoppedLeading: func() {
 				stoppedLeading = true
@@ -665,84 +1550,451 @@ func (ctrl *ProvisionController) lockProvisionClaimOperation(claim *v1.Persisten
 		},
 	})
 	if err != nil {
-		glog.Errorf("Error creating LeaderElector, can't provision for claim %q: %v", claimToClaimKey(claim), err)
-		return
+		glog.Errorf("Error creating LeaderElector, can't provision for or resize claim %q: %v", claimToClaimKey(claim), err)
+		return
+	}
+
+	ctrl.leaderElectorsMutex.Lock()
+	ctrl.leaderElectors[claim.UID] = le
+	ctrl.leaderElectorsMutex.Unlock()
+
+	// To determine when to stop trying to acquire/renew the lock, watch for
+	// provisioning or resizing success/failure. (The leader could get the
+	// result of its operation but it has to watch anyway)
+	stopCh := make(chan struct{})
+	successCh, err := watchTask(claim, stopCh)
+	if err != nil {
+		glog.Errorf("Error watching for success, can't provision for or resize claim %q: %v", claimToClaimKey(claim), err)
+	}
+
+	// The controller stopping is like the task succeeding: the LeaderElector
+	// stops trying to acquire or renew the lock
+	task := make(chan bool)
+	go func() {
+		for {
+			var result bool
+			select {
+			case result = <-successCh:
+			case <-ctrl.stopCtx.Done():
+				result = true
+			}
+			select {
+			case task <- result:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	le.Run(task)
+
+	close(stopCh)
+
+	// If we were the leader and stopped, give others a chance to acquire
+	// (whether they exist & want to or not). Else, there must have been a
+	// success so just proceed.
+	if stoppedLeading {
+		sleep(ctrl.stopCtx, ctrl.leaseDuration+ctrl.retryPeriod)
+	}
+
+	ctrl.leaderElectorsMutex.Lock()
+	delete(ctrl.leaderElectors, claim.UID)
+	ctrl.leaderElectorsMutex.Unlock()
+}
+
+// runLeaderElection races, until stopCh is closed, to lead the controllers
+// sharing this controller's lock object. While leading, this controller
+// provisions & deletes volumes; otherwise it ignores claims & volumes.
+func (ctrl *ProvisionController) runLeaderElection(stopCh <-chan struct{}) {
+	lockName := strings.Replace(ctrl.provisionerName, "/", "-", -1)
+	lock, err := rl.New(ctrl.leaderElectionLockType, ctrl.leaderElectionNamespace, lockName, ctrl.client, rl.Config{
+		Identity:      string(ctrl.identity),
+		EventRecorder: ctrl.eventRecorder,
+	})
+	if err != nil {
+		glog.Fatalf("Error creating leader election lock: %v", err)
+	}
+
+	// The LeaderElector stops acquiring or renewing when its task is done:
+	// here the task is done only when the controller is stopped
+	task := make(chan bool, 1)
+	go func() {
+		<-stopCh
+		task <- true
+	}()
+
+	for {
+		le, err := leaderelection.NewLeaderElector(leaderelection.Config{
+			Lock:          lock,
+			LeaseDuration: ctrl.leaseDuration,
+			RenewDeadline: ctrl.renewDeadline,
+			RetryPeriod:   ctrl.retryPeriod,
+			TermLimit:     0,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(stop <-chan struct{}) {
+					glog.Infof("Became leader %s, provisioning and deleting", lock.Describe())
+					ctrl.metrics.leaderElectionTotal.Inc(ctrl.provisionerName)
+					ctrl.setLeading(true)
+					// Handle claims & volumes that were ignored while not leading
+					// rather than waiting for the next resync
+					for _, claim := range ctrl.claims.List() {
+						ctrl.addClaim(claim)
+					}
+					for _, volume := range ctrl.volumes.List() {
+						ctrl.updateVolume(volume, volume)
+					}
+					<-stop
+					ctrl.setLeading(false)
+				},
+				OnStoppedLeading: func() {
+					glog.Infof("Stopped leading %s", lock.Describe())
+				},
+			},
+		})
+		if err != nil {
+			glog.Fatalf("Error creating LeaderElector: %v", err)
+		}
+
+		le.Run(task)
+
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+	}
+}
+
+func (ctrl *ProvisionController) isLeading() bool {
+	ctrl.leadingMutex.Lock()
+	defer ctrl.leadingMutex.Unlock()
+	return ctrl.leading
+}
+
+func (ctrl *ProvisionController) setLeading(leading bool) {
+	ctrl.leadingMutex.Lock()
+	ctrl.leading = leading
+	ctrl.leadingMutex.Unlock()
+}
+
+// updateProvisionStats records the result of provisioning for the claim in its
+// annotations: on failure the failure count is incremented & the error saved,
+// on success they are removed. A FinalError is saved in annProvisionFinalError
+// instead, even if failedProvisionThreshold is not set.
+func (ctrl *ProvisionController) updateProvisionStats(claim *v1.PersistentVolumeClaim, err error) {
+	ctrl.failedProvisionStatsMutex.Lock()
+	failedProvisionThreshold := ctrl.failedProvisionThreshold
+	ctrl.failedProvisionStatsMutex.Unlock()
+
+	// Claims must not be modified in dry-run mode
+	if ctrl.dryRun {
+		return
+	}
+
+	switch err.(type) {
+	case nil:
+		if _, found := claim.Annotations[annProvisionFailures]; !found {
+			return
+		}
+	case *FinalError:
+		ctrl.recordProvisionFinalError(claim, err)
+		return
+	case *TransientError, *InProgressError:
+		// Neither counts towards failedProvisionThreshold
+		return
+	}
+
+	// Do not record the failed claim info when failedProvisionThreshold is not
+	// set
+	if failedProvisionThreshold <= 0 {
+		return
+	}
+
+	oldClaim, getErr := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Get(claim.Name, metav1.GetOptions{})
+	if getErr != nil {
+		glog.Errorf("Error getting claim %q to record provision failures: %v", claimToClaimKey(claim), getErr)
+		return
+	}
+	newClaim, copyErr := copyClaim(oldClaim)
+	if copyErr != nil {
+		glog.Errorf("Error recording provision failures on claim %q: %v", claimToClaimKey(claim), copyErr)
+		return
+	}
+	if !setFailureAnnotations(&newClaim.ObjectMeta, annProvisionFailures, annProvisionLastError, err) {
+		return
+	}
+	if _, updateErr := ctrl.saveClaim(oldClaim, newClaim); updateErr != nil {
+		glog.Errorf("Error recording provision failures on claim %q: %v", claimToClaimKey(claim), updateErr)
+	}
+}
+
+// dryRunEvent emits the event describing what a dry run would do to the
+// object, unless it was already emitted for the object: resyncs only log it.
+func (ctrl *ProvisionController) dryRunEvent(object runtime.Object, uid types.UID, reason, msg string) {
+	key := reason + "/" + string(uid)
+	ctrl.dryRunEventsMutex.Lock()
+	emitted := ctrl.dryRunEvents[key]
+	ctrl.dryRunEvents[key] = true
+	ctrl.dryRunEventsMutex.Unlock()
+	if !emitted {
+		ctrl.eventRecorder.Event(object, v1.EventTypeNormal, reason, msg)
+	}
+}
+
+// recordProvisionFinalError saves the FinalError provisioning for the claim
+// failed with in its annProvisionFinalError annotation: retrying won't help.
+func (ctrl *ProvisionController) recordProvisionFinalError(claim *v1.PersistentVolumeClaim, err error) {
+	oldClaim, getErr := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Get(claim.Name, metav1.GetOptions{})
+	if getErr != nil {
+		glog.Errorf("Error getting claim %q to record final provision error: %v", claimToClaimKey(claim), getErr)
+		return
+	}
+	newClaim, copyErr := copyClaim(oldClaim)
+	if copyErr != nil {
+		glog.Errorf("Error recording final provision error on claim %q: %v", claimToClaimKey(claim), copyErr)
+		return
+	}
+	metav1.SetMetaDataAnnotation(&newClaim.ObjectMeta, annProvisionFinalError, err.Error())
+	if _, updateErr := ctrl.saveClaim(oldClaim, newClaim); updateErr != nil {
+		glog.Errorf("Error recording final provision error on claim %q: %v", claimToClaimKey(claim), updateErr)
+	}
+}
+
+// updateDeleteStats records a failure to delete the volume in its
+// annotations: the failure count is incremented & the error saved.
+func (ctrl *ProvisionController) updateDeleteStats(volume *v1.PersistentVolume, err error) {
+	ctrl.failedDeleteStatsMutex.Lock()
+	failedDeleteThreshold := ctrl.failedDeleteThreshold
+	ctrl.failedDeleteStatsMutex.Unlock()
+
+	// Do not record the failed volume info when failedDeleteThreshold is not
+	// set or in dry-run mode. On success the volume is deleted so there is
+	// nothing to record.
+	if failedDeleteThreshold <= 0 || ctrl.dryRun || err == nil {
+		return
+	}
+
+	oldVolume, getErr := ctrl.client.Core().PersistentVolumes().Get(volume.Name, metav1.GetOptions{})
+	if getErr != nil {
+		glog.Errorf("Error getting volume %q to record delete failures: %v", volume.Name, getErr)
+		return
+	}
+	newVolume, copyErr := copyVolume(oldVolume)
+	if copyErr != nil {
+		glog.Errorf("Error recording delete failures on volume %q: %v", volume.Name, copyErr)
+		return
+	}
+	if !setFailureAnnotations(&newVolume.ObjectMeta, annDeleteFailures, annDeleteLastError, err) {
+		return
+	}
+	if _, updateErr := ctrl.saveVolume(oldVolume, newVolume); updateErr != nil {
+		glog.Errorf("Error recording delete failures on volume %q: %v", volume.Name, updateErr)
+	}
+}
+
+// getFailureCount returns the failure count recorded in annotation
+// failuresKey, or 0 if there is none
+func getFailureCount(annotations map[string]string, failuresKey string) int {
+	value, found := annotations[failuresKey]
+	if !found {
+		return 0
+	}
+	failureCount, err := strconv.Atoi(value)
+	if err != nil {
+		glog.Errorf("Error parsing annotation %s=%q: %v", failuresKey, value, err)
+		return 0
+	}
+	return failureCount
+}
+
+// setFailureAnnotations increments the failure count annotation & saves the
+// last error if err is not nil, else removes both. Returns whether the
+// annotations changed.
+func setFailureAnnotations(meta *metav1.ObjectMeta, failuresKey, lastErrorKey string, err error) bool {
+	if err == nil {
+		if _, found := meta.Annotations[failuresKey]; !found {
+			return false
+		}
+		delete(meta.Annotations, failuresKey)
+		delete(meta.Annotations, lastErrorKey)
+		return true
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[failuresKey] = strconv.Itoa(getFailureCount(meta.Annotations, failuresKey) + 1)
+	meta.Annotations[lastErrorKey] = err.Error()
+	return true
+}
+
+// isOnlyFailureUpdate checks if the only update between the old & new claim or
+// volume is the controller recording another failure in the failure
+// annotations. Such updates must not trigger a sync or failed operations would
+// be retried immediately, without backoff. Removing the failure annotations,
+// i.e. requesting a retry, is not such an update.
+func isOnlyFailureUpdate(oldObj, newObj interface{}, failuresKey, lastErrorKey string) (bool, error) {
+	old, oldFailureCount, err := removeFailures(oldObj, failuresKey, lastErrorKey)
+	if err != nil {
+		return false, err
 	}
+	new, newFailureCount, err := removeFailures(newObj, failuresKey, lastErrorKey)
+	if err != nil {
+		return false, err
+	}
+	return newFailureCount > oldFailureCount && reflect.DeepEqual(old, new), nil
+}
 
-	ctrl.leaderElectorsMutex.Lock()
-	ctrl.leaderElectors[claim.UID] = le
-	ctrl.leaderElectorsMutex.Unlock()
-
-	// To determine when to stop trying to acquire/renew the lock, watch for
-	// provisioning success/failure. (The leader could get the result of its
-	// operation but it has to watch anyway)
-	stopCh := make(chan struct{})
-	successCh, err := ctrl.watchProvisioning(claim, stopCh)
+// removeFailures returns a copy of the claim or volume with its failure
+// annotations and ResourceVersion removed, and its failure count
+func removeFailures(obj interface{}, failuresKey, lastErrorKey string) (interface{}, int, error) {
+	clone, err := scheme.Scheme.DeepCopy(obj)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error cloning object: %v", err)
+	}
+	objMeta, err := meta.Accessor(clone)
 	if err != nil {
-		glog.Errorf("Error watching for provisioning success, can't provision for claim %q: %v", claimToClaimKey(claim), err)
+		return nil, 0, err
 	}
 
-	le.Run(successCh)
+	annotations := objMeta.GetAnnotations()
+	failureCount := getFailureCount(annotations, failuresKey)
+	newAnnotations := make(map[string]string)
+	for k, v := range annotations {
+		if k != failuresKey && k != lastErrorKey {
+			newAnnotations[k] = v
+		}
+	}
+	objMeta.SetAnnotations(newAnnotations)
+	objMeta.SetResourceVersion("")
 
-	close(stopCh)
+	return clone, failureCount, nil
+}
 
-	// If we were the leader and stopped, give others a chance to acquire
-	// (whether they exist & want to or not). Else, there must have been a
-	// success so just proceed.
-	if stoppedLeading {
-		time.Sleep(ctrl.leaseDuration + ctrl.retryPeriod)
+// isOnlyHandleUpdate checks if the only update between the old & new claim is
+// the controller saving or removing the handle of an asynchronous provision.
+// Such updates must not trigger a sync or the provision would be polled
+// immediately instead of after its RetryAfter.
+func isOnlyHandleUpdate(oldClaim, newClaim *v1.PersistentVolumeClaim) (bool, error) {
+	if oldClaim.Annotations[annProvisionHandle] == newClaim.Annotations[annProvisionHandle] {
+		return false, nil
 	}
-
-	ctrl.leaderElectorsMutex.Lock()
-	delete(ctrl.leaderElectors, claim.UID)
-	ctrl.leaderElectorsMutex.Unlock()
+	old, err := removeHandle(oldClaim)
+	if err != nil {
+		return false, err
+	}
+	new, err := removeHandle(newClaim)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(old, new), nil
 }
 
-func (ctrl *ProvisionController) updateProvisionStats(claim *v1.PersistentVolumeClaim, err error) {
-	ctrl.failedProvisionStatsMutex.Lock()
-	defer ctrl.failedProvisionStatsMutex.Unlock()
+// removeHandle returns a copy of the claim with its provision handle
+// annotation and ResourceVersion removed
+func removeHandle(claim *v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error) {
+	clone, err := scheme.Scheme.DeepCopy(claim)
+	if err != nil {
+		return nil, fmt.Errorf("Error cloning claim: %v", err)
+	}
+	claimClone, ok := clone.(*v1.PersistentVolumeClaim)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected claim cast error: %v", claimClone)
+	}
+	delete(claimClone.Annotations, annProvisionHandle)
+	claimClone.ResourceVersion = ""
+	return claimClone, nil
+}
 
-	// Do not record the failed claim info when failedProvisionThreshold is not set
-	if ctrl.failedProvisionThreshold <= 0 {
-		return
+// provision calls Provision for the claim or, if an asynchronous provision was
+// started for it, ProvisionStatus, and saves or removes the provision's handle
+// in the claim's annotations as it starts or finishes.
+func (ctrl *ProvisionController) provision(claim *v1.PersistentVolumeClaim, provisioner Provisioner, options VolumeOptions) (*v1.PersistentVolume, error) {
+	asyncProvisioner, async := provisioner.(AsyncProvisioner)
+	handle, found := claim.Annotations[annProvisionHandle]
+
+	var volume *v1.PersistentVolume
+	var err error
+	if async && found {
+		glog.V(4).Infof("provisionClaimOperation [%s]: polling provision %q", claimToClaimKey(claim), handle)
+		volume, err = asyncProvisioner.ProvisionStatus(handle, options)
+	} else {
+		volume, err = ctrl.adoptVolume(claim, provisioner, options)
+		if err == nil && volume == nil {
+			if options.DataSource != nil {
+				glog.V(4).Infof("provisionClaimOperation [%s]: cloning claim %q", claimToClaimKey(claim), options.DataSource.PVC.Name)
+				volume, err = provisioner.(Cloner).Clone(options)
+			} else {
+				volume, err = provisioner.Provision(options)
+			}
+		}
 	}
 
-	if err != nil {
-		if failureCount, exists := ctrl.failedProvisionStats[claim.UID]; exists == true {
-			failureCount = failureCount + 1
-			ctrl.failedProvisionStats[claim.UID] = failureCount
-		} else {
-			ctrl.failedProvisionStats[claim.UID] = 1
+	newHandle := ""
+	if ierr, ok := err.(*InProgressError); ok && async {
+		newHandle = ierr.Handle
+		if newHandle == "" {
+			// Still polling the same provision
+			newHandle = handle
+		}
+	}
+	if newHandle != handle {
+		if updateErr := ctrl.setProvisionHandle(claim, newHandle); updateErr != nil {
+			if newHandle == "" {
+				// The handle of a finished provision is ignored on the next
+				// sync if its PV exists, otherwise its status is polled again
+				glog.Errorf("Error removing provision handle from claim %q: %v", claimToClaimKey(claim), updateErr)
+			} else {
+				return nil, fmt.Errorf("error saving provision handle %q: %v", newHandle, updateErr)
+			}
 		}
-	} else {
-		delete(ctrl.failedProvisionStats, claim.UID)
 	}
-}
 
-func (ctrl *ProvisionController) updateDeleteStats(volume *v1.PersistentVolume, err error) {
-	ctrl.failedDeleteStatsMutex.Lock()
-	defer ctrl.failedDeleteStatsMutex.Unlock()
+	return volume, err
+}
 
-	// Do not record the failed volume info when failedDeleteThreshold is not set
-	if ctrl.failedDeleteThreshold <= 0 {
-		return
+// adoptVolume returns a PV object for the claim's storage asset if the
+// provisioner implements Exister and the asset already exists, otherwise nil
+func (ctrl *ProvisionController) adoptVolume(claim *v1.PersistentVolumeClaim, provisioner Provisioner, options VolumeOptions) (*v1.PersistentVolume, error) {
+	exister, ok := provisioner.(Exister)
+	if !ok {
+		return nil, nil
+	}
+	volume, err := exister.Exists(options)
+	if err != nil {
+		return nil, fmt.Errorf("error checking if storage asset %q exists: %v", options.AssetName, err)
+	}
+	if volume != nil {
+		glog.Infof("storage asset for claim %q already exists, adopting it", claimToClaimKey(claim))
 	}
+	return volume, nil
+}
 
+// setProvisionHandle saves handle in the claim's annotations or, if it is
+// empty, removes the claim's handle
+func (ctrl *ProvisionController) setProvisionHandle(claim *v1.PersistentVolumeClaim, handle string) error {
+	oldClaim, err := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Get(claim.Name, metav1.GetOptions{})
 	if err != nil {
-		if failureCount, exists := ctrl.failedDeleteStats[volume.UID]; exists == true {
-			failureCount = failureCount + 1
-			ctrl.failedDeleteStats[volume.UID] = failureCount
-		} else {
-			ctrl.failedDeleteStats[volume.UID] = 1
-		}
+		return err
+	}
+	newClaim, err := copyClaim(oldClaim)
+	if err != nil {
+		return err
+	}
+	if handle == "" {
+		delete(newClaim.Annotations, annProvisionHandle)
 	} else {
-		delete(ctrl.failedDeleteStats, volume.UID)
+		if newClaim.Annotations == nil {
+			newClaim.Annotations = make(map[string]string)
+		}
+		newClaim.Annotations[annProvisionHandle] = handle
 	}
+	_, err = ctrl.saveClaim(oldClaim, newClaim)
+	return err
 }
 
 // provisionClaimOperation attempts to provision a volume for the given claim.
-// Returns an error for use by goroutinemap when expbackoff is enabled: if nil,
-// the operation is deleted, else the operation may be retried with expbackoff.
+// Returns an error for use by the claim queue when expbackoff is enabled: if
+// nil, the claim is forgotten, else the claim may be retried with expbackoff.
 func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVolumeClaim) error {
 	// Most code here is identical to that found in controller.go of kube's PV controller...
 	claimClass := helper.GetPersistentVolumeClaimClass(claim)
@@ -767,31 +2019,122 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 		return nil
 	}
 
-	provisioner, parameters, err := ctrl.getStorageClassFields(claimClass)
+	provisionerName, parameters, err := ctrl.getStorageClassFields(claimClass)
 	if err != nil {
 		glog.Errorf("Error getting claim %q's StorageClass's fields: %v", claimToClaimKey(claim), err)
 		return nil
 	}
-	if provisioner != ctrl.provisionerName {
+	provisioner, ok := ctrl.provisioners[provisionerName]
+	if !ok {
 		// class.Provisioner has either changed since shouldProvision() or
 		// annDynamicallyProvisioned contains different provisioner than
 		// class.Provisioner.
-		glog.Errorf("Unknown provisioner %q requested in claim %q's StorageClass %q", provisioner, claimToClaimKey(claim), claimClass)
+		glog.Errorf("Unknown provisioner %q requested in claim %q's StorageClass %q", provisionerName, claimToClaimKey(claim), claimClass)
+		return nil
+	}
+
+	// Whether the volume's PV was saved or its asynchronous provision is in
+	// progress, in which case its storage stays reserved against the quota
+	// until the PV or the claim's provision handle is in the cache
+	saved, inProgress := false, false
+	quota, parameters, err := parseQuota(parameters)
+	if err != nil {
+		strerr := fmt.Sprintf("Error parsing StorageClass %q's quota: %v", claimClass, err)
+		glog.Errorf("Error parsing claim %q's StorageClass's quota: %v", claimToClaimKey(claim), err)
+		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
+		return &FinalError{Reason: strerr}
+	}
+	// An asynchronous provision in progress was already within quota
+	if _, found := claim.Annotations[annProvisionHandle]; !found {
+		if err = ctrl.reserveQuota(claim, claimClass, pvName, quota); err != nil {
+			strerr := fmt.Sprintf("Quota exceeded, not provisioning volume for claim %s: %v", claimToClaimKey(claim), err)
+			glog.Error(strerr)
+			ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
+			return &TransientError{Reason: strerr, RetryAfter: quotaExceededRetryInterval}
+		}
+	}
+	defer func() {
+		ctrl.releaseQuota(pvName, saved || inProgress)
+	}()
+
+	policy, err := ctrl.getStorageClassPolicy(claimClass)
+	if err != nil {
+		glog.Errorf("Error getting claim %q's StorageClass's reclaim policy and mount options: %v", claimToClaimKey(claim), err)
+		return err
+	}
+
+	// With delayed binding, shouldProvision waited for the scheduler to
+	// select a node, so provision a volume accessible from it
+	var selectedNode *v1.Node
+	if nodeName, found := claim.Annotations[annSelectedNode]; found {
+		selectedNode, err = ctrl.client.Core().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			glog.Errorf("Error getting claim %q's selected node %q: %v", claimToClaimKey(claim), nodeName, err)
+			return err
+		}
+	}
+
+	selectorRequirements, err := getSelectorRequirements(claim)
+	if err != nil {
+		glog.Errorf("Error parsing claim %q's selector: %v", claimToClaimKey(claim), err)
 		return nil
 	}
 
+	volumeMode, err := ctrl.getClaimVolumeMode(claim)
+	if err != nil {
+		glog.Errorf("Error getting claim %q's volume mode: %v", claimToClaimKey(claim), err)
+		return err
+	}
+	if volumeMode == PersistentVolumeBlock && !supportsBlock(provisioner) {
+		strerr := fmt.Sprintf("Provisioner %q does not support block volumes, requested by claim %s", provisionerName, claimToClaimKey(claim))
+		glog.Error(strerr)
+		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
+		return &FinalError{Reason: strerr}
+	}
+
+	dataSource, err := ctrl.getDataSource(claim, provisionerName, provisioner)
+	if err != nil {
+		strerr := fmt.Sprintf("Error getting data source of claim %s: %v", claimToClaimKey(claim), err)
+		glog.Error(strerr)
+		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
+		if _, ok := err.(*FinalError); ok {
+			return &FinalError{Reason: strerr}
+		}
+		return err
+	}
+
 	options := VolumeOptions{
-		// TODO SHOULD be set to `Delete` unless user manually congiures other reclaim policy.
-		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
-		PVName:     pvName,
-		PVC:        claim,
-		Parameters: parameters,
+		PersistentVolumeReclaimPolicy: policy.ReclaimPolicy,
+		PVName:                        pvName,
+		AssetName:                     "kubernetes-dynamic-" + pvName,
+		PVC:                           claim,
+		Parameters:                    parameters,
+		MountOptions:                  policy.MountOptions,
+		SelectorRequirements:          selectorRequirements,
+		SelectedNode:                  selectedNode,
+		AllowedTopologies:             topologySelectorTermsToNodeSelectorTerms(policy.AllowedTopologies),
+		VolumeMode:                    volumeMode,
+		DataSource:                    dataSource,
+	}
+
+	if ctrl.dryRun {
+		msg := fmt.Sprintf("Dry run: would provision volume %s with StorageClass %q, parameters %v, reclaim policy %s", pvName, claimClass, parameters, policy.ReclaimPolicy)
+		glog.Infof("%s for claim %q", msg, claimToClaimKey(claim))
+		ctrl.dryRunEvent(claim, claim.UID, "ProvisioningDryRun", msg)
+		return nil
 	}
 
 	ctrl.eventRecorder.Event(claim, v1.EventTypeNormal, "Provisioning", fmt.Sprintf("External provisioner is provisioning volume for claim %q", claimToClaimKey(claim)))
 
-	volume, err = ctrl.provisioner.Provision(options)
+	startTime := time.Now()
+	volume, err = ctrl.provision(claim, provisioner, options)
 	if err != nil {
+		if _, ok := err.(*InProgressError); ok {
+			inProgress = true
+			glog.V(4).Infof("provisionClaimOperation [%s]: %v", claimToClaimKey(claim), err)
+			return err
+		}
+		ctrl.metrics.provisionFailedTotal.Inc(provisionerName, claimClass)
 		strerr := fmt.Sprintf("Failed to provision volume with StorageClass %q: %v", claimClass, err)
 		glog.Errorf("Failed to provision volume for claim %q with StorageClass %q: %v", claimToClaimKey(claim), claimClass, err)
 		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
@@ -800,27 +2143,59 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 
 	glog.Infof("volume %q for claim %q created", volume.Name, claimToClaimKey(claim))
 
+	// Label the volume so the claim's selector matches it. If it still
+	// doesn't, the PV controller would never bind it to the claim.
+	matches, err := setSelectorLabels(volume, claim, selectorRequirements)
+	if err == nil && !matches {
+		err = fmt.Errorf("volume labels %v do not match claim selector", volume.Labels)
+	}
+	if err != nil {
+		ctrl.metrics.provisionFailedTotal.Inc(provisionerName, claimClass)
+		strerr := fmt.Sprintf("Error labeling provisioned volume for claim %s: %v. Deleting the volume.", claimToClaimKey(claim), err)
+		glog.Error(strerr)
+		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
+		ctrl.deleteProvisionedVolume(claim, provisioner, volume)
+		return err
+	}
+
 	// Set ClaimRef and the PV controller will bind and set annBoundByController for us
 	volume.Spec.ClaimRef = claimRef
 
-	metav1.SetMetaDataAnnotation(&volume.ObjectMeta, annDynamicallyProvisioned, ctrl.provisionerName)
+	// Honor the class's reclaim policy & mount options regardless of what the
+	// provisioner set
+	volume.Spec.PersistentVolumeReclaimPolicy = policy.ReclaimPolicy
+	if len(policy.MountOptions) > 0 {
+		metav1.SetMetaDataAnnotation(&volume.ObjectMeta, annMountOptions, strings.Join(policy.MountOptions, ","))
+	}
+
+	metav1.SetMetaDataAnnotation(&volume.ObjectMeta, annDynamicallyProvisioned, provisionerName)
+	if ctrl.claimLabelSelector != "" {
+		metav1.SetMetaDataAnnotation(&volume.ObjectMeta, annClaimLabels, ctrl.getScopeLabels(claim))
+	}
 	if ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.6.0")) {
 		volume.Spec.StorageClassName = claimClass
 	} else {
 		metav1.SetMetaDataAnnotation(&volume.ObjectMeta, annClass, claimClass)
 	}
 
+	if ctrl.addFinalizer {
+		volume.Finalizers = append(volume.Finalizers, finalizerPV)
+	}
+
 	// Try to create the PV object several times
 	for i := 0; i < ctrl.createProvisionedPVRetryCount; i++ {
 		glog.V(4).Infof("provisionClaimOperation [%s]: trying to save volume %s", claimToClaimKey(claim), volume.Name)
-		if _, err = ctrl.client.Core().PersistentVolumes().Create(volume); err == nil {
+		if _, err = ctrl.createVolume(volume, volumeMode); err == nil {
 			// Save succeeded.
 			glog.Infof("volume %q for claim %q saved", volume.Name, claimToClaimKey(claim))
 			break
 		}
-		// Save failed, try again after a while.
+		// Save failed, try again after a while. If the controller is
+		// stopping, roll back now rather than risk running out of time.
 		glog.Infof("failed to save volume %q for claim %q: %v", volume.Name, claimToClaimKey(claim), err)
-		time.Sleep(ctrl.createProvisionedPVInterval)
+		if !sleep(ctrl.stopCtx, ctrl.createProvisionedPVInterval) {
+			break
+		}
 	}
 
 	if err != nil {
@@ -828,29 +2203,16 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 		// but we don't have appropriate PV object for it.
 		// Emit some event here and try to delete the storage asset several
 		// times.
+		ctrl.metrics.provisionFailedTotal.Inc(provisionerName, claimClass)
 		strerr := fmt.Sprintf("Error creating provisioned PV object for claim %s: %v. Deleting the volume.", claimToClaimKey(claim), err)
 		glog.Error(strerr)
 		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
-
-		for i := 0; i < ctrl.createProvisionedPVRetryCount; i++ {
-			if err = ctrl.provisioner.Delete(volume); err == nil {
-				// Delete succeeded
-				glog.V(4).Infof("provisionClaimOperation [%s]: cleaning volume %s succeeded", claimToClaimKey(claim), volume.Name)
-				break
-			}
-			// Delete failed, try again after a while.
-			glog.Infof("failed to delete volume %q: %v", volume.Name, err)
-			time.Sleep(ctrl.createProvisionedPVInterval)
-		}
-
-		if err != nil {
-			// Delete failed several times. There is an orphaned volume and there
-			// is nothing we can do about it.
-			strerr := fmt.Sprintf("Error cleaning provisioned volume for claim %s: %v. Please delete manually.", claimToClaimKey(claim), err)
-			glog.Error(strerr)
-			ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningCleanupFailed", strerr)
-		}
+		ctrl.deleteProvisionedVolume(claim, provisioner, volume)
 	} else {
+		saved = true
+		ctrl.metrics.provisionTotal.Inc(provisionerName, claimClass)
+		ctrl.metrics.recordSuccess()
+		ctrl.metrics.provisionDuration.Observe(time.Since(startTime).Seconds(), provisionerName, claimClass)
 		glog.Infof("volume %q provisioned for claim %q", volume.Name, claimToClaimKey(claim))
 		msg := fmt.Sprintf("Successfully provisioned volume %s", volume.Name)
 		ctrl.eventRecorder.Event(claim, v1.EventTypeNormal, "ProvisioningSucceeded", msg)
@@ -859,12 +2221,69 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 	return nil
 }
 
+// deleteProvisionedVolume tries several times to delete the storage asset of a
+// volume that was provisioned for the claim but can't be used, e.g. because
+// its PV object couldn't be saved.
+func (ctrl *ProvisionController) deleteProvisionedVolume(claim *v1.PersistentVolumeClaim, provisioner Provisioner, volume *v1.PersistentVolume) {
+	var err error
+	for i := 0; i < ctrl.createProvisionedPVRetryCount; i++ {
+		if err = provisioner.Delete(volume); err == nil {
+			// Delete succeeded
+			glog.V(4).Infof("provisionClaimOperation [%s]: cleaning volume %s succeeded", claimToClaimKey(claim), volume.Name)
+			break
+		}
+		// Delete failed, try again after a while, unless the controller's
+		// shutdown grace period has run out.
+		glog.Infof("failed to delete volume %q: %v", volume.Name, err)
+		if !sleep(ctrl.terminateCtx, ctrl.createProvisionedPVInterval) {
+			break
+		}
+	}
+
+	if err != nil {
+		// Delete failed several times. There is an orphaned volume and there
+		// is nothing we can do about it.
+		strerr := fmt.Sprintf("Error cleaning provisioned volume for claim %s: %v. Please delete manually.", claimToClaimKey(claim), err)
+		glog.Error(strerr)
+		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningCleanupFailed", strerr)
+	}
+}
+
 // watchProvisioning returns a channel to which it sends the results of all
 // provisioning attempts for the given claim. The PVC being modified to no
 // longer need provisioning is considered a success.
 func (ctrl *ProvisionController) watchProvisioning(claim *v1.PersistentVolumeClaim, stopChannel chan struct{}) (<-chan bool, error) {
+	return ctrl.watchClaimTask(claim, stopChannel, "ProvisioningFailed", "ProvisioningSucceeded", func(claim *v1.PersistentVolumeClaim) bool {
+		if claim.Spec.VolumeName != "" {
+			return true
+		}
+		if !ctrl.shouldProvision(claim) {
+			glog.Infof("claim %s/%s was modified to not ask for this provisioner", claim.Namespace, claim.Name)
+			return true
+		}
+		return false
+	})
+}
+
+// watchResizing returns a channel to which it sends the results of all
+// resizing attempts for the given claim's volume. The PVC's capacity reaching
+// its request, or its request being lowered to its capacity, is considered a
+// success.
+func (ctrl *ProvisionController) watchResizing(claim *v1.PersistentVolumeClaim, stopChannel chan struct{}) (<-chan bool, error) {
+	return ctrl.watchClaimTask(claim, stopChannel, "VolumeResizeFailed", "VolumeResizeSuccessful", func(claim *v1.PersistentVolumeClaim) bool {
+		requested := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+		capacity := claim.Status.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		return requested.Cmp(capacity) <= 0
+	})
+}
+
+// watchClaimTask returns a channel to which it sends the results of all
+// attempts at a task for the given claim: false for failReason events, true
+// for successReason events or when done returns true for the modified PVC.
+// The PVC being deleted is considered a success.
+func (ctrl *ProvisionController) watchClaimTask(claim *v1.PersistentVolumeClaim, stopChannel chan struct{}, failReason, successReason string, done func(*v1.PersistentVolumeClaim) bool) (<-chan bool, error) {
 	stopWatchPVC := make(chan struct{})
-	pvcCh, err := ctrl.watchPVC(claim, stopWatchPVC)
+	pvcCh, err := ctrl.watchPVC(claim, stopWatchPVC, failReason, successReason)
 	if err != nil {
 		glog.Infof("cannot start watcher for PVC %s/%s: %v", claim.Namespace, claim.Name, err)
 		return nil, err
@@ -889,10 +2308,7 @@ func (ctrl *ProvisionController) watchProvisioning(claim *v1.PersistentVolumeCla
 					glog.V(4).Infof("claim update received: %s %s/%s %s", event.Type, claim.Namespace, claim.Name, claim.Status.Phase)
 					switch event.Type {
 					case watch.Added, watch.Modified:
-						if claim.Spec.VolumeName != "" {
-							successCh <- true
-						} else if !ctrl.shouldProvision(claim) {
-							glog.Infof("claim %s/%s was modified to not ask for this provisioner", claim.Namespace, claim.Name)
+						if done(claim) {
 							successCh <- true
 						}
 
@@ -909,9 +2325,9 @@ func (ctrl *ProvisionController) watchProvisioning(claim *v1.PersistentVolumeCla
 					// Event received
 					claimEvent := event.Object.(*v1.Event)
 					glog.V(4).Infof("claim event received: %s %s/%s %s/%s %s", event.Type, claimEvent.Namespace, claimEvent.Name, claimEvent.InvolvedObject.Namespace, claimEvent.InvolvedObject.Name, claimEvent.Reason)
-					if claimEvent.Reason == "ProvisioningSucceeded" {
+					if claimEvent.Reason == successReason {
 						successCh <- true
-					} else if claimEvent.Reason == "ProvisioningFailed" {
+					} else if claimEvent.Reason == failReason {
 						successCh <- false
 					}
 				}
@@ -922,9 +2338,9 @@ func (ctrl *ProvisionController) watchProvisioning(claim *v1.PersistentVolumeCla
 	return successCh, nil
 }
 
-// watchPVC returns a watch on the given PVC and ProvisioningFailed &
-// ProvisioningSucceeded events involving it
-func (ctrl *ProvisionController) watchPVC(claim *v1.PersistentVolumeClaim, stopChannel chan struct{}) (<-chan watch.Event, error) {
+// watchPVC returns a watch on the given PVC and the events with the given
+// failure & success reasons involving it
+func (ctrl *ProvisionController) watchPVC(claim *v1.PersistentVolumeClaim, stopChannel chan struct{}, failReason, successReason string) (<-chan watch.Event, error) {
 	options := metav1.ListOptions{
 		FieldSelector:   "metadata.name=" + claim.Name,
 		Watch:           true,
@@ -936,13 +2352,13 @@ func (ctrl *ProvisionController) watchPVC(claim *v1.PersistentVolumeClaim, stopC
 		return nil, err
 	}
 
-	failWatch, err := ctrl.getPVCEventWatch(claim, v1.EventTypeWarning, "ProvisioningFailed")
+	failWatch, err := ctrl.getPVCEventWatch(claim, v1.EventTypeWarning, failReason)
 	if err != nil {
 		pvcWatch.Stop()
 		return nil, err
 	}
 
-	successWatch, err := ctrl.getPVCEventWatch(claim, v1.EventTypeNormal, "ProvisioningSucceeded")
+	successWatch, err := ctrl.getPVCEventWatch(claim, v1.EventTypeNormal, successReason)
 	if err != nil {
 		failWatch.Stop()
 		pvcWatch.Stop()
@@ -1028,22 +2444,56 @@ func (ctrl *ProvisionController) deleteVolumeOperation(volume *v1.PersistentVolu
 		return nil
 	}
 
-	err = ctrl.provisioner.Delete(volume)
+	provisionerName := newVolume.Annotations[annDynamicallyProvisioned]
+	volumeClass := helper.GetPersistentVolumeClass(volume)
+	if ctrl.dryRun {
+		msg := fmt.Sprintf("Dry run: would delete volume %s with StorageClass %q", volume.Name, volumeClass)
+		glog.Info(msg)
+		ctrl.dryRunEvent(volume, volume.UID, "VolumeDeleteDryRun", msg)
+		return nil
+	}
+
+	startTime := time.Now()
+	err = ctrl.provisioners[provisionerName].Delete(volume)
 	if err != nil {
 		if ierr, ok := err.(*IgnoredError); ok {
 			// Delete ignored, do nothing and hope another provisioner will delete it.
 			glog.Infof("deletion of volume %q ignored: %v", volume.Name, ierr)
 			return nil
 		}
+		ctrl.metrics.deleteFailedTotal.Inc(provisionerName, volumeClass)
 		// Delete failed, emit an event.
 		glog.Errorf("Deletion of volume %q failed: %v", volume.Name, err)
 		ctrl.eventRecorder.Event(volume, v1.EventTypeWarning, "VolumeFailedDelete", err.Error())
 		return err
 	}
 
+	ctrl.metrics.deleteTotal.Inc(provisionerName, volumeClass)
+	ctrl.metrics.recordSuccess()
+	ctrl.metrics.deleteDuration.Observe(time.Since(startTime).Seconds(), provisionerName, volumeClass)
 	glog.Infof("volume %q deleted", volume.Name)
 
 	glog.V(4).Infof("deleteVolumeOperation [%s]: success", volume.Name)
+	if hasFinalizer(newVolume.Finalizers, finalizerPV) {
+		finalizedVolume, err := copyVolume(newVolume)
+		if err != nil {
+			return err
+		}
+		finalizedVolume.Finalizers = removeString(finalizedVolume.Finalizers, finalizerPV)
+		if _, err = ctrl.saveVolume(newVolume, finalizedVolume); err != nil {
+			// The storage asset has been deleted so the next attempt's Delete
+			// should succeed quickly, e.g. with not found
+			glog.Infof("failed to remove finalizer from volume %q: %v", volume.Name, err)
+			return nil
+		}
+		glog.V(4).Infof("deleteVolumeOperation [%s]: removed finalizer", volume.Name)
+	}
+	if newVolume.DeletionTimestamp != nil {
+		// The volume was deleted directly and is gone now its finalizer is
+		glog.Infof("volume %q deleted from database", volume.Name)
+		return nil
+	}
+
 	// Delete the volume
 	if err = ctrl.client.Core().PersistentVolumes().Delete(volume.Name, nil); err != nil {
 		// Oops, could not delete the volume and therefore the controller will
@@ -1056,6 +2506,114 @@ func (ctrl *ProvisionController) deleteVolumeOperation(volume *v1.PersistentVolu
 	return nil
 }
 
+// removeFinalizer removes finalizerPV from the given volume, which is being
+// deleted, without deleting its storage asset
+func (ctrl *ProvisionController) removeFinalizer(volume *v1.PersistentVolume) error {
+	if ctrl.dryRun {
+		glog.Infof("Dry run: would remove finalizer from volume %q", volume.Name)
+		return nil
+	}
+
+	oldVolume, err := ctrl.client.Core().PersistentVolumes().Get(volume.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !hasFinalizer(oldVolume.Finalizers, finalizerPV) {
+		return nil
+	}
+	newVolume, err := copyVolume(oldVolume)
+	if err != nil {
+		return err
+	}
+	newVolume.Finalizers = removeString(newVolume.Finalizers, finalizerPV)
+	if _, err = ctrl.saveVolume(oldVolume, newVolume); err != nil {
+		glog.Errorf("Error removing finalizer from volume %q: %v", volume.Name, err)
+		return err
+	}
+	glog.Infof("removed finalizer from volume %q, its storage asset is retained", volume.Name)
+	return nil
+}
+
+// resizeVolumeOperation attempts to expand the volume bound to the given claim
+// to the claim's requested size. Returns an error for use by the claim queue
+// when expbackoff is enabled: if nil, the claim is forgotten, else the claim
+// may be retried with expbackoff.
+func (ctrl *ProvisionController) resizeVolumeOperation(claim *v1.PersistentVolumeClaim) error {
+	glog.V(4).Infof("resizeVolumeOperation [%s] started", claimToClaimKey(claim))
+
+	// Get the latest volume, the cached one may be stale if a previous resize
+	// just finished
+	volume, err := ctrl.client.Core().PersistentVolumes().Get(claim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorf("Error getting volume %q bound to claim %q: %v", claim.Spec.VolumeName, claimToClaimKey(claim), err)
+		return err
+	}
+
+	resizer, ok := ctrl.provisioners[volume.Annotations[annDynamicallyProvisioned]].(Resizer)
+	if !ok {
+		glog.V(4).Infof("resizeVolumeOperation [%s]: volume %q is not resizable by this controller, skipping", claimToClaimKey(claim), volume.Name)
+		return nil
+	}
+
+	requested := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	if requested.Cmp(capacity) <= 0 {
+		glog.V(4).Infof("resizeVolumeOperation [%s]: volume %q already has capacity %s, skipping", claimToClaimKey(claim), volume.Name, capacity.String())
+		return nil
+	}
+
+	if ctrl.dryRun {
+		msg := fmt.Sprintf("Dry run: would resize volume %s from %s to %s", volume.Name, capacity.String(), requested.String())
+		glog.Infof("%s for claim %q", msg, claimToClaimKey(claim))
+		ctrl.dryRunEvent(claim, claim.UID, "VolumeResizeDryRun", msg)
+		return nil
+	}
+
+	newSize, err := resizer.Resize(volume, requested)
+	if err != nil {
+		strerr := fmt.Sprintf("Failed to resize volume %s to %s: %v", volume.Name, requested.String(), err)
+		glog.Errorf("Failed to resize volume %q for claim %q: %v", volume.Name, claimToClaimKey(claim), err)
+		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "VolumeResizeFailed", strerr)
+		return err
+	}
+
+	glog.Infof("volume %q for claim %q resized to %s", volume.Name, claimToClaimKey(claim), newSize.String())
+
+	newVolume, err := copyVolume(volume)
+	if err != nil {
+		return err
+	}
+	newVolume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)] = newSize
+	if _, err = ctrl.saveVolume(volume, newVolume); err != nil {
+		// The storage asset has been resized so the next attempt's Resize should
+		// return quickly, only the PV object needs updating
+		strerr := fmt.Sprintf("Error updating capacity of volume %s to %s: %v", volume.Name, newSize.String(), err)
+		glog.Error(strerr)
+		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "VolumeResizeFailed", strerr)
+		return err
+	}
+	ctrl.metrics.recordSuccess()
+
+	// The PV controller only sets the claim's capacity when binding, so set it
+	// here. Failure is not fatal: the volume itself has been resized.
+	newClaim, err := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Get(claim.Name, metav1.GetOptions{})
+	if err == nil {
+		if newClaim.Status.Capacity == nil {
+			newClaim.Status.Capacity = v1.ResourceList{}
+		}
+		newClaim.Status.Capacity[v1.ResourceName(v1.ResourceStorage)] = newSize
+		_, err = ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).UpdateStatus(newClaim)
+	}
+	if err != nil {
+		glog.Infof("failed to update capacity of claim %q: %v", claimToClaimKey(claim), err)
+	}
+
+	msg := fmt.Sprintf("Successfully resized volume %s to %s", volume.Name, newSize.String())
+	ctrl.eventRecorder.Event(claim, v1.EventTypeNormal, "VolumeResizeSuccessful", msg)
+
+	return nil
+}
+
 // getProvisionedVolumeNameForClaim returns PV.Name for the provisioned volume.
 // The name must be unique.
 func (ctrl *ProvisionController) getProvisionedVolumeNameForClaim(claim *v1.PersistentVolumeClaim) string {
@@ -1098,6 +2656,97 @@ func (ctrl *ProvisionController) getStorageClassFields(name string) (string, map
 	return "", nil, fmt.Errorf("Cannot convert object to StorageClass: %+v", classObj)
 }
 
+// storageClassPolicy holds the StorageClass fields introduced in Kubernetes 1.8
+// and later that the vendored storage/v1 StorageClass type does not have yet.
+type storageClassPolicy struct {
+	ReclaimPolicy     v1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy,omitempty"`
+	MountOptions      []string                         `json:"mountOptions,omitempty"`
+	VolumeBindingMode string                           `json:"volumeBindingMode,omitempty"`
+	AllowedTopologies []topologySelectorTerm           `json:"allowedTopologies,omitempty"`
+
+	// resourceVersion of the class the policy was decoded from
+	resourceVersion string
+}
+
+// getStorageClassPolicy returns the reclaim policy, mount options, volume
+// binding mode and allowed topologies of the StorageClass with the given name.
+// Before Kubernetes 1.8 classes have none of them so the defaults, Delete and
+// nothing else, are returned.
+func (ctrl *ProvisionController) getStorageClassPolicy(name string) (*storageClassPolicy, error) {
+	if !ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.8.0")) {
+		return &storageClassPolicy{ReclaimPolicy: v1.PersistentVolumeReclaimDelete}, nil
+	}
+
+	// The classes cache holds typed objects that have dropped the fields, so
+	// the raw object is got from the API server, but only once per version of
+	// the class in the cache.
+	resourceVersion := ctrl.getStorageClassResourceVersion(name)
+	ctrl.classPoliciesMutex.Lock()
+	policy, ok := ctrl.classPolicies[name]
+	ctrl.classPoliciesMutex.Unlock()
+	if ok && resourceVersion != "" && policy.resourceVersion == resourceVersion {
+		return policy, nil
+	}
+
+	raw, err := ctrl.client.StorageV1().RESTClient().Get().Resource("storageclasses").Name(name).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	var class struct {
+		metav1.ObjectMeta `json:"metadata,omitempty"`
+		storageClassPolicy
+	}
+	if err = json.Unmarshal(raw, &class); err != nil {
+		return nil, fmt.Errorf("Error decoding StorageClass %q: %v", name, err)
+	}
+	policy = &class.storageClassPolicy
+	policy.resourceVersion = class.ResourceVersion
+	if policy.ReclaimPolicy == "" {
+		policy.ReclaimPolicy = v1.PersistentVolumeReclaimDelete
+	}
+
+	ctrl.classPoliciesMutex.Lock()
+	ctrl.classPolicies[name] = policy
+	ctrl.classPoliciesMutex.Unlock()
+	return policy, nil
+}
+
+// getStorageClassResourceVersion returns the resourceVersion of the
+// StorageClass with the given name in the classes cache, or "" if it's not
+// there.
+func (ctrl *ProvisionController) getStorageClassResourceVersion(name string) string {
+	classObj, found, err := ctrl.classes.GetByKey(name)
+	if err != nil || !found {
+		return ""
+	}
+	switch class := classObj.(type) {
+	case *storage.StorageClass:
+		return class.ResourceVersion
+	case *storagebeta.StorageClass:
+		return class.ResourceVersion
+	}
+	return ""
+}
+
+func hasFinalizer(finalizers []string, finalizer string) bool {
+	for _, f := range finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(strs []string, str string) []string {
+	var result []string
+	for _, s := range strs {
+		if s != str {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
 func claimToClaimKey(claim *v1.PersistentVolumeClaim) string {
 	return fmt.Sprintf("%s/%s", claim.Namespace, claim.Name)
 }
@@ -19,9 +19,12 @@ package controller
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -33,14 +36,18 @@ import (
 	"k8s.io/apimachinery/pkg/conversion"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/kubernetes/scheme"
 	fakev1core "k8s.io/client-go/kubernetes/typed/core/v1/fake"
 	"k8s.io/client-go/pkg/api/v1/ref"
+	"k8s.io/client-go/rest"
 	testclient "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
 	fcache "k8s.io/client-go/tools/cache/testing"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -135,14 +142,14 @@ func TestController(t *testing.T) {
 			expectedVolumes: []v1.PersistentVolume(nil),
 		},
 		{
-			name: "provisioner fails to delete volume-1: pv is not deleted",
+			name: "provisioner fails to delete volume-1: pv is not deleted, failure is recorded",
 			objs: []runtime.Object{
-				newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+				newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz", annDeleteFailures: "14"}),
 			},
 			provisionerName: "foo.bar/baz",
 			provisioner:     newBadTestProvisioner(),
 			expectedVolumes: []v1.PersistentVolume{
-				*newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+				*newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz", annDeleteFailures: "15", annDeleteLastError: "fake error"}),
 			},
 		},
 		{
@@ -187,7 +194,7 @@ func TestController(t *testing.T) {
 		go ctrl.Run(stopCh)
 
 		time.Sleep(2 * resyncPeriod)
-		ctrl.runningOperations.Wait()
+		waitForSync(ctrl)
 
 		pvList, _ := client.Core().PersistentVolumes().List(metav1.ListOptions{})
 		if !reflect.DeepEqual(test.expectedVolumes, pvList.Items) {
@@ -198,6 +205,189 @@ func TestController(t *testing.T) {
 	}
 }
 
+func TestAdditionalProvisioner(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newStorageClass("class-1", "foo.bar/baz"),
+		newStorageClass("class-2", "foo.bar/qux"),
+		newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		newClaim("claim-2", "uid-2-1", "class-2", "", nil),
+		newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/qux"}),
+	)
+	provisioner1 := newTestProvisioner()
+	provisioner2 := newTestProvisioner()
+	ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner1, "v1.5.0")
+	if err := AdditionalProvisioner("foo.bar/qux", provisioner2)(ctrl); err != nil {
+		t.Fatalf("unexpected error adding provisioner: %v", err)
+	}
+	if err := AdditionalProvisioner("foo.bar/baz", provisioner2)(ctrl); err == nil {
+		t.Errorf("expected error adding provisioner with duplicate name but got nil")
+	}
+	stopCh := make(chan struct{})
+	go ctrl.Run(stopCh)
+
+	time.Sleep(2 * resyncPeriod)
+	waitForSync(ctrl)
+
+	expectedProvisioners := map[string]string{"pvc-uid-1-1": "foo.bar/baz", "pvc-uid-2-1": "foo.bar/qux"}
+	for volumeName, expected := range expectedProvisioners {
+		volume, err := client.Core().PersistentVolumes().Get(volumeName, metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("error getting volume %q: %v", volumeName, err)
+			continue
+		}
+		if provisioner := volume.Annotations[annDynamicallyProvisioned]; expected != provisioner {
+			t.Errorf("expected volume %q provisioned by %q but got %q", volumeName, expected, provisioner)
+		}
+	}
+	if _, err := client.Core().PersistentVolumes().Get("volume-1", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected volume-1 to be deleted")
+	}
+	if len(provisioner1.provisionCalls) != 1 || len(provisioner2.provisionCalls) != 1 {
+		t.Errorf("expected 1 Provision call per provisioner but got %d and %d", len(provisioner1.provisionCalls), len(provisioner2.provisionCalls))
+	}
+	close(stopCh)
+}
+
+func TestClaimScope(t *testing.T) {
+	tests := []struct {
+		name              string
+		objs              []runtime.Object
+		namespaces        []string
+		labelSelector     string
+		expectedVolumes   []string
+		unexpectedVolumes []string
+	}{
+		{
+			name: "provision only for claim in namespace ns-1",
+			objs: []runtime.Object{
+				newClaimWithNamespace(newClaim("claim-1", "uid-1-1", "class-1", "", nil), "ns-1"),
+				newClaimWithNamespace(newClaim("claim-2", "uid-2-1", "class-1", "", nil), "ns-2"),
+			},
+			namespaces:        []string{"ns-1"},
+			expectedVolumes:   []string{"pvc-uid-1-1"},
+			unexpectedVolumes: []string{"pvc-uid-2-1"},
+		},
+		{
+			name: "provision only for claims in namespaces ns-1 & ns-2",
+			objs: []runtime.Object{
+				newClaimWithNamespace(newClaim("claim-1", "uid-1-1", "class-1", "", nil), "ns-1"),
+				newClaimWithNamespace(newClaim("claim-2", "uid-2-1", "class-1", "", nil), "ns-2"),
+				newClaimWithNamespace(newClaim("claim-3", "uid-3-1", "class-1", "", nil), "ns-3"),
+			},
+			namespaces:        []string{"ns-1", "ns-2"},
+			expectedVolumes:   []string{"pvc-uid-1-1", "pvc-uid-2-1"},
+			unexpectedVolumes: []string{"pvc-uid-3-1"},
+		},
+		{
+			name: "provision only for claim matching label selector",
+			objs: []runtime.Object{
+				newClaimWithLabels(newClaim("claim-1", "uid-1-1", "class-1", "", nil), map[string]string{"tenant": "a"}),
+				newClaimWithLabels(newClaim("claim-2", "uid-2-1", "class-1", "", nil), map[string]string{"tenant": "b"}),
+			},
+			labelSelector:     "tenant=a",
+			expectedVolumes:   []string{"pvc-uid-1-1"},
+			unexpectedVolumes: []string{"pvc-uid-2-1"},
+		},
+		{
+			name: "don't delete volume bound to claim in other namespace",
+			objs: []runtime.Object{
+				newVolumeWithClaimRef(newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}), "ns-2", "claim-1"),
+			},
+			namespaces:      []string{"ns-1"},
+			expectedVolumes: []string{"volume-1"},
+		},
+		{
+			name: "don't delete volume of claim not matching label selector",
+			objs: []runtime.Object{
+				newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz", annClaimLabels: "tenant=b"}),
+			},
+			labelSelector:   "tenant=a",
+			expectedVolumes: []string{"volume-1"},
+		},
+		{
+			name: "delete volume of claim matching label selector",
+			objs: []runtime.Object{
+				newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz", annClaimLabels: "tenant=a"}),
+			},
+			labelSelector:     "tenant=a",
+			unexpectedVolumes: []string{"volume-1"},
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(append(test.objs, newStorageClass("class-1", "foo.bar/baz"))...)
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newTestProvisioner(), "v1.5.0", Namespaces(test.namespaces), ClaimLabelSelector(test.labelSelector))
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		time.Sleep(2 * resyncPeriod)
+		waitForSync(ctrl)
+
+		for _, volumeName := range test.expectedVolumes {
+			if _, err := client.Core().PersistentVolumes().Get(volumeName, metav1.GetOptions{}); err != nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected volume %q to exist but got error: %v\n", volumeName, err)
+			}
+		}
+		for _, volumeName := range test.unexpectedVolumes {
+			if _, err := client.Core().PersistentVolumes().Get(volumeName, metav1.GetOptions{}); err == nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected volume %q not to exist\n", volumeName)
+			}
+		}
+		close(stopCh)
+	}
+}
+
+func TestSharedInformers(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newStorageClass("class-1", "foo.bar/baz"),
+		newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+	)
+	claimInformer := cache.NewSharedInformer(newClaimListWatch(client, nil, ""), &v1.PersistentVolumeClaim{}, 0)
+	volumeInformer := cache.NewSharedInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.Core().PersistentVolumes().List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Core().PersistentVolumes().Watch(options)
+		},
+	}, &v1.PersistentVolume{}, 0)
+	classInformer := cache.NewSharedInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.StorageV1beta1().StorageClasses().List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.StorageV1beta1().StorageClasses().Watch(options)
+		},
+	}, &storagebeta.StorageClass{}, 0)
+
+	ctrl := newTestProvisionController(client, "foo.bar/baz", newTestProvisioner(), "v1.5.0",
+		ClaimsInformer(claimInformer), VolumesInformer(volumeInformer), ClassesInformer(classInformer))
+	if ctrl.claimController != nil || ctrl.volumeController != nil || ctrl.classReflector != nil {
+		t.Errorf("expected controller to use the informers passed to it instead of its own")
+	}
+	if ctrl.ClaimLister() != claimInformer.GetStore() || ctrl.VolumeLister() != volumeInformer.GetStore() || ctrl.ClassLister() != classInformer.GetStore() {
+		t.Errorf("expected controller to return the stores of the informers passed to it")
+	}
+
+	stopCh := make(chan struct{})
+	go claimInformer.Run(stopCh)
+	go volumeInformer.Run(stopCh)
+	go classInformer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, claimInformer.HasSynced, volumeInformer.HasSynced, classInformer.HasSynced) {
+		t.Fatalf("timed out waiting for informers to sync")
+	}
+	go ctrl.Run(stopCh)
+
+	time.Sleep(2 * resyncPeriod)
+	waitForSync(ctrl)
+
+	if _, err := client.Core().PersistentVolumes().Get("pvc-uid-1-1", metav1.GetOptions{}); err != nil {
+		t.Errorf("error getting provisioned volume: %v", err)
+	}
+	close(stopCh)
+}
+
 func TestMultipleControllers(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -250,6 +440,7 @@ func TestMultipleControllers(t *testing.T) {
 			ctrls[i].claims.Add(newClaim("claim-1", "uid-1-1", "class-1", "", nil))
 			ctrls[i].classes.Add(newStorageClass("class-1", "foo.bar/baz"))
 			stopChs[i] = make(chan struct{})
+			go wait.Until(ctrls[i].runClaimWorker, time.Second, stopChs[i])
 		}
 
 		for i := 0; i < test.numControllers; i++ {
@@ -264,9 +455,121 @@ func TestMultipleControllers(t *testing.T) {
 			t.Errorf("expected provision calls:\n %v\n but got:\n %v\n", test.expectedCalls, len(provisioner.provisionCalls))
 		}
 
-		for _, stopCh := range stopChs {
+		for i, stopCh := range stopChs {
 			close(stopCh)
+			ctrls[i].claimQueue.ShutDown()
+		}
+	}
+}
+
+func TestControllerLeaderElection(t *testing.T) {
+	tests := []struct {
+		name           string
+		lockType       string
+		numControllers int
+		expectedCalls  int
+	}{
+		{
+			name:           "configmap lock: call provision exactly once",
+			lockType:       rl.ConfigMapsResourceLock,
+			numControllers: 3,
+			expectedCalls:  1,
+		},
+		{
+			name:           "endpoints lock: call provision exactly once",
+			lockType:       rl.EndpointsResourceLock,
+			numControllers: 3,
+			expectedCalls:  1,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(
+			newStorageClass("class-1", "foo.bar/baz"),
+			newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		)
+
+		provisioner := newTestProvisioner()
+		ctrls := make([]*ProvisionController, test.numControllers)
+		stopCh := make(chan struct{})
+		for i := 0; i < test.numControllers; i++ {
+			ctrls[i] = newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0")
+			LeaderElection(LeaderElectionController)(ctrls[i])
+			LeaderElectionLockType(test.lockType)(ctrls[i])
+			// Lease durations are recorded in whole seconds
+			LeaseDuration(2 * time.Second)(ctrls[i])
+			RenewDeadline(time.Second)(ctrls[i])
+			go ctrls[i].Run(stopCh)
+		}
+
+		time.Sleep(3 * resyncPeriod)
+		for _, ctrl := range ctrls {
+			waitForSync(ctrl)
+		}
+
+		if test.expectedCalls != len(provisioner.provisionCalls) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected provision calls:\n %v\n but got:\n %v\n", test.expectedCalls, len(provisioner.provisionCalls))
+		}
+
+		leaders := 0
+		for _, ctrl := range ctrls {
+			if ctrl.isLeading() {
+				leaders++
+			}
 		}
+		if leaders != 1 {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected 1 leader but got %v\n", leaders)
+		}
+
+		claim, _ := client.Core().PersistentVolumeClaims(v1.NamespaceDefault).Get("claim-1", metav1.GetOptions{})
+		if _, found := claim.Annotations[rl.LeaderElectionRecordAnnotationKey]; found {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected claim not to be annotated with a leader election record")
+		}
+		close(stopCh)
+	}
+}
+
+func TestThreadiness(t *testing.T) {
+	tests := []struct {
+		name           string
+		threadiness    int
+		numClaims      int
+		expectedVolume int
+	}{
+		{
+			name:           "provision 10 claims at most 2 at a time",
+			threadiness:    2,
+			numClaims:      10,
+			expectedVolume: 10,
+		},
+	}
+	for _, test := range tests {
+		objs := []runtime.Object{newStorageClass("class-1", "foo.bar/baz")}
+		for i := 0; i < test.numClaims; i++ {
+			objs = append(objs, newClaim(fmt.Sprintf("claim-%d", i), fmt.Sprintf("uid-%d", i), "class-1", "", nil))
+		}
+		client := fake.NewSimpleClientset(objs...)
+		provisioner := newConcurrencyTestProvisioner()
+		ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0")
+		Threadiness(test.threadiness)(ctrl)
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		time.Sleep(2 * resyncPeriod)
+		waitForSync(ctrl)
+
+		pvList, _ := client.Core().PersistentVolumes().List(metav1.ListOptions{})
+		if test.expectedVolume != len(pvList.Items) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %v PVs but got %v\n", test.expectedVolume, len(pvList.Items))
+		}
+		if provisioner.maxInFlight > test.threadiness {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected at most %v concurrent provision calls but got %v\n", test.threadiness, provisioner.maxInFlight)
+		}
+		close(stopCh)
 	}
 }
 
@@ -324,6 +627,30 @@ func TestShouldProvision(t *testing.T) {
 				map[string]string{annStorageProvisioner: "abc.def/ghi"}),
 			expectedShould: false,
 		},
+		{
+			name:            "failed fewer times than threshold",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim: newClaim("claim-1", "1-1", "class-1", "",
+				map[string]string{annProvisionFailures: "14"}),
+			expectedShould: true,
+		},
+		{
+			name:            "failed threshold times",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim: newClaim("claim-1", "1-1", "class-1", "",
+				map[string]string{annProvisionFailures: "15"}),
+			expectedShould: false,
+		},
+		{
+			name:            "failed with final error",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim: newClaim("claim-1", "1-1", "class-1", "",
+				map[string]string{annProvisionFinalError: "invalid parameter"}),
+			expectedShould: false,
+		},
 	}
 	for _, test := range tests {
 		client := fake.NewSimpleClientset(test.claim)
@@ -344,6 +671,60 @@ func TestShouldProvision(t *testing.T) {
 	}
 }
 
+func TestShouldProvisionDelayedBinding(t *testing.T) {
+	tests := []struct {
+		name           string
+		class          string
+		claim          *v1.PersistentVolumeClaim
+		expectedShould bool
+	}{
+		{
+			name:           "immediate binding",
+			class:          `{"provisioner": "foo.bar/baz"}`,
+			claim:          newClaim("claim-1", "1-1", "class-1", "", nil),
+			expectedShould: true,
+		},
+		{
+			name:           "wait for a node to be selected",
+			class:          `{"provisioner": "foo.bar/baz", "volumeBindingMode": "WaitForFirstConsumer"}`,
+			claim:          newClaim("claim-1", "1-1", "class-1", "", nil),
+			expectedShould: false,
+		},
+		{
+			name:  "node selected",
+			class: `{"provisioner": "foo.bar/baz", "volumeBindingMode": "WaitForFirstConsumer"}`,
+			claim: newClaim("claim-1", "1-1", "class-1", "",
+				map[string]string{annSelectedNode: "node-1"}),
+			expectedShould: true,
+		},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/apis/storage.k8s.io/v1/storageclasses/class-1" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, test.class)
+		}))
+		client := kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newTestProvisioner(), "v1.12.0")
+
+		err := ctrl.classes.Add(newStorageClass("class-1", "foo.bar/baz"))
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("error adding class to cache: %v", err)
+		}
+
+		should := ctrl.shouldProvision(test.claim)
+		if test.expectedShould != should {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected should provision %v but got %v\n", test.expectedShould, should)
+		}
+		server.Close()
+	}
+}
+
 func TestShouldDelete(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -394,6 +775,13 @@ func TestShouldDelete(t *testing.T) {
 			serverGitVersion: "v1.5.0",
 			expectedShould:   false,
 		},
+		{
+			name:             "failed threshold times",
+			provisionerName:  "foo.bar/baz",
+			volume:           newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz", annDeleteFailures: "15"}),
+			serverGitVersion: "v1.5.0",
+			expectedShould:   false,
+		},
 	}
 	for _, test := range tests {
 		client := fake.NewSimpleClientset()
@@ -453,79 +841,1161 @@ func TestIsOnlyRecordUpdate(t *testing.T) {
 	}
 }
 
-func newTestProvisionController(
-	client kubernetes.Interface,
-	provisionerName string,
-	provisioner Provisioner,
-	serverGitVersion string,
-) *ProvisionController {
-	ctrl := NewProvisionController(
-		client,
-		provisionerName,
-		provisioner,
-		serverGitVersion,
-		ResyncPeriod(resyncPeriod),
-		ExponentialBackOffOnError(false),
-		CreateProvisionedPVInterval(10*time.Millisecond),
-		LeaseDuration(2*resyncPeriod),
-		RenewDeadline(resyncPeriod),
-		RetryPeriod(resyncPeriod/2),
-		TermLimit(2*resyncPeriod))
-	return ctrl
-}
-
-func newStorageClass(name, provisioner string) *storagebeta.StorageClass {
-	return &storagebeta.StorageClass{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
+func TestIsOnlyFailureUpdate(t *testing.T) {
+	tests := []struct {
+		name       string
+		old        *v1.PersistentVolumeClaim
+		new        *v1.PersistentVolumeClaim
+		expectedIs bool
+	}{
+		{
+			name:       "is only failure update",
+			old:        newClaim("claim-1", "1-1", "class-1", "", nil),
+			new:        newClaim("claim-1", "1-1", "class-1", "", map[string]string{annProvisionFailures: "1", annProvisionLastError: "a"}),
+			expectedIs: true,
 		},
-		Provisioner: provisioner,
-	}
-}
-
-func newClaim(name, claimUID, provisioner, volumeName string, annotations map[string]string) *v1.PersistentVolumeClaim {
-	claim := &v1.PersistentVolumeClaim{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:            name,
-			Namespace:       v1.NamespaceDefault,
-			UID:             types.UID(claimUID),
-			ResourceVersion: "0",
-			Annotations:     map[string]string{annClass: provisioner},
-			SelfLink:        "/api/v1/namespaces/" + v1.NamespaceDefault + "/persistentvolumeclaims/" + name,
+		{
+			name:       "is only failure update, failed again",
+			old:        newClaim("claim-1", "1-1", "class-1", "", map[string]string{annProvisionFailures: "1", annProvisionLastError: "a"}),
+			new:        newClaim("claim-1", "1-1", "class-1", "", map[string]string{annProvisionFailures: "2", annProvisionLastError: "b"}),
+			expectedIs: true,
 		},
-		Spec: v1.PersistentVolumeClaimSpec{
-			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadOnlyMany},
-			Resources: v1.ResourceRequirements{
-				Requests: v1.ResourceList{
-					v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Mi"),
-				},
-			},
-			VolumeName: volumeName,
+		{
+			name:       "isn't only failure update, failures removed to retry",
+			old:        newClaim("claim-1", "1-1", "class-1", "", map[string]string{annProvisionFailures: "15", annProvisionLastError: "a"}),
+			new:        newClaim("claim-1", "1-1", "class-1", "", nil),
+			expectedIs: false,
 		},
-		Status: v1.PersistentVolumeClaimStatus{
-			Phase: v1.ClaimPending,
+		{
+			name:       "isn't only failure update, stayed exactly the same",
+			old:        newClaim("claim-1", "1-1", "class-1", "", map[string]string{annProvisionFailures: "1", annProvisionLastError: "a"}),
+			new:        newClaim("claim-1", "1-1", "class-1", "", map[string]string{annProvisionFailures: "1", annProvisionLastError: "a"}),
+			expectedIs: false,
+		},
+		{
+			name:       "isn't only failure update, class changed as well",
+			old:        newClaim("claim-1", "1-1", "class-1", "", nil),
+			new:        newClaim("claim-1", "1-1", "class-2", "", map[string]string{annProvisionFailures: "1", annProvisionLastError: "a"}),
+			expectedIs: false,
 		},
 	}
-	for k, v := range annotations {
-		claim.Annotations[k] = v
+	for _, test := range tests {
+		is, _ := isOnlyFailureUpdate(test.old, test.new, annProvisionFailures, annProvisionLastError)
+		if test.expectedIs != is {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected is only failure update %v but got %v\n", test.expectedIs, is)
+		}
 	}
-	return claim
 }
 
-func newVolume(name string, phase v1.PersistentVolumePhase, policy v1.PersistentVolumeReclaimPolicy, annotations map[string]string) *v1.PersistentVolume {
-	pv := &v1.PersistentVolume{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        name,
-			Annotations: annotations,
-			SelfLink:    "/api/v1/persistentvolumes/" + name,
+func TestFailureAnnotations(t *testing.T) {
+	tests := []struct {
+		name                  string
+		claim                 *v1.PersistentVolumeClaim
+		provisioner           Provisioner
+		expectedFailures      string
+		expectedLastError     string
+		expectedFailuresFound bool
+		expectedFinalError    string
+	}{
+		{
+			name:                  "record failures up to threshold",
+			claim:                 newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			provisioner:           newBadTestProvisioner(),
+			expectedFailures:      "2",
+			expectedLastError:     "fake error",
+			expectedFailuresFound: true,
 		},
-		Spec: v1.PersistentVolumeSpec{
-			PersistentVolumeReclaimPolicy: policy,
-			AccessModes:                   []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadOnlyMany},
-			Capacity: v1.ResourceList{
-				v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Mi"),
-			},
-			PersistentVolumeSource: v1.PersistentVolumeSource{
+		{
+			name:                  "remove failures on success",
+			claim:                 newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annProvisionFailures: "1", annProvisionLastError: "fake error"}),
+			provisioner:           newTestProvisioner(),
+			expectedFailuresFound: false,
+		},
+		{
+			name:                  "record final error",
+			claim:                 newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			provisioner:           newErrorTestProvisioner(&FinalError{Reason: "invalid parameter"}),
+			expectedFailuresFound: false,
+			expectedFinalError:    "invalid parameter",
+		},
+		{
+			name:                  "don't record transient error",
+			claim:                 newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			provisioner:           newErrorTestProvisioner(&TransientError{Reason: "timeout"}),
+			expectedFailuresFound: false,
+		},
+		{
+			name:                  "don't record in progress error",
+			claim:                 newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			provisioner:           newErrorTestProvisioner(&InProgressError{Reason: "creating"}),
+			expectedFailuresFound: false,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(newStorageClass("class-1", "foo.bar/baz"), test.claim)
+		ctrl := newTestProvisionController(client, "foo.bar/baz", test.provisioner, "v1.5.0")
+		FailedProvisionThreshold(2)(ctrl)
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		time.Sleep(5 * resyncPeriod)
+		waitForSync(ctrl)
+
+		claim, _ := client.Core().PersistentVolumeClaims(v1.NamespaceDefault).Get("claim-1", metav1.GetOptions{})
+		failures, found := claim.Annotations[annProvisionFailures]
+		if test.expectedFailuresFound != found || test.expectedFailures != failures {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected failures annotation %q (found %v) but got %q (found %v)\n", test.expectedFailures, test.expectedFailuresFound, failures, found)
+		}
+		if lastError := claim.Annotations[annProvisionLastError]; test.expectedLastError != lastError {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected last error annotation %q but got %q\n", test.expectedLastError, lastError)
+		}
+		if finalError := claim.Annotations[annProvisionFinalError]; test.expectedFinalError != finalError {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected final error annotation %q but got %q\n", test.expectedFinalError, finalError)
+		}
+		close(stopCh)
+	}
+}
+
+func TestFinalErrorWithoutThreshold(t *testing.T) {
+	client := fake.NewSimpleClientset(newStorageClass("class-1", "foo.bar/baz"), newClaim("claim-1", "uid-1-1", "class-1", "", nil))
+	ctrl := newTestProvisionController(client, "foo.bar/baz", newErrorTestProvisioner(&FinalError{Reason: "invalid parameter"}), "v1.5.0")
+	FailedProvisionThreshold(0)(ctrl)
+	stopCh := make(chan struct{})
+	go ctrl.Run(stopCh)
+
+	time.Sleep(5 * resyncPeriod)
+	waitForSync(ctrl)
+	close(stopCh)
+
+	claim, _ := client.Core().PersistentVolumeClaims(v1.NamespaceDefault).Get("claim-1", metav1.GetOptions{})
+	if finalError := claim.Annotations[annProvisionFinalError]; finalError != "invalid parameter" {
+		t.Errorf("expected final error annotation %q but got %q", "invalid parameter", finalError)
+	}
+	if ctrl.shouldProvision(claim) {
+		t.Errorf("expected no retries of claim with final error")
+	}
+}
+
+func TestProvisionErrorRetries(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		expectedRequeue bool
+	}{
+		{
+			name:            "don't retry final error",
+			err:             &FinalError{Reason: "invalid parameter"},
+			expectedRequeue: false,
+		},
+		{
+			name:            "retry transient error",
+			err:             &TransientError{Reason: "timeout", RetryAfter: 10 * time.Millisecond},
+			expectedRequeue: true,
+		},
+		{
+			name:            "poll in progress error",
+			err:             &InProgressError{Reason: "creating", RetryAfter: 10 * time.Millisecond},
+			expectedRequeue: true,
+		},
+	}
+	for _, test := range tests {
+		class := newStorageClass("class-1", "foo.bar/baz")
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		client := fake.NewSimpleClientset(class, claim)
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newErrorTestProvisioner(test.err), "v1.5.0")
+		LeaderElection(LeaderElectionController)(ctrl)
+		ctrl.setLeading(true)
+		ctrl.classes.Add(class)
+		ctrl.claims.Add(claim)
+		ctrl.claimQueue.Add(claimToClaimKey(claim))
+
+		ctrl.processNextWorkItem(ctrl.claimQueue, ctrl.syncClaimHandler)
+		time.Sleep(50 * time.Millisecond)
+
+		if requeued := ctrl.claimQueue.Len() > 0; test.expectedRequeue != requeued {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected requeue %v but got %v\n", test.expectedRequeue, requeued)
+		}
+		ctrl.claimQueue.ShutDown()
+	}
+}
+
+func TestResyncDuringRetry(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		expectedRequeue bool
+	}{
+		{
+			name:            "resync while backing off",
+			err:             errors.New("fake error"),
+			expectedRequeue: false,
+		},
+		{
+			name:            "resync while waiting to retry transient error",
+			err:             &TransientError{Reason: "timeout", RetryAfter: time.Hour},
+			expectedRequeue: false,
+		},
+		{
+			name:            "resync while waiting to poll in progress error",
+			err:             &InProgressError{Reason: "creating", RetryAfter: time.Hour},
+			expectedRequeue: false,
+		},
+		{
+			name:            "resync after final error",
+			err:             &FinalError{Reason: "invalid parameter"},
+			expectedRequeue: true,
+		},
+	}
+	for _, test := range tests {
+		class := newStorageClass("class-1", "foo.bar/baz")
+		claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		client := fake.NewSimpleClientset(class, claim)
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newErrorTestProvisioner(test.err), "v1.5.0", ExponentialBackOffOnError(true))
+		LeaderElection(LeaderElectionController)(ctrl)
+		ctrl.setLeading(true)
+		ctrl.classes.Add(class)
+		ctrl.claims.Add(claim)
+		ctrl.claimQueue.Add(claimToClaimKey(claim))
+
+		ctrl.processNextWorkItem(ctrl.claimQueue, ctrl.syncClaimHandler)
+		ctrl.updateClaim(claim, claim)
+
+		if requeued := ctrl.claimQueue.Len() > 0; test.expectedRequeue != requeued {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected requeue %v but got %v\n", test.expectedRequeue, requeued)
+		}
+		ctrl.claimQueue.ShutDown()
+	}
+}
+
+func TestAsyncProvision(t *testing.T) {
+	tests := []struct {
+		name               string
+		claim              *v1.PersistentVolumeClaim
+		expectedProvisions int
+	}{
+		{
+			name:               "start & poll provision for claim-1",
+			claim:              newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			expectedProvisions: 1,
+		},
+		{
+			name:               "resume polling provision for claim-1 after restart",
+			claim:              newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annProvisionHandle: "handle-1"}),
+			expectedProvisions: 0,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(newStorageClass("class-1", "foo.bar/baz"), test.claim)
+		provisioner := &asyncTestProvisioner{testProvisioner: newTestProvisioner(), polls: 2}
+		ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0")
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		time.Sleep(5 * resyncPeriod)
+		waitForSync(ctrl)
+
+		if _, err := client.Core().PersistentVolumes().Get("pvc-uid-1-1", metav1.GetOptions{}); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("error getting provisioned volume: %v\n", err)
+		}
+		provisioner.mutex.Lock()
+		if test.expectedProvisions != provisioner.provisions {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %d Provision calls but got %d\n", test.expectedProvisions, provisioner.provisions)
+		}
+		provisioner.mutex.Unlock()
+		claim, _ := client.Core().PersistentVolumeClaims(v1.NamespaceDefault).Get("claim-1", metav1.GetOptions{})
+		if handle, found := claim.Annotations[annProvisionHandle]; found {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected no provision handle annotation but got %q\n", handle)
+		}
+		close(stopCh)
+	}
+}
+
+func TestIsOnlyHandleUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      *v1.PersistentVolumeClaim
+		new      *v1.PersistentVolumeClaim
+		expected bool
+	}{
+		{
+			name:     "handle saved",
+			old:      newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			new:      newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annProvisionHandle: "handle-1"}),
+			expected: true,
+		},
+		{
+			name:     "handle removed",
+			old:      newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annProvisionHandle: "handle-1"}),
+			new:      newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			expected: true,
+		},
+		{
+			name:     "handle unchanged",
+			old:      newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annProvisionHandle: "handle-1"}),
+			new:      newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annProvisionHandle: "handle-1", "foo": "bar"}),
+			expected: false,
+		},
+		{
+			name:     "handle & other annotation saved",
+			old:      newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			new:      newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annProvisionHandle: "handle-1", "foo": "bar"}),
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		result, err := isOnlyHandleUpdate(test.old, test.new)
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error: %v\n", err)
+		}
+		if test.expected != result {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %v but got %v\n", test.expected, result)
+		}
+	}
+}
+
+func TestAdoptVolume(t *testing.T) {
+	tests := []struct {
+		name               string
+		exists             bool
+		expectedProvisions int
+	}{
+		{
+			name:               "provision asset for claim-1",
+			exists:             false,
+			expectedProvisions: 1,
+		},
+		{
+			name:               "adopt existing asset for claim-1",
+			exists:             true,
+			expectedProvisions: 0,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(newStorageClass("class-1", "foo.bar/baz"), newClaim("claim-1", "uid-1-1", "class-1", "", nil))
+		provisioner := &existerTestProvisioner{testProvisioner: newTestProvisioner(), exists: test.exists}
+		ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0")
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		time.Sleep(2 * resyncPeriod)
+		waitForSync(ctrl)
+
+		if _, err := client.Core().PersistentVolumes().Get("pvc-uid-1-1", metav1.GetOptions{}); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("error getting provisioned volume: %v\n", err)
+		}
+		if provisions := len(provisioner.provisionCalls); test.expectedProvisions != provisions {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %d Provision calls but got %d\n", test.expectedProvisions, provisions)
+		}
+		provisioner.mutex.Lock()
+		if expected := "kubernetes-dynamic-pvc-uid-1-1"; expected != provisioner.assetName {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected asset name %q but got %q\n", expected, provisioner.assetName)
+		}
+		provisioner.mutex.Unlock()
+		close(stopCh)
+	}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		name             string
+		claim            *v1.PersistentVolumeClaim
+		volume           *v1.PersistentVolume
+		provisioner      Provisioner
+		expectedCapacity resource.Quantity
+	}{
+		{
+			name:             "resize volume-1",
+			claim:            newClaimWithRequest(newClaim("claim-1", "uid-1-1", "class-1", "volume-1", nil), "2Mi"),
+			volume:           newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			provisioner:      newTestResizer(),
+			expectedCapacity: resource.MustParse("2Mi"),
+		},
+		{
+			name:             "don't resize volume-1 because the provisioner can't",
+			claim:            newClaimWithRequest(newClaim("claim-1", "uid-1-1", "class-1", "volume-1", nil), "2Mi"),
+			volume:           newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			provisioner:      newTestProvisioner(),
+			expectedCapacity: resource.MustParse("1Mi"),
+		},
+		{
+			name:             "don't resize volume-1 because it's not this provisioner's",
+			claim:            newClaimWithRequest(newClaim("claim-1", "uid-1-1", "class-1", "volume-1", nil), "2Mi"),
+			volume:           newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "abc.def/ghi"}),
+			provisioner:      newTestResizer(),
+			expectedCapacity: resource.MustParse("1Mi"),
+		},
+		{
+			name:             "don't resize volume-1 because it's big enough",
+			claim:            newClaim("claim-1", "uid-1-1", "class-1", "volume-1", nil),
+			volume:           newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			provisioner:      newTestResizer(),
+			expectedCapacity: resource.MustParse("1Mi"),
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(test.claim, test.volume)
+		ctrl := newTestProvisionController(client, "foo.bar/baz", test.provisioner, "v1.5.0")
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		time.Sleep(2 * resyncPeriod)
+		waitForSync(ctrl)
+
+		volume, _ := client.Core().PersistentVolumes().Get("volume-1", metav1.GetOptions{})
+		capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		if test.expectedCapacity.Cmp(capacity) != 0 {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected capacity %v but got %v\n", test.expectedCapacity.String(), capacity.String())
+		}
+		close(stopCh)
+	}
+}
+
+func TestResizeLeadership(t *testing.T) {
+	tests := []struct {
+		name             string
+		mode             LeaderElectionMode
+		expectedCapacity resource.Quantity
+	}{
+		{
+			name:             "resize as leader of all claims",
+			mode:             LeaderElectionController,
+			expectedCapacity: resource.MustParse("2Mi"),
+		},
+		{
+			name:             "don't resize before leading the claim",
+			mode:             LeaderElectionPerClaim,
+			expectedCapacity: resource.MustParse("1Mi"),
+		},
+	}
+	for _, test := range tests {
+		claim := newClaimWithRequest(newClaim("claim-1", "uid-1-1", "class-1", "volume-1", nil), "2Mi")
+		volume := newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"})
+		client := fake.NewSimpleClientset(claim, volume)
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newTestResizer(), "v1.5.0", LeaderElection(test.mode))
+		ctrl.setLeading(true)
+		ctrl.volumes.Add(volume)
+
+		if err := ctrl.syncClaim(claim); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error syncing claim: %v\n", err)
+		}
+
+		volume, _ = client.Core().PersistentVolumes().Get("volume-1", metav1.GetOptions{})
+		capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		if test.expectedCapacity.Cmp(capacity) != 0 {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected capacity %v but got %v\n", test.expectedCapacity.String(), capacity.String())
+		}
+		ctrl.stop()
+	}
+}
+
+func TestSelector(t *testing.T) {
+	tests := []struct {
+		name           string
+		claim          *v1.PersistentVolumeClaim
+		expectedLabels map[string]string
+		expectedSaved  bool
+	}{
+		{
+			name:           "label volume to match single value selector",
+			claim:          newClaimWithSelector(newClaim("claim-1", "uid-1-1", "class-1", "", nil), &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "a"}}),
+			expectedLabels: map[string]string{"zone": "a"},
+			expectedSaved:  true,
+		},
+		{
+			name: "don't save volume not matching multiple value selector",
+			claim: newClaimWithSelector(newClaim("claim-1", "uid-1-1", "class-1", "", nil), &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "zone", Operator: metav1.LabelSelectorOpIn, Values: []string{"a", "b"}},
+			}}),
+			expectedSaved: false,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(newStorageClass("class-1", "foo.bar/baz"), test.claim)
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newTestProvisioner(), "v1.5.0")
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		time.Sleep(2 * resyncPeriod)
+		waitForSync(ctrl)
+
+		volume, err := client.Core().PersistentVolumes().Get("pvc-uid-1-1", metav1.GetOptions{})
+		if saved := err == nil; test.expectedSaved != saved {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume saved %v but got %v\n", test.expectedSaved, saved)
+		} else if saved && !reflect.DeepEqual(test.expectedLabels, volume.Labels) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected labels %v but got %v\n", test.expectedLabels, volume.Labels)
+		}
+		close(stopCh)
+	}
+}
+
+func TestSelectedNode(t *testing.T) {
+	tests := []struct {
+		name             string
+		claim            *v1.PersistentVolumeClaim
+		objs             []runtime.Object
+		expectedAffinity string
+		expectedSaved    bool
+	}{
+		{
+			name:  "provision volume accessible from selected node",
+			claim: newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annSelectedNode: "node-1"}),
+			objs: []runtime.Object{
+				&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"zone": "a"}}},
+			},
+			expectedAffinity: `{"requiredDuringSchedulingIgnoredDuringExecution":{"nodeSelectorTerms":[{"matchExpressions":[{"key":"zone","operator":"In","values":["a"]}]}]}}`,
+			expectedSaved:    true,
+		},
+		{
+			name:          "don't provision for missing selected node",
+			claim:         newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annSelectedNode: "node-1"}),
+			expectedSaved: false,
+		},
+	}
+	for _, test := range tests {
+		objs := append(test.objs, newStorageClass("class-1", "foo.bar/baz"), test.claim)
+		client := fake.NewSimpleClientset(objs...)
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newTopologyTestProvisioner(), "v1.5.0")
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		time.Sleep(2 * resyncPeriod)
+		waitForSync(ctrl)
+
+		volume, err := client.Core().PersistentVolumes().Get("pvc-uid-1-1", metav1.GetOptions{})
+		if saved := err == nil; test.expectedSaved != saved {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume saved %v but got %v\n", test.expectedSaved, saved)
+		} else if saved && test.expectedAffinity != volume.Annotations[v1.AlphaStorageNodeAffinityAnnotation] {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected node affinity %v but got %v\n", test.expectedAffinity, volume.Annotations[v1.AlphaStorageNodeAffinityAnnotation])
+		}
+		close(stopCh)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	tests := []struct {
+		name string
+		objs []runtime.Object
+	}{
+		{
+			name: "don't provision for claim-1",
+			objs: []runtime.Object{
+				newStorageClass("class-1", "foo.bar/baz"),
+				newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			},
+		},
+		{
+			name: "don't delete volume-1",
+			objs: []runtime.Object{
+				newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			},
+		},
+		{
+			name: "don't resize volume-1",
+			objs: []runtime.Object{
+				newClaimWithRequest(newClaim("claim-1", "uid-1-1", "class-1", "volume-1", nil), "2Mi"),
+				newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			},
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(test.objs...)
+		provisioner := newTestResizer()
+		ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0")
+		DryRun(true)(ctrl)
+		recorder := record.NewFakeRecorder(10)
+		ctrl.eventRecorder = recorder
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		time.Sleep(5 * resyncPeriod)
+		waitForSync(ctrl)
+
+		if len(provisioner.provisionCalls) != 0 {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected no provision calls but got %v\n", len(provisioner.provisionCalls))
+		}
+		// Resyncs must not repeat the event
+		if len(recorder.Events) != 1 {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected 1 dry run event but got %v\n", len(recorder.Events))
+		}
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "get" || action.GetVerb() == "list" || action.GetVerb() == "watch" {
+				continue
+			}
+			if resource := action.GetResource().Resource; resource == "persistentvolumes" || resource == "persistentvolumeclaims" {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected no modifications but got %s %s\n", action.GetVerb(), resource)
+			}
+		}
+		close(stopCh)
+	}
+}
+
+func TestFinalizer(t *testing.T) {
+	deletionTimestamp := metav1.Now()
+	tests := []struct {
+		name               string
+		objs               []runtime.Object
+		volumeName         string
+		expectedExists     bool
+		expectedFinalizers []string
+	}{
+		{
+			name: "add finalizer to volume provisioned for claim-1",
+			objs: []runtime.Object{
+				newStorageClass("class-1", "foo.bar/baz"),
+				newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			},
+			volumeName:         "pvc-uid-1-1",
+			expectedExists:     true,
+			expectedFinalizers: []string{finalizerPV},
+		},
+		{
+			name: "delete released volume-1 with finalizer",
+			objs: []runtime.Object{
+				newVolumeWithFinalizer(newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}), nil),
+			},
+			volumeName:     "volume-1",
+			expectedExists: false,
+		},
+		{
+			name: "remove finalizer from deleted released volume-1 after deleting asset",
+			objs: []runtime.Object{
+				newVolumeWithFinalizer(newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}), &deletionTimestamp),
+			},
+			volumeName:         "volume-1",
+			expectedExists:     true,
+			expectedFinalizers: nil,
+		},
+		{
+			name: "keep finalizer on deleted bound volume-1",
+			objs: []runtime.Object{
+				newVolumeWithFinalizer(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}), &deletionTimestamp),
+			},
+			volumeName:         "volume-1",
+			expectedExists:     true,
+			expectedFinalizers: []string{finalizerPV},
+		},
+		{
+			name: "remove finalizer from deleted retained volume-1",
+			objs: []runtime.Object{
+				newVolumeWithFinalizer(newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimRetain, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}), &deletionTimestamp),
+			},
+			volumeName:         "volume-1",
+			expectedExists:     true,
+			expectedFinalizers: nil,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(test.objs...)
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newTestProvisioner(), "v1.5.0")
+		AddFinalizer(true)(ctrl)
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		time.Sleep(2 * resyncPeriod)
+		waitForSync(ctrl)
+
+		volume, err := client.Core().PersistentVolumes().Get(test.volumeName, metav1.GetOptions{})
+		if exists := err == nil; test.expectedExists != exists {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume exists %v but got %v\n", test.expectedExists, exists)
+		} else if exists && !reflect.DeepEqual(test.expectedFinalizers, volume.Finalizers) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected finalizers %v but got %v\n", test.expectedFinalizers, volume.Finalizers)
+		}
+		close(stopCh)
+	}
+}
+
+func TestReconcileOrphans(t *testing.T) {
+	tests := []struct {
+		name            string
+		deleteOrphans   bool
+		dryRun          bool
+		perClaim        bool
+		passes          int
+		expectedDeleted []string
+	}{
+		{
+			name:            "don't delete orphan found in one pass",
+			deleteOrphans:   true,
+			passes:          1,
+			expectedDeleted: nil,
+		},
+		{
+			name:            "delete orphan found in two passes",
+			deleteOrphans:   true,
+			passes:          2,
+			expectedDeleted: []string{"volume-2"},
+		},
+		{
+			name:            "only report orphan if DeleteOrphans is not set",
+			deleteOrphans:   false,
+			passes:          2,
+			expectedDeleted: nil,
+		},
+		{
+			name:            "only report orphan in dry-run mode",
+			deleteOrphans:   true,
+			dryRun:          true,
+			passes:          2,
+			expectedDeleted: nil,
+		},
+		{
+			name:            "only report orphan in PerClaim mode",
+			deleteOrphans:   true,
+			perClaim:        true,
+			passes:          2,
+			expectedDeleted: nil,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}))
+		provisioner := &listerTestProvisioner{testProvisioner: newTestProvisioner(), assets: []string{"volume-1", "volume-2"}}
+		ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0")
+		DeleteOrphans(test.deleteOrphans)(ctrl)
+		DryRun(test.dryRun)(ctrl)
+		if !test.perClaim {
+			LeaderElection(LeaderElectionController)(ctrl)
+			ctrl.setLeading(true)
+		}
+		for i := 0; i < test.passes; i++ {
+			ctrl.reconcileOrphans()
+		}
+		if !reflect.DeepEqual(test.expectedDeleted, provisioner.deleted) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected deleted assets %v but got %v\n", test.expectedDeleted, provisioner.deleted)
+		}
+	}
+}
+
+func TestReconcileOrphansOfClaims(t *testing.T) {
+	tests := []struct {
+		name            string
+		claim           *v1.PersistentVolumeClaim
+		cached          bool
+		claimRef        bool
+		namespaces      []string
+		expectedDeleted []string
+	}{
+		{
+			name:            "don't delete asset of claim being provisioned asynchronously",
+			claim:           newClaim("claim-1", "uid-1-1", "class-1", "", map[string]string{annProvisionHandle: "handle-1"}),
+			cached:          true,
+			expectedDeleted: nil,
+		},
+		{
+			name:            "don't delete asset of pending claim that may adopt it",
+			claim:           newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			cached:          true,
+			expectedDeleted: nil,
+		},
+		{
+			name:            "delete asset of claim bound to another volume",
+			claim:           newClaim("claim-1", "uid-1-1", "class-1", "volume-1", nil),
+			cached:          true,
+			expectedDeleted: []string{"pvc-uid-1-1"},
+		},
+		{
+			name:            "don't delete asset of pending claim out of scope",
+			claim:           newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			namespaces:      []string{"other"},
+			expectedDeleted: nil,
+		},
+		{
+			name:            "don't delete asset whose claim ref is pending but not cached",
+			claim:           newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			claimRef:        true,
+			namespaces:      []string{"other"},
+			expectedDeleted: nil,
+		},
+		{
+			name:            "delete asset whose claim ref is bound to another volume",
+			claim:           newClaim("claim-1", "uid-1-1", "class-1", "volume-1", nil),
+			claimRef:        true,
+			namespaces:      []string{"other"},
+			expectedDeleted: []string{"pvc-uid-1-1"},
+		},
+		{
+			name:            "delete asset whose claim ref was recreated",
+			claim:           newClaim("claim-1", "uid-1-2", "class-1", "", nil),
+			claimRef:        true,
+			namespaces:      []string{"other"},
+			expectedDeleted: []string{"pvc-uid-1-1"},
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(test.claim)
+		provisioner := &listerTestProvisioner{testProvisioner: newTestProvisioner(), assets: []string{"pvc-uid-1-1"}}
+		if test.claimRef {
+			provisioner.claimRef = &v1.ObjectReference{Namespace: v1.NamespaceDefault, Name: "claim-1", UID: "uid-1-1"}
+		}
+		ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0", Namespaces(test.namespaces))
+		DeleteOrphans(true)(ctrl)
+		LeaderElection(LeaderElectionController)(ctrl)
+		ctrl.setLeading(true)
+		if test.cached {
+			ctrl.claims.Add(test.claim)
+		}
+		for i := 0; i < 2; i++ {
+			ctrl.reconcileOrphans()
+		}
+		if !reflect.DeepEqual(test.expectedDeleted, provisioner.deleted) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected deleted assets %v but got %v\n", test.expectedDeleted, provisioner.deleted)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name           string
+		gracePeriod    time.Duration
+		finish         bool
+		expectedVolume bool
+	}{
+		{
+			name:           "wait for in-flight provision to finish",
+			gracePeriod:    time.Second,
+			finish:         true,
+			expectedVolume: true,
+		},
+		{
+			name:           "stop waiting after grace period",
+			gracePeriod:    100 * time.Millisecond,
+			finish:         false,
+			expectedVolume: false,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(
+			newStorageClass("class-1", "foo.bar/baz"),
+			newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		)
+		provisioner := newBlockingTestProvisioner()
+		ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0", ShutdownGracePeriod(test.gracePeriod))
+
+		stopCh := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			ctrl.Run(stopCh)
+			close(stopped)
+		}()
+
+		select {
+		case <-provisioner.started:
+		case <-time.After(10 * resyncPeriod):
+			t.Fatalf("timed out waiting for provision to start")
+		}
+		close(stopCh)
+
+		select {
+		case <-stopped:
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected Run to wait for in-flight provision")
+		case <-time.After(test.gracePeriod / 2):
+		}
+		if test.finish {
+			close(provisioner.finish)
+		}
+		select {
+		case <-stopped:
+		case <-time.After(test.gracePeriod):
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected Run to return")
+		}
+
+		_, err := client.Core().PersistentVolumes().Get("pvc-uid-1-1", metav1.GetOptions{})
+		if test.expectedVolume != (err == nil) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume saved %v but got error %v", test.expectedVolume, err)
+		}
+		if !test.finish {
+			close(provisioner.finish)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	class := newStorageClass("class-1", "foo.bar/baz")
+	class.Parameters = map[string]string{paramMaxVolumesPerNamespace: "1"}
+	client := fake.NewSimpleClientset(class)
+	provisioner := newTestProvisioner()
+	ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0")
+	ctrl.classes.Add(class)
+
+	claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+	if err := ctrl.provisionClaimOperation(claim); err != nil {
+		t.Fatalf("unexpected error provisioning within quota: %v", err)
+	}
+	volume, err := client.Core().PersistentVolumes().Get("pvc-uid-1-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting provisioned volume: %v", err)
+	}
+	ctrl.volumes.Add(volume)
+
+	err = ctrl.provisionClaimOperation(newClaim("claim-2", "uid-2-1", "class-1", "", nil))
+	if _, ok := err.(*TransientError); !ok {
+		t.Errorf("expected TransientError provisioning over quota but got %v", err)
+	}
+	if _, err := client.Core().PersistentVolumes().Get("pvc-uid-2-1", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected no volume to be provisioned over quota")
+	}
+	if len(provisioner.provisionCalls) != 1 {
+		t.Errorf("expected 1 call to Provision but got %d", len(provisioner.provisionCalls))
+	}
+}
+
+func TestGetStorageClassPolicy(t *testing.T) {
+	tests := []struct {
+		name                  string
+		class                 string
+		serverGitVersion      string
+		expectedReclaimPolicy v1.PersistentVolumeReclaimPolicy
+		expectedMountOptions  []string
+		expectedBindingMode   string
+		expectedTopologies    []topologySelectorTerm
+	}{
+		{
+			name:                  "1.7 has no policy",
+			class:                 `{"provisioner": "foo.bar/baz", "reclaimPolicy": "Retain"}`,
+			serverGitVersion:      "v1.7.0",
+			expectedReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			expectedMountOptions:  nil,
+		},
+		{
+			name:                  "1.8 defaults",
+			class:                 `{"provisioner": "foo.bar/baz"}`,
+			serverGitVersion:      "v1.8.0",
+			expectedReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			expectedMountOptions:  nil,
+		},
+		{
+			name:                  "1.8 retain with mount options",
+			class:                 `{"provisioner": "foo.bar/baz", "reclaimPolicy": "Retain", "mountOptions": ["ro", "soft"]}`,
+			serverGitVersion:      "v1.8.0",
+			expectedReclaimPolicy: v1.PersistentVolumeReclaimRetain,
+			expectedMountOptions:  []string{"ro", "soft"},
+		},
+		{
+			name:                  "delayed binding with allowed topologies",
+			class:                 `{"provisioner": "foo.bar/baz", "volumeBindingMode": "WaitForFirstConsumer", "allowedTopologies": [{"matchLabelExpressions": [{"key": "zone", "values": ["a", "b"]}]}]}`,
+			serverGitVersion:      "v1.12.0",
+			expectedReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			expectedMountOptions:  nil,
+			expectedBindingMode:   volumeBindingWaitForFirstConsumer,
+			expectedTopologies: []topologySelectorTerm{
+				{MatchLabelExpressions: []topologySelectorLabelRequirement{{Key: "zone", Values: []string{"a", "b"}}}},
+			},
+		},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/apis/storage.k8s.io/v1/storageclasses/class-1" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, test.class)
+		}))
+		client := kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newTestProvisioner(), test.serverGitVersion)
+
+		policy, err := ctrl.getStorageClassPolicy("class-1")
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error getting class policy: %v", err)
+			server.Close()
+			continue
+		}
+		if test.expectedReclaimPolicy != policy.ReclaimPolicy {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected reclaim policy %v but got %v\n", test.expectedReclaimPolicy, policy.ReclaimPolicy)
+		}
+		if !reflect.DeepEqual(test.expectedMountOptions, policy.MountOptions) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected mount options %v but got %v\n", test.expectedMountOptions, policy.MountOptions)
+		}
+		if test.expectedBindingMode != policy.VolumeBindingMode {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume binding mode %q but got %q\n", test.expectedBindingMode, policy.VolumeBindingMode)
+		}
+		if !reflect.DeepEqual(test.expectedTopologies, policy.AllowedTopologies) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected allowed topologies %v but got %v\n", test.expectedTopologies, policy.AllowedTopologies)
+		}
+		server.Close()
+	}
+}
+
+func TestGetStorageClassPolicyCached(t *testing.T) {
+	var gets int32
+	resourceVersion := "1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&gets, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"metadata": {"name": "class-1", "resourceVersion": %q}, "provisioner": "foo.bar/baz", "reclaimPolicy": "Retain"}`, resourceVersion)
+	}))
+	defer server.Close()
+	client := kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+	ctrl := newTestProvisionController(client, "foo.bar/baz", newTestProvisioner(), "v1.8.0")
+
+	class := newStorageClass("class-1", "foo.bar/baz")
+	class.ResourceVersion = resourceVersion
+	ctrl.classes.Add(class)
+	for i := 0; i < 2; i++ {
+		if _, err := ctrl.getStorageClassPolicy("class-1"); err != nil {
+			t.Fatalf("unexpected error getting class policy: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&gets); n != 1 {
+		t.Errorf("expected 1 GET of an unchanged class but got %d", n)
+	}
+
+	resourceVersion = "2"
+	class = newStorageClass("class-1", "foo.bar/baz")
+	class.ResourceVersion = resourceVersion
+	ctrl.classes.Update(class)
+	policy, err := ctrl.getStorageClassPolicy("class-1")
+	if err != nil {
+		t.Fatalf("unexpected error getting class policy: %v", err)
+	}
+	if n := atomic.LoadInt32(&gets); n != 2 {
+		t.Errorf("expected 2 GETs after the class changed but got %d", n)
+	}
+	if policy.ReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+		t.Errorf("expected reclaim policy %v but got %v", v1.PersistentVolumeReclaimRetain, policy.ReclaimPolicy)
+	}
+}
+
+// waitForSync waits until ctrl has no queued or in-flight claims & volumes
+func waitForSync(ctrl *ProvisionController) {
+	for ctrl.claimQueue.Len() > 0 || ctrl.volumeQueue.Len() > 0 || atomic.LoadInt64(&ctrl.metrics.operationsInFlight) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctrl.runningOperations.Wait()
+}
+
+func newTestProvisionController(
+	client kubernetes.Interface,
+	provisionerName string,
+	provisioner Provisioner,
+	serverGitVersion string,
+	options ...func(*ProvisionController) error,
+) *ProvisionController {
+	ctrl := NewProvisionController(
+		client,
+		provisionerName,
+		provisioner,
+		serverGitVersion,
+		append([]func(*ProvisionController) error{
+			ResyncPeriod(resyncPeriod),
+			ExponentialBackOffOnError(false),
+			CreateProvisionedPVInterval(10 * time.Millisecond),
+			LeaseDuration(2 * resyncPeriod),
+			RenewDeadline(resyncPeriod),
+			RetryPeriod(resyncPeriod / 2),
+			TermLimit(2 * resyncPeriod),
+		}, options...)...)
+	return ctrl
+}
+
+func newStorageClass(name, provisioner string) *storagebeta.StorageClass {
+	return &storagebeta.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Provisioner: provisioner,
+	}
+}
+
+func newClaim(name, claimUID, provisioner, volumeName string, annotations map[string]string) *v1.PersistentVolumeClaim {
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       v1.NamespaceDefault,
+			UID:             types.UID(claimUID),
+			ResourceVersion: "0",
+			Annotations:     map[string]string{annClass: provisioner},
+			SelfLink:        "/api/v1/namespaces/" + v1.NamespaceDefault + "/persistentvolumeclaims/" + name,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadOnlyMany},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Mi"),
+				},
+			},
+			VolumeName: volumeName,
+		},
+		Status: v1.PersistentVolumeClaimStatus{
+			Phase: v1.ClaimPending,
+		},
+	}
+	for k, v := range annotations {
+		claim.Annotations[k] = v
+	}
+	return claim
+}
+
+func newClaimWithSelector(claim *v1.PersistentVolumeClaim, selector *metav1.LabelSelector) *v1.PersistentVolumeClaim {
+	claim.Spec.Selector = selector
+	return claim
+}
+
+func newClaimWithNamespace(claim *v1.PersistentVolumeClaim, namespace string) *v1.PersistentVolumeClaim {
+	claim.Namespace = namespace
+	claim.SelfLink = "/api/v1/namespaces/" + namespace + "/persistentvolumeclaims/" + claim.Name
+	return claim
+}
+
+func newClaimWithLabels(claim *v1.PersistentVolumeClaim, labels map[string]string) *v1.PersistentVolumeClaim {
+	claim.Labels = labels
+	return claim
+}
+
+func newClaimWithRequest(claim *v1.PersistentVolumeClaim, request string) *v1.PersistentVolumeClaim {
+	claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)] = resource.MustParse(request)
+	return claim
+}
+
+func newVolume(name string, phase v1.PersistentVolumePhase, policy v1.PersistentVolumeReclaimPolicy, annotations map[string]string) *v1.PersistentVolume {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: annotations,
+			SelfLink:    "/api/v1/persistentvolumes/" + name,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: policy,
+			AccessModes:                   []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadOnlyMany},
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Mi"),
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
 				NFS: &v1.NFSVolumeSource{
 					Server:   "foo",
 					Path:     "bar",
@@ -541,8 +2011,19 @@ func newVolume(name string, phase v1.PersistentVolumePhase, policy v1.Persistent
 	return pv
 }
 
+func newVolumeWithClaimRef(volume *v1.PersistentVolume, namespace, name string) *v1.PersistentVolume {
+	volume.Spec.ClaimRef = &v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: namespace, Name: name}
+	return volume
+}
+
 // newProvisionedVolume returns the volume the test controller should provision for the
 // given claim with the given class
+func newVolumeWithFinalizer(volume *v1.PersistentVolume, deletionTimestamp *metav1.Time) *v1.PersistentVolume {
+	volume.Finalizers = []string{finalizerPV}
+	volume.DeletionTimestamp = deletionTimestamp
+	return volume
+}
+
 func newProvisionedVolume(storageClass *storagebeta.StorageClass, claim *v1.PersistentVolumeClaim) *v1.PersistentVolume {
 	// pv.Spec MUST be set to match requirements in claim.Spec, especially access mode and PV size. The provisioned volume size MUST NOT be smaller than size requested in the claim, however it MAY be larger.
 	options := VolumeOptions{
@@ -614,6 +2095,207 @@ func (p *testProvisioner) Delete(volume *v1.PersistentVolume) error {
 	return nil
 }
 
+func newTestResizer() *testResizer {
+	return &testResizer{newTestProvisioner()}
+}
+
+// listerTestProvisioner lists the given assets, with the given claim ref if
+// any, & records Delete calls
+type listerTestProvisioner struct {
+	*testProvisioner
+	assets   []string
+	claimRef *v1.ObjectReference
+	deleted  []string
+}
+
+var _ Lister = &listerTestProvisioner{}
+
+func (p *listerTestProvisioner) List() ([]*v1.PersistentVolume, error) {
+	volumes := []*v1.PersistentVolume{}
+	for _, asset := range p.assets {
+		volumes = append(volumes, &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: asset}, Spec: v1.PersistentVolumeSpec{ClaimRef: p.claimRef}})
+	}
+	return volumes, nil
+}
+
+func (p *listerTestProvisioner) Delete(volume *v1.PersistentVolume) error {
+	p.deleted = append(p.deleted, volume.Name)
+	return nil
+}
+
+type testResizer struct {
+	*testProvisioner
+}
+
+var _ Provisioner = &testResizer{}
+var _ Resizer = &testResizer{}
+
+func (p *testResizer) Resize(volume *v1.PersistentVolume, newSize resource.Quantity) (resource.Quantity, error) {
+	return newSize, nil
+}
+
+func newConcurrencyTestProvisioner() *concurrencyTestProvisioner {
+	return &concurrencyTestProvisioner{testProvisioner: &testProvisioner{make(chan bool, 64)}}
+}
+
+// concurrencyTestProvisioner records the maximum number of concurrent
+// Provision calls
+type concurrencyTestProvisioner struct {
+	*testProvisioner
+	mutex       sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+var _ Provisioner = &concurrencyTestProvisioner{}
+
+func (p *concurrencyTestProvisioner) Provision(options VolumeOptions) (*v1.PersistentVolume, error) {
+	p.mutex.Lock()
+	p.inFlight++
+	if p.inFlight > p.maxInFlight {
+		p.maxInFlight = p.inFlight
+	}
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		p.inFlight--
+		p.mutex.Unlock()
+	}()
+	return p.testProvisioner.Provision(options)
+}
+
+func newTopologyTestProvisioner() *topologyTestProvisioner {
+	return &topologyTestProvisioner{newTestProvisioner()}
+}
+
+// topologyTestProvisioner provisions volumes accessible from the zone of the
+// selected node
+type topologyTestProvisioner struct {
+	*testProvisioner
+}
+
+var _ Provisioner = &topologyTestProvisioner{}
+
+func (p *topologyTestProvisioner) Provision(options VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.SelectedNode == nil {
+		return nil, errors.New("no selected node")
+	}
+	term, err := NodeSelectorTermForNode(options.SelectedNode, "zone")
+	if err != nil {
+		return nil, err
+	}
+	volume, err := p.testProvisioner.Provision(options)
+	if err != nil {
+		return nil, err
+	}
+	return volume, SetNodeAffinity(volume, []v1.NodeSelectorTerm{term})
+}
+
+// existerTestProvisioner reports the asset for every claim as existing or
+// not & records the asset name it is asked about
+type existerTestProvisioner struct {
+	*testProvisioner
+	exists    bool
+	mutex     sync.Mutex
+	assetName string
+}
+
+var _ Exister = &existerTestProvisioner{}
+
+func (p *existerTestProvisioner) Exists(options VolumeOptions) (*v1.PersistentVolume, error) {
+	p.mutex.Lock()
+	p.assetName = options.AssetName
+	p.mutex.Unlock()
+	if !p.exists {
+		return nil, nil
+	}
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+		},
+	}, nil
+}
+
+// asyncTestProvisioner starts provisions that finish after the given number
+// of ProvisionStatus polls & records Provision calls
+type asyncTestProvisioner struct {
+	*testProvisioner
+	mutex      sync.Mutex
+	provisions int
+	polls      int
+}
+
+var _ AsyncProvisioner = &asyncTestProvisioner{}
+
+func (p *asyncTestProvisioner) Provision(options VolumeOptions) (*v1.PersistentVolume, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.provisions++
+	return nil, &InProgressError{Reason: "creating", RetryAfter: 10 * time.Millisecond, Handle: "handle-1"}
+}
+
+func (p *asyncTestProvisioner) ProvisionStatus(handle string, options VolumeOptions) (*v1.PersistentVolume, error) {
+	p.mutex.Lock()
+	if p.polls > 0 {
+		p.polls--
+		p.mutex.Unlock()
+		return nil, &InProgressError{Reason: "creating", RetryAfter: 10 * time.Millisecond}
+	}
+	p.mutex.Unlock()
+	return p.testProvisioner.Provision(options)
+}
+
+func newBlockingTestProvisioner() *blockingTestProvisioner {
+	return &blockingTestProvisioner{
+		testProvisioner: newTestProvisioner(),
+		started:         make(chan struct{}),
+		finish:          make(chan struct{}),
+	}
+}
+
+// blockingTestProvisioner closes started when Provision is first called and
+// blocks every Provision until finish is closed
+type blockingTestProvisioner struct {
+	*testProvisioner
+	startOnce sync.Once
+	started   chan struct{}
+	finish    chan struct{}
+}
+
+var _ Provisioner = &blockingTestProvisioner{}
+
+func (p *blockingTestProvisioner) Provision(options VolumeOptions) (*v1.PersistentVolume, error) {
+	p.startOnce.Do(func() { close(p.started) })
+	<-p.finish
+	return p.testProvisioner.Provision(options)
+}
+
+func newErrorTestProvisioner(err error) Provisioner {
+	return &errorTestProvisioner{err}
+}
+
+// errorTestProvisioner fails every Provision with the given error
+type errorTestProvisioner struct {
+	err error
+}
+
+var _ Provisioner = &errorTestProvisioner{}
+
+func (p *errorTestProvisioner) Provision(options VolumeOptions) (*v1.PersistentVolume, error) {
+	return nil, p.err
+}
+
+func (p *errorTestProvisioner) Delete(volume *v1.PersistentVolume) error {
+	return nil
+}
+
 func newBadTestProvisioner() Provisioner {
 	return &badTestProvisioner{}
 }
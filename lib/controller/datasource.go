@@ -0,0 +1,76 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// annDataSource annotation requests that a PVC's volume be provisioned as a
+// clone of another PVC's volume. Its value is the name of the PVC to clone,
+// which must be in the same namespace: users can only clone claims they could
+// mount anyway. That is the whole permission check, there is no
+// SubjectAccessReview since a claim doesn't record who created it.
+const annDataSource = "controller.external-storage.incubator.kubernetes.io/data-source"
+
+// getDataSource returns the claim's data source or nil if it has none. It
+// returns a FinalError if the data source can never be cloned, e.g. because
+// it is another provisioner's volume, and any other error if it can't be
+// cloned yet, e.g. because the source claim is not bound.
+func (ctrl *ProvisionController) getDataSource(claim *v1.PersistentVolumeClaim, provisionerName string, provisioner Provisioner) (*VolumeDataSource, error) {
+	sourceName, found := claim.Annotations[annDataSource]
+	if !found {
+		return nil, nil
+	}
+	if _, ok := provisioner.(Cloner); !ok {
+		return nil, &FinalError{Reason: fmt.Sprintf("provisioner %q does not support cloning", provisionerName)}
+	}
+	if sourceName == claim.Name {
+		return nil, &FinalError{Reason: "claim can't be cloned from itself"}
+	}
+
+	sourceClaim, err := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Get(sourceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting source claim %q: %v", sourceName, err)
+	}
+	if sourceClaim.Status.Phase != v1.ClaimBound || sourceClaim.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("source claim %q is not bound", sourceName)
+	}
+	sourceVolume, err := ctrl.client.Core().PersistentVolumes().Get(sourceClaim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting source claim %q's volume %q: %v", sourceName, sourceClaim.Spec.VolumeName, err)
+	}
+	// Guard against the claim's volume having since been bound to another
+	// claim, maybe in another namespace
+	if sourceVolume.Spec.ClaimRef == nil || sourceVolume.Spec.ClaimRef.UID != sourceClaim.UID {
+		return nil, fmt.Errorf("source claim %q's volume %q is not bound to it", sourceName, sourceVolume.Name)
+	}
+	if sourceVolume.Annotations[annDynamicallyProvisioned] != provisionerName {
+		return nil, &FinalError{Reason: fmt.Sprintf("source claim %q's volume %q was not provisioned by %q", sourceName, sourceVolume.Name, provisionerName)}
+	}
+
+	requested := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	sourceCapacity := sourceVolume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	if requested.Cmp(sourceCapacity) < 0 {
+		return nil, &FinalError{Reason: fmt.Sprintf("requested size %s is smaller than source claim %q's volume size %s", requested.String(), sourceName, sourceCapacity.String())}
+	}
+
+	return &VolumeDataSource{PVC: sourceClaim, PV: sourceVolume}, nil
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetDataSource(t *testing.T) {
+	tests := []struct {
+		name             string
+		objs             []runtime.Object
+		claim            *v1.PersistentVolumeClaim
+		provisioner      Provisioner
+		expectedSource   string
+		expectError      bool
+		expectFinalError bool
+	}{
+		{
+			name:        "no data source",
+			claim:       newClaim("claim-2", "uid-2-1", "class-1", "", nil),
+			provisioner: newCloneTestProvisioner(),
+		},
+		{
+			name: "clone",
+			objs: []runtime.Object{
+				newBoundClaim("claim-1", "uid-1-1", "volume-1"),
+				newVolumeWithClaimUID(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}), "uid-1-1"),
+			},
+			claim:          newClaim("claim-2", "uid-2-1", "class-1", "", map[string]string{annDataSource: "claim-1"}),
+			provisioner:    newCloneTestProvisioner(),
+			expectedSource: "volume-1",
+		},
+		{
+			name: "provisioner does not support cloning",
+			objs: []runtime.Object{
+				newBoundClaim("claim-1", "uid-1-1", "volume-1"),
+				newVolumeWithClaimUID(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}), "uid-1-1"),
+			},
+			claim:            newClaim("claim-2", "uid-2-1", "class-1", "", map[string]string{annDataSource: "claim-1"}),
+			provisioner:      newTestProvisioner(),
+			expectError:      true,
+			expectFinalError: true,
+		},
+		{
+			name:        "source claim not found",
+			claim:       newClaim("claim-2", "uid-2-1", "class-1", "", map[string]string{annDataSource: "claim-1"}),
+			provisioner: newCloneTestProvisioner(),
+			expectError: true,
+		},
+		{
+			name: "source claim in another namespace",
+			objs: []runtime.Object{
+				newClaimWithNamespace(newBoundClaim("claim-1", "uid-1-1", "volume-1"), "other"),
+				newVolumeWithClaimUID(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}), "uid-1-1"),
+			},
+			claim:       newClaim("claim-2", "uid-2-1", "class-1", "", map[string]string{annDataSource: "claim-1"}),
+			provisioner: newCloneTestProvisioner(),
+			expectError: true,
+		},
+		{
+			name: "source claim not bound",
+			objs: []runtime.Object{
+				newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			},
+			claim:       newClaim("claim-2", "uid-2-1", "class-1", "", map[string]string{annDataSource: "claim-1"}),
+			provisioner: newCloneTestProvisioner(),
+			expectError: true,
+		},
+		{
+			name: "source volume bound to another claim",
+			objs: []runtime.Object{
+				newBoundClaim("claim-1", "uid-1-1", "volume-1"),
+				newVolumeWithClaimUID(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}), "uid-3-1"),
+			},
+			claim:       newClaim("claim-2", "uid-2-1", "class-1", "", map[string]string{annDataSource: "claim-1"}),
+			provisioner: newCloneTestProvisioner(),
+			expectError: true,
+		},
+		{
+			name: "source volume of another provisioner",
+			objs: []runtime.Object{
+				newBoundClaim("claim-1", "uid-1-1", "volume-1"),
+				newVolumeWithClaimUID(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "abc.def/ghi"}), "uid-1-1"),
+			},
+			claim:            newClaim("claim-2", "uid-2-1", "class-1", "", map[string]string{annDataSource: "claim-1"}),
+			provisioner:      newCloneTestProvisioner(),
+			expectError:      true,
+			expectFinalError: true,
+		},
+		{
+			name: "source volume bigger than requested",
+			objs: []runtime.Object{
+				newBoundClaim("claim-1", "uid-1-1", "volume-1"),
+				newVolumeWithClaimUID(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}), "uid-1-1"),
+			},
+			claim:            newClaimWithRequest(newClaim("claim-2", "uid-2-1", "class-1", "", map[string]string{annDataSource: "claim-1"}), "1Ki"),
+			provisioner:      newCloneTestProvisioner(),
+			expectError:      true,
+			expectFinalError: true,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(test.objs...)
+		ctrl := newTestProvisionController(client, "foo.bar/baz", test.provisioner, "v1.5.0")
+
+		source, err := ctrl.getDataSource(test.claim, "foo.bar/baz", test.provisioner)
+		if test.expectError != (err != nil) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error %v but got %v\n", test.expectError, err)
+		}
+		if _, ok := err.(*FinalError); ok != test.expectFinalError {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected FinalError %v but got %v\n", test.expectFinalError, err)
+		}
+		sourceVolume := ""
+		if source != nil {
+			sourceVolume = source.PV.Name
+		}
+		if sourceVolume != test.expectedSource {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected source volume %q but got %q\n", test.expectedSource, sourceVolume)
+		}
+	}
+}
+
+func TestProvisionClone(t *testing.T) {
+	class := newStorageClass("class-1", "foo.bar/baz")
+	client := fake.NewSimpleClientset(
+		class,
+		newBoundClaim("claim-1", "uid-1-1", "volume-1"),
+		newVolumeWithClaimUID(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}), "uid-1-1"),
+	)
+	provisioner := newCloneTestProvisioner()
+	ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0")
+	ctrl.classes.Add(class)
+
+	claim := newClaim("claim-2", "uid-2-1", "class-1", "", map[string]string{annDataSource: "claim-1"})
+	if err := ctrl.provisionClaimOperation(claim); err != nil {
+		t.Fatalf("unexpected error provisioning clone: %v", err)
+	}
+	if _, err := client.Core().PersistentVolumes().Get("pvc-uid-2-1", metav1.GetOptions{}); err != nil {
+		t.Errorf("error getting cloned volume: %v", err)
+	}
+	if len(provisioner.cloned) != 1 || provisioner.cloned[0] != "volume-1" {
+		t.Errorf("expected volume-1 to be cloned but got %v", provisioner.cloned)
+	}
+	if len(provisioner.provisionCalls) != 0 {
+		t.Errorf("expected no calls to Provision but got %d", len(provisioner.provisionCalls))
+	}
+}
+
+func newBoundClaim(name, claimUID, volumeName string) *v1.PersistentVolumeClaim {
+	claim := newClaim(name, claimUID, "class-1", volumeName, nil)
+	claim.Status.Phase = v1.ClaimBound
+	return claim
+}
+
+func newVolumeWithClaimUID(volume *v1.PersistentVolume, claimUID string) *v1.PersistentVolume {
+	volume.Spec.ClaimRef = &v1.ObjectReference{Kind: "PersistentVolumeClaim", UID: types.UID(claimUID)}
+	return volume
+}
+
+func newCloneTestProvisioner() *cloneTestProvisioner {
+	return &cloneTestProvisioner{testProvisioner: newTestProvisioner()}
+}
+
+// cloneTestProvisioner records the volumes it clones
+type cloneTestProvisioner struct {
+	*testProvisioner
+	cloned []string
+}
+
+var _ Cloner = &cloneTestProvisioner{}
+
+func (p *cloneTestProvisioner) Clone(options VolumeOptions) (*v1.PersistentVolume, error) {
+	p.cloned = append(p.cloned, options.DataSource.PV.Name)
+	volume, err := p.testProvisioner.Provision(options)
+	<-p.provisionCalls
+	return volume, err
+}
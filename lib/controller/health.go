@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// healthStatus is the body of the controller's health check responses
+type healthStatus struct {
+	// Whether the claim, volume & class caches have synced
+	Synced bool `json:"synced"`
+	// When the last provision, delete or resize succeeded, if any has
+	LastSuccessfulOperation *time.Time `json:"lastSuccessfulOperation,omitempty"`
+	// Errors returned by the provisioners' HealthCheck, keyed by provisioner
+	// name
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// recordSuccess records that a provision, delete or resize just succeeded
+func (m *controllerMetrics) recordSuccess() {
+	atomic.StoreInt64(&m.lastSuccessTime, time.Now().UnixNano())
+}
+
+// serveHealth serves the controller's health checks on healthPort until the
+// process exits.
+func (ctrl *ProvisionController) serveHealth() {
+	mux := http.NewServeMux()
+	ctrl.handleHealth(mux)
+	addr := fmt.Sprintf(":%d", ctrl.healthPort)
+	glog.Infof("Serving health checks on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		glog.Errorf("Error serving health checks on %s: %v", addr, err)
+	}
+}
+
+// handleHealth registers the liveness check at /healthz, which fails if any
+// provisioner's HealthCheck does, and the readiness check at /readyz, which
+// also fails until the caches have synced.
+func (ctrl *ProvisionController) handleHealth(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		status := ctrl.healthStatus()
+		writeHealthStatus(w, status, len(status.Errors) == 0)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		status := ctrl.healthStatus()
+		writeHealthStatus(w, status, len(status.Errors) == 0 && status.Synced)
+	})
+}
+
+func writeHealthStatus(w http.ResponseWriter, status *healthStatus, healthy bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		glog.Errorf("Error writing health status: %v", err)
+	}
+}
+
+// healthStatus checks the caches & provisioners
+func (ctrl *ProvisionController) healthStatus() *healthStatus {
+	status := &healthStatus{Synced: ctrl.hasSynced()}
+	if nanos := atomic.LoadInt64(&ctrl.metrics.lastSuccessTime); nanos > 0 {
+		lastSuccess := time.Unix(0, nanos)
+		status.LastSuccessfulOperation = &lastSuccess
+	}
+
+	names := make([]string, 0, len(ctrl.provisioners))
+	for name := range ctrl.provisioners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		checker, ok := ctrl.provisioners[name].(HealthChecker)
+		if !ok {
+			continue
+		}
+		if err := checker.HealthCheck(); err != nil {
+			glog.Warningf("Health check of provisioner %q failed: %v", name, err)
+			if status.Errors == nil {
+				status.Errors = make(map[string]string)
+			}
+			status.Errors[name] = err.Error()
+		}
+	}
+
+	return status
+}
+
+// hasSynced returns whether the claim, volume & class caches have synced,
+// whether the controller or the caller runs the informers filling them
+func (ctrl *ProvisionController) hasSynced() bool {
+	if ctrl.claimInformer != nil {
+		if !ctrl.claimInformer.HasSynced() {
+			return false
+		}
+	} else if !ctrl.claimController.HasSynced() {
+		return false
+	}
+	if ctrl.volumeInformer != nil {
+		if !ctrl.volumeInformer.HasSynced() {
+			return false
+		}
+	} else if !ctrl.volumeController.HasSynced() {
+		return false
+	}
+	if ctrl.classInformer != nil {
+		return ctrl.classInformer.HasSynced()
+	}
+	return ctrl.classReflector.LastSyncResourceVersion() != ""
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	storagebeta "k8s.io/api/storage/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		name              string
+		sync              bool
+		healthErr         error
+		succeed           bool
+		expectedLiveness  int
+		expectedReadiness int
+	}{
+		{
+			name:              "not synced",
+			sync:              false,
+			expectedLiveness:  http.StatusOK,
+			expectedReadiness: http.StatusServiceUnavailable,
+		},
+		{
+			name:              "synced",
+			sync:              true,
+			succeed:           true,
+			expectedLiveness:  http.StatusOK,
+			expectedReadiness: http.StatusOK,
+		},
+		{
+			name:              "provisioner unhealthy",
+			sync:              true,
+			healthErr:         errors.New("server unreachable"),
+			expectedLiveness:  http.StatusServiceUnavailable,
+			expectedReadiness: http.StatusServiceUnavailable,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		claimInformer := cache.NewSharedInformer(newClaimListWatch(client, nil, ""), &v1.PersistentVolumeClaim{}, 0)
+		volumeInformer := cache.NewSharedInformer(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return client.Core().PersistentVolumes().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return client.Core().PersistentVolumes().Watch(options)
+			},
+		}, &v1.PersistentVolume{}, 0)
+		classInformer := cache.NewSharedInformer(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return client.StorageV1beta1().StorageClasses().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return client.StorageV1beta1().StorageClasses().Watch(options)
+			},
+		}, &storagebeta.StorageClass{}, 0)
+		provisioner := &healthTestProvisioner{testProvisioner: newTestProvisioner(), err: test.healthErr}
+		ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0",
+			ClaimsInformer(claimInformer), VolumesInformer(volumeInformer), ClassesInformer(classInformer))
+
+		stopCh := make(chan struct{})
+		if test.sync {
+			go claimInformer.Run(stopCh)
+			go volumeInformer.Run(stopCh)
+			go classInformer.Run(stopCh)
+			if !cache.WaitForCacheSync(stopCh, claimInformer.HasSynced, volumeInformer.HasSynced, classInformer.HasSynced) {
+				t.Fatalf("timed out waiting for informers to sync")
+			}
+		}
+		if test.succeed {
+			ctrl.metrics.recordSuccess()
+		}
+
+		mux := http.NewServeMux()
+		ctrl.handleHealth(mux)
+		for path, expectedCode := range map[string]int{"/healthz": test.expectedLiveness, "/readyz": test.expectedReadiness} {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			if w.Code != expectedCode {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected %s code %d but got %d", path, expectedCode, w.Code)
+			}
+			status := healthStatus{}
+			if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("error decoding %s body: %v", path, err)
+				continue
+			}
+			if status.Synced != test.sync || (status.LastSuccessfulOperation != nil) != test.succeed || (status.Errors != nil) != (test.healthErr != nil) {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("unexpected %s status %+v", path, status)
+			}
+		}
+		close(stopCh)
+	}
+}
+
+// healthTestProvisioner's HealthCheck returns err
+type healthTestProvisioner struct {
+	*testProvisioner
+	err error
+}
+
+var _ HealthChecker = &healthTestProvisioner{}
+
+func (p *healthTestProvisioner) HealthCheck() error {
+	return p.err
+}
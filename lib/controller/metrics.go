@@ -0,0 +1,156 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller/metrics"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// controllerMetrics are the metrics a ProvisionController records. They are
+// always recorded but only served if option function MetricsPort is set.
+type controllerMetrics struct {
+	registry *metrics.Registry
+
+	// Labeled by provisioner name & StorageClass name
+	provisionTotal       *metrics.CounterVec
+	provisionFailedTotal *metrics.CounterVec
+	provisionDuration    *metrics.HistogramVec
+	deleteTotal          *metrics.CounterVec
+	deleteFailedTotal    *metrics.CounterVec
+	deleteDuration       *metrics.HistogramVec
+
+	// Labeled by provisioner name
+	leaderElectionTotal *metrics.CounterVec
+
+	// Number of claims & volumes currently being synced by workers
+	operationsInFlight int64
+
+	// Number of orphaned storage assets found by the last reconcileOrphans
+	orphanedAssets int64
+
+	// Time in Unix nanoseconds of the last successful provision, delete or
+	// resize, 0 if there has been none
+	lastSuccessTime int64
+}
+
+func newControllerMetrics(ctrl *ProvisionController) *controllerMetrics {
+	registry := metrics.NewRegistry()
+	m := &controllerMetrics{
+		registry: registry,
+		provisionTotal: registry.NewCounterVec(
+			"controller_persistentvolumeclaim_provision_total",
+			"Total number of volumes provisioned, i.e. whose PV was successfully saved.",
+			"provisioner", "class"),
+		provisionFailedTotal: registry.NewCounterVec(
+			"controller_persistentvolumeclaim_provision_failed_total",
+			"Total number of failed provisions, either of the storage asset or of the PV object.",
+			"provisioner", "class"),
+		provisionDuration: registry.NewHistogramVec(
+			"controller_persistentvolumeclaim_provision_duration_seconds",
+			"Latency in seconds of successful provisions, from calling Provision to saving the PV.",
+			metrics.DefBuckets,
+			"provisioner", "class"),
+		deleteTotal: registry.NewCounterVec(
+			"controller_persistentvolume_delete_total",
+			"Total number of volumes deleted, i.e. whose storage asset was successfully deleted.",
+			"provisioner", "class"),
+		deleteFailedTotal: registry.NewCounterVec(
+			"controller_persistentvolume_delete_failed_total",
+			"Total number of failed deletions of storage assets.",
+			"provisioner", "class"),
+		deleteDuration: registry.NewHistogramVec(
+			"controller_persistentvolume_delete_duration_seconds",
+			"Latency in seconds of successful deletions of storage assets.",
+			metrics.DefBuckets,
+			"provisioner", "class"),
+		leaderElectionTotal: registry.NewCounterVec(
+			"controller_leader_election_acquired_total",
+			"Total number of times this controller acquired leadership.",
+			"provisioner"),
+	}
+
+	registry.NewGaugeFunc(
+		"controller_operations_in_flight",
+		"Number of provision, delete, etc. operations currently running.",
+		func() float64 {
+			return float64(atomic.LoadInt64(&m.operationsInFlight))
+		})
+	registry.NewGaugeFunc(
+		"controller_failed_provision_claims",
+		"Number of claims whose provisioning has failed and not yet succeeded.",
+		func() float64 {
+			return float64(countFailures(ctrl.claims.List(), annProvisionFailures))
+		})
+	registry.NewGaugeFunc(
+		"controller_failed_delete_volumes",
+		"Number of volumes whose deletion has failed and not yet succeeded.",
+		func() float64 {
+			return float64(countFailures(ctrl.volumes.List(), annDeleteFailures))
+		})
+	registry.NewGaugeFunc(
+		"controller_orphaned_storage_assets",
+		"Number of storage assets without a PV found by the last orphan reconcile pass.",
+		func() float64 {
+			return float64(atomic.LoadInt64(&m.orphanedAssets))
+		})
+	registry.NewGaugeFunc(
+		"controller_last_successful_operation_timestamp_seconds",
+		"Unix time of the last successful provision, delete or resize, 0 if there has been none.",
+		func() float64 {
+			return float64(atomic.LoadInt64(&m.lastSuccessTime)) / float64(time.Second)
+		})
+
+	return m
+}
+
+// countFailures returns the number of claims or volumes with a failure count
+// annotation
+func countFailures(objs []interface{}, failuresKey string) int {
+	count := 0
+	for _, obj := range objs {
+		objMeta, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		if _, found := objMeta.GetAnnotations()[failuresKey]; found {
+			count++
+		}
+	}
+	return count
+}
+
+// serveMetrics serves the controller's metrics on metricsPort at metricsPath
+// until the process exits.
+func (ctrl *ProvisionController) serveMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle(ctrl.metricsPath, ctrl.metrics.registry)
+	if ctrl.healthPort == ctrl.metricsPort {
+		ctrl.handleHealth(mux)
+	}
+	addr := fmt.Sprintf(":%d", ctrl.metricsPort)
+	glog.Infof("Serving metrics on %s%s", addr, ctrl.metricsPath)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		glog.Errorf("Error serving metrics on %s: %v", addr, err)
+	}
+}
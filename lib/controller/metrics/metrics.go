@@ -0,0 +1,271 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics implements the few Prometheus metric types the provision
+// controller exposes and serves them in the Prometheus text exposition
+// format, so that the library need not vendor the Prometheus client.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, suited to
+// operations that call out to a storage backend.
+var DefBuckets = []float64{.1, .25, .5, 1, 2.5, 5, 10, 15, 30, 60, 120, 300, 600}
+
+// collector is a metric that can write itself in the text exposition format.
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry is a set of metrics served together. It implements http.Handler.
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry creates a new, empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// ServeHTTP writes all metrics of the registry in the text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	collectors := make([]collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mutex.Unlock()
+
+	buf := &bytes.Buffer{}
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// vec holds the label names and per-label-values samples shared by the
+// vector metric types.
+type vec struct {
+	name, help string
+	labels     []string
+
+	mutex sync.Mutex
+	// Map of joined label values to label values & the metric's samples
+	values map[string]*labeledValue
+}
+
+type labeledValue struct {
+	labelValues []string
+	value       interface{}
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*labeledValue),
+	}
+}
+
+// get returns the value for the given label values, creating it with newValue
+// if it doesn't exist yet. Must be called with the mutex held.
+func (v *vec) get(labelValues []string, newValue func() interface{}) interface{} {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values but got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	lv, ok := v.values[key]
+	if !ok {
+		lv = &labeledValue{
+			labelValues: append([]string(nil), labelValues...),
+			value:       newValue(),
+		}
+		v.values[key] = lv
+	}
+	return lv.value
+}
+
+// sorted returns the values sorted by label values, for stable output. Must be
+// called with the mutex held.
+func (v *vec) sorted() []*labeledValue {
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sorted := make([]*labeledValue, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, v.values[key])
+	}
+	return sorted
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec creates a CounterVec and registers it
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labels)}
+	r.register(c)
+	return c
+}
+
+// Inc increments the counter for the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	value := c.get(labelValues, func() interface{} { return new(float64) }).(*float64)
+	*value++
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, lv := range c.sorted() {
+		writeSample(w, c.name, c.labels, lv.labelValues, "", "", *lv.value.(*float64))
+	}
+}
+
+// HistogramVec is a histogram partitioned by label values
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+type histogram struct {
+	// Non-cumulative count of observations per bucket
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates a HistogramVec with the given bucket upper bounds,
+// in increasing order, and registers it
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{newVec(name, help, labels), buckets}
+	r.register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram for the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	hist := h.get(labelValues, func() interface{} {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	}).(*histogram)
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			hist.counts[i]++
+			break
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, lv := range h.sorted() {
+		hist := lv.value.(*histogram)
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += hist.counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, lv.labelValues, "le", formatFloat(upperBound), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, lv.labelValues, "le", "+Inf", float64(hist.count))
+		writeSample(w, h.name+"_sum", h.labels, lv.labelValues, "", "", hist.sum)
+		writeSample(w, h.name+"_count", h.labels, lv.labelValues, "", "", float64(hist.count))
+	}
+}
+
+// gaugeFunc is a gauge whose value is computed when collected
+type gaugeFunc struct {
+	name, help string
+	function   func() float64
+}
+
+// NewGaugeFunc creates a gauge whose value is the return value of the given
+// function at collection time, and registers it
+func (r *Registry) NewGaugeFunc(name, help string, function func() float64) {
+	r.register(&gaugeFunc{name, help, function})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	writeSample(w, g.name, nil, nil, "", "", g.function())
+}
+
+var (
+	helpEscaper       = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+)
+
+func writeHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, helpEscaper.Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// writeSample writes a sample line. extraLabel, if not empty, is appended to
+// the labels, e.g. the "le" label of histogram buckets.
+func writeSample(w io.Writer, name string, labels, labelValues []string, extraLabel, extraLabelValue string, value float64) {
+	pairs := make([]string, 0, len(labels)+1)
+	for i, label := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", label, labelValueEscaper.Replace(labelValues[i])))
+	}
+	if extraLabel != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraLabel, labelValueEscaper.Replace(extraLabelValue)))
+	}
+	if len(pairs) > 0 {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+	}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Test counter.", "provisioner", "class")
+	h := r.NewHistogramVec("test_duration_seconds", "Test histogram.", []float64{1, 5}, "provisioner")
+	r.NewGaugeFunc("test_gauge", "Test gauge.", func() float64 { return 3 })
+
+	c.Inc("foo.bar/baz", "class-2")
+	c.Inc("foo.bar/baz", "class-1")
+	c.Inc("foo.bar/baz", "class-1")
+	c.Inc("foo.bar/baz", `quote"d`)
+	h.Observe(0.5, "foo.bar/baz")
+	h.Observe(2, "foo.bar/baz")
+	h.Observe(10, "foo.bar/baz")
+
+	expected := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{provisioner="foo.bar/baz",class="class-1"} 2
+test_total{provisioner="foo.bar/baz",class="class-2"} 1
+test_total{provisioner="foo.bar/baz",class="quote\"d"} 1
+# HELP test_duration_seconds Test histogram.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{provisioner="foo.bar/baz",le="1"} 1
+test_duration_seconds_bucket{provisioner="foo.bar/baz",le="5"} 2
+test_duration_seconds_bucket{provisioner="foo.bar/baz",le="+Inf"} 3
+test_duration_seconds_sum{provisioner="foo.bar/baz"} 12.5
+test_duration_seconds_count{provisioner="foo.bar/baz"} 3
+# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge 3
+`
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Body.String() != expected {
+		t.Errorf("expected metrics:\n%s\nbut got:\n%s", expected, w.Body.String())
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic on wrong number of label values")
+		}
+	}()
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Test counter.", "provisioner", "class")
+	c.Inc("foo.bar/baz")
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync/atomic"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// reconcileOrphans lists the storage assets of every provisioner that
+// implements Lister and reports, and deletes if deleteOrphans is set, the ones
+// whose PV doesn't exist. An asset must be found orphaned in two consecutive
+// passes, otherwise it may just have been provisioned and its PV not saved yet.
+// Assets of pending claims are never orphans. Only the leader deletes orphans
+// in LeaderElectionController mode; in PerClaim mode they are only reported.
+func (ctrl *ProvisionController) reconcileOrphans() {
+	if ctrl.leaderElectionMode == LeaderElectionController && !ctrl.isLeading() {
+		return
+	}
+
+	pending := ctrl.getPendingVolumeNames()
+	orphans := make(map[string]bool)
+	for provisionerName, provisioner := range ctrl.provisioners {
+		lister, ok := provisioner.(Lister)
+		if !ok {
+			continue
+		}
+		assets, err := lister.List()
+		if err != nil {
+			glog.Errorf("Error listing storage assets of provisioner %q to find orphans: %v", provisionerName, err)
+			continue
+		}
+		for _, asset := range assets {
+			if ctrl.reconcileOrphan(provisioner, asset, pending) {
+				orphans[asset.Name] = true
+			}
+		}
+	}
+
+	ctrl.orphans = orphans
+	atomic.StoreInt64(&ctrl.metrics.orphanedAssets, int64(len(orphans)))
+}
+
+// reconcileOrphan reports, and deletes if deleteOrphans is set, the given
+// asset of the given provisioner if it was found orphaned in the previous pass
+// too. Returns whether the asset is still orphaned.
+func (ctrl *ProvisionController) reconcileOrphan(provisioner Provisioner, asset *v1.PersistentVolume, pending map[string]bool) bool {
+	if !ctrl.isOrphan(asset.Name) {
+		return false
+	}
+	if ctrl.hasPendingClaim(asset, pending) {
+		glog.V(4).Infof("storage asset for volume %q may belong to a pending claim, not an orphan", asset.Name)
+		return false
+	}
+	if !ctrl.orphans[asset.Name] {
+		glog.V(4).Infof("storage asset for volume %q has no PV, will check again next pass", asset.Name)
+		return true
+	}
+
+	if !ctrl.deleteOrphans || ctrl.dryRun || ctrl.leaderElectionMode != LeaderElectionController {
+		glog.Warningf("storage asset for volume %q is orphaned, its PV does not exist. Please delete it manually", asset.Name)
+		return true
+	}
+	if err := provisioner.Delete(asset); err != nil {
+		if ierr, ok := err.(*IgnoredError); ok {
+			glog.Infof("deletion of orphaned storage asset for volume %q ignored: %v", asset.Name, ierr)
+			return true
+		}
+		glog.Errorf("Error deleting orphaned storage asset for volume %q: %v", asset.Name, err)
+		return true
+	}
+	glog.Infof("orphaned storage asset for volume %q deleted", asset.Name)
+	return false
+}
+
+// getPendingVolumeNames returns the names of the volumes of claims not bound
+// yet. Their assets may exist before their PVs: either the asynchronous
+// provision is in progress or the asset is waiting to be adopted with Exister
+// by the next provision attempt.
+func (ctrl *ProvisionController) getPendingVolumeNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, obj := range ctrl.claims.List() {
+		claim, ok := obj.(*v1.PersistentVolumeClaim)
+		if !ok {
+			continue
+		}
+		if claim.Spec.VolumeName == "" {
+			names[ctrl.getProvisionedVolumeNameForClaim(claim)] = true
+		}
+	}
+	return names
+}
+
+// hasPendingClaim returns whether the claim the asset was provisioned for may
+// exist and not be bound yet. If the asset has a ClaimRef, the claim is got
+// from the API server. Otherwise, it can only be looked for among the pending
+// claims in the cache, which lacks the claims out of the controller's scope:
+// if Namespaces or ClaimLabelSelector is set, the claim is assumed pending.
+func (ctrl *ProvisionController) hasPendingClaim(asset *v1.PersistentVolume, pending map[string]bool) bool {
+	claimRef := asset.Spec.ClaimRef
+	if claimRef == nil {
+		return pending[asset.Name] || len(ctrl.namespaces) > 0 || ctrl.claimLabelSelector != ""
+	}
+	claim, err := ctrl.client.Core().PersistentVolumeClaims(claimRef.Namespace).Get(claimRef.Name, metav1.GetOptions{})
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			glog.Errorf("Error getting claim %s/%s to check if storage asset for volume %q is orphaned: %v", claimRef.Namespace, claimRef.Name, asset.Name, err)
+			return true
+		}
+		return false
+	}
+	if claimRef.UID != "" && claim.UID != claimRef.UID {
+		// The claim was deleted & recreated
+		return false
+	}
+	return claim.Spec.VolumeName == ""
+}
+
+// hasLister returns whether any of the controller's provisioners implements
+// Lister
+func (ctrl *ProvisionController) hasLister() bool {
+	for _, provisioner := range ctrl.provisioners {
+		if _, ok := provisioner.(Lister); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isOrphan returns whether the PV with the given name doesn't exist. It checks
+// the cache first, then the API server in case the cache is stale.
+func (ctrl *ProvisionController) isOrphan(volumeName string) bool {
+	if _, exists, err := ctrl.volumes.GetByKey(volumeName); err == nil && exists {
+		return false
+	}
+	_, err := ctrl.client.Core().PersistentVolumes().Get(volumeName, metav1.GetOptions{})
+	if err == nil {
+		return false
+	}
+	if !apierrs.IsNotFound(err) {
+		glog.Errorf("Error getting volume %q to check if its storage asset is orphaned: %v", volumeName, err)
+		return false
+	}
+	return true
+}
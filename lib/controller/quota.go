@@ -0,0 +1,189 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/kubernetes/pkg/api/v1/helper"
+)
+
+// StorageClass parameters reserved by the library for the maximum number of
+// volumes & total storage each namespace may provision from the class. They
+// are removed from the parameters passed to the Provisioner.
+const (
+	paramMaxVolumesPerNamespace = "controller.external-storage.incubator.kubernetes.io/max-volumes-per-namespace"
+	paramMaxStoragePerNamespace = "controller.external-storage.incubator.kubernetes.io/max-storage-per-namespace"
+)
+
+// classQuota is the maximum number of volumes & bytes of storage each
+// namespace may provision from a StorageClass. Negative means unlimited.
+type classQuota struct {
+	maxVolumes int64
+	maxStorage int64
+}
+
+// quotaReservation is the storage a claim being provisioned for counts
+// against its namespace's quota until its PV is in the cache. Once the PV is
+// saved, or an asynchronous provision is started, the reservation is kept at
+// most a resync period: by then the PV, or the claim's provision handle, is in
+// the cache and counted instead. Reservations are only in memory, so other
+// controllers' synchronous provisions in flight are not counted.
+type quotaReservation struct {
+	namespace string
+	class     string
+	storage   int64
+	savedTime time.Time
+}
+
+// parseQuota returns the quota set by the given StorageClass parameters & the
+// parameters without the reserved ones
+func parseQuota(parameters map[string]string) (classQuota, map[string]string, error) {
+	quota := classQuota{maxVolumes: -1, maxStorage: -1}
+	_, hasMaxVolumes := parameters[paramMaxVolumesPerNamespace]
+	_, hasMaxStorage := parameters[paramMaxStoragePerNamespace]
+	if !hasMaxVolumes && !hasMaxStorage {
+		return quota, parameters, nil
+	}
+
+	remaining := make(map[string]string, len(parameters))
+	for k, v := range parameters {
+		switch k {
+		case paramMaxVolumesPerNamespace:
+			maxVolumes, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || maxVolumes < 0 {
+				return quota, nil, fmt.Errorf("invalid %s %q, must be a non-negative integer", k, v)
+			}
+			quota.maxVolumes = maxVolumes
+		case paramMaxStoragePerNamespace:
+			maxStorage, err := resource.ParseQuantity(v)
+			if err != nil || maxStorage.Sign() < 0 {
+				return quota, nil, fmt.Errorf("invalid %s %q, must be a non-negative quantity", k, v)
+			}
+			quota.maxStorage = maxStorage.Value()
+		default:
+			remaining[k] = v
+		}
+	}
+
+	return quota, remaining, nil
+}
+
+// reserveQuota checks that provisioning the volume pvName for the claim keeps
+// its namespace within the class's quota and if so, reserves the claim's
+// requested storage until releaseQuota is called. Usage is that of the PVs in
+// the cache provisioned for claims in the namespace, plus reservations.
+func (ctrl *ProvisionController) reserveQuota(claim *v1.PersistentVolumeClaim, class, pvName string, quota classQuota) error {
+	if quota.maxVolumes < 0 && quota.maxStorage < 0 {
+		return nil
+	}
+
+	requested := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+
+	ctrl.quotaMutex.Lock()
+	defer ctrl.quotaMutex.Unlock()
+
+	volumes, storage := ctrl.quotaUsage(claim.Namespace, class)
+	if quota.maxVolumes >= 0 && volumes+1 > quota.maxVolumes {
+		return fmt.Errorf("namespace %q has %d volumes of StorageClass %q, the maximum is %d", claim.Namespace, volumes, class, quota.maxVolumes)
+	}
+	if quota.maxStorage >= 0 && storage+requested.Value() > quota.maxStorage {
+		return fmt.Errorf("namespace %q has %s of StorageClass %q, requesting %s more would exceed the maximum of %s", claim.Namespace,
+			resource.NewQuantity(storage, resource.BinarySI), class, requested.String(), resource.NewQuantity(quota.maxStorage, resource.BinarySI))
+	}
+
+	ctrl.quotaReservations[pvName] = quotaReservation{namespace: claim.Namespace, class: class, storage: requested.Value()}
+	return nil
+}
+
+// releaseQuota releases the storage reserved for the volume pvName, if it
+// wasn't saved nor is still being provisioned asynchronously. Otherwise the
+// reservation is kept until the PV or the claim's provision handle is in the
+// cache.
+func (ctrl *ProvisionController) releaseQuota(pvName string, keep bool) {
+	ctrl.quotaMutex.Lock()
+	defer ctrl.quotaMutex.Unlock()
+	reservation, ok := ctrl.quotaReservations[pvName]
+	if !ok {
+		return
+	}
+	if keep {
+		reservation.savedTime = time.Now()
+		ctrl.quotaReservations[pvName] = reservation
+	} else {
+		delete(ctrl.quotaReservations, pvName)
+	}
+}
+
+// quotaUsage returns the number of volumes & bytes of storage of the class
+// provisioned, or being provisioned, for claims in the namespace. Claims with
+// an asynchronous provision in progress count their requested storage until
+// their PV is in the cache. quotaMutex must be held.
+func (ctrl *ProvisionController) quotaUsage(namespace, class string) (int64, int64) {
+	var volumes, storage int64
+	seen := make(map[string]bool)
+	for _, obj := range ctrl.volumes.List() {
+		volume, ok := obj.(*v1.PersistentVolume)
+		if !ok {
+			continue
+		}
+		if _, provisioned := volume.Annotations[annDynamicallyProvisioned]; !provisioned {
+			continue
+		}
+		if volume.Spec.ClaimRef == nil || volume.Spec.ClaimRef.Namespace != namespace || helper.GetPersistentVolumeClass(volume) != class {
+			continue
+		}
+		capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		volumes++
+		storage += capacity.Value()
+		seen[volume.Name] = true
+	}
+	inProgress := make(map[string]bool)
+	for _, obj := range ctrl.claims.List() {
+		claim, ok := obj.(*v1.PersistentVolumeClaim)
+		if !ok {
+			continue
+		}
+		if _, found := claim.Annotations[annProvisionHandle]; !found {
+			continue
+		}
+		pvName := ctrl.getProvisionedVolumeNameForClaim(claim)
+		if seen[pvName] || claim.Namespace != namespace || helper.GetPersistentVolumeClaimClass(claim) != class {
+			continue
+		}
+		requested := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+		volumes++
+		storage += requested.Value()
+		inProgress[pvName] = true
+	}
+	for pvName, reservation := range ctrl.quotaReservations {
+		if seen[pvName] || (!reservation.savedTime.IsZero() && time.Since(reservation.savedTime) > ctrl.resyncPeriod) {
+			delete(ctrl.quotaReservations, pvName)
+			continue
+		}
+		if inProgress[pvName] || reservation.namespace != namespace || reservation.class != class {
+			continue
+		}
+		volumes++
+		storage += reservation.storage
+	}
+	return volumes, storage
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseQuota(t *testing.T) {
+	tests := []struct {
+		name               string
+		parameters         map[string]string
+		expectedQuota      classQuota
+		expectedParameters map[string]string
+		expectErr          bool
+	}{
+		{
+			name:               "no quota",
+			parameters:         map[string]string{"foo": "bar"},
+			expectedQuota:      classQuota{maxVolumes: -1, maxStorage: -1},
+			expectedParameters: map[string]string{"foo": "bar"},
+		},
+		{
+			name: "quota parameters removed",
+			parameters: map[string]string{
+				"foo":                       "bar",
+				paramMaxVolumesPerNamespace: "10",
+				paramMaxStoragePerNamespace: "1Gi",
+			},
+			expectedQuota:      classQuota{maxVolumes: 10, maxStorage: 1024 * 1024 * 1024},
+			expectedParameters: map[string]string{"foo": "bar"},
+		},
+		{
+			name:       "invalid max volumes",
+			parameters: map[string]string{paramMaxVolumesPerNamespace: "-1"},
+			expectErr:  true,
+		},
+		{
+			name:       "invalid max storage",
+			parameters: map[string]string{paramMaxStoragePerNamespace: "lots"},
+			expectErr:  true,
+		},
+	}
+	for _, test := range tests {
+		quota, parameters, err := parseQuota(test.parameters)
+		if test.expectErr != (err != nil) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error %v but got %v\n", test.expectErr, err)
+			continue
+		}
+		if test.expectErr {
+			continue
+		}
+		if quota != test.expectedQuota {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected quota %+v but got %+v\n", test.expectedQuota, quota)
+		}
+		if !reflect.DeepEqual(test.expectedParameters, parameters) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected parameters %v but got %v\n", test.expectedParameters, parameters)
+		}
+	}
+}
+
+func TestReserveQuota(t *testing.T) {
+	provisioned := map[string]string{annDynamicallyProvisioned: "foo.bar/baz", annClass: "class-1"}
+	tests := []struct {
+		name         string
+		volumes      []*v1.PersistentVolume
+		claims       []*v1.PersistentVolumeClaim
+		reservations []string
+		quota        classQuota
+		claim        *v1.PersistentVolumeClaim
+		expectErr    bool
+	}{
+		{
+			name:    "unlimited",
+			volumes: []*v1.PersistentVolume{newVolumeWithClaimRef(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, provisioned), v1.NamespaceDefault, "claim-0")},
+			quota:   classQuota{maxVolumes: -1, maxStorage: -1},
+			claim:   newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		},
+		{
+			name:      "too many volumes",
+			volumes:   []*v1.PersistentVolume{newVolumeWithClaimRef(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, provisioned), v1.NamespaceDefault, "claim-0")},
+			quota:     classQuota{maxVolumes: 1, maxStorage: -1},
+			claim:     newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			expectErr: true,
+		},
+		{
+			name:    "volumes in other namespace don't count",
+			volumes: []*v1.PersistentVolume{newVolumeWithClaimRef(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, provisioned), "other", "claim-0")},
+			quota:   classQuota{maxVolumes: 1, maxStorage: -1},
+			claim:   newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		},
+		{
+			name:    "volumes of other class don't count",
+			volumes: []*v1.PersistentVolume{newVolumeWithClaimRef(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz", annClass: "class-2"}), v1.NamespaceDefault, "claim-0")},
+			quota:   classQuota{maxVolumes: 1, maxStorage: -1},
+			claim:   newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		},
+		{
+			name:    "volumes not provisioned don't count",
+			volumes: []*v1.PersistentVolume{newVolumeWithClaimRef(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annClass: "class-1"}), v1.NamespaceDefault, "claim-0")},
+			quota:   classQuota{maxVolumes: 1, maxStorage: -1},
+			claim:   newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		},
+		{
+			name:      "too much storage",
+			volumes:   []*v1.PersistentVolume{newVolumeWithClaimRef(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, provisioned), v1.NamespaceDefault, "claim-0")},
+			quota:     classQuota{maxVolumes: -1, maxStorage: 2 * 1024 * 1024},
+			claim:     newClaimWithRequest(newClaim("claim-1", "uid-1-1", "class-1", "", nil), "2Mi"),
+			expectErr: true,
+		},
+		{
+			name:    "storage within quota",
+			volumes: []*v1.PersistentVolume{newVolumeWithClaimRef(newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, provisioned), v1.NamespaceDefault, "claim-0")},
+			quota:   classQuota{maxVolumes: -1, maxStorage: 2 * 1024 * 1024},
+			claim:   newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		},
+		{
+			name:         "reservations count",
+			reservations: []string{"pvc-uid-0-1"},
+			quota:        classQuota{maxVolumes: 1, maxStorage: -1},
+			claim:        newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			expectErr:    true,
+		},
+		{
+			name:      "asynchronous provisions in progress count",
+			claims:    []*v1.PersistentVolumeClaim{newClaim("claim-0", "uid-0-1", "class-1", "", map[string]string{annProvisionHandle: "handle-0"})},
+			quota:     classQuota{maxVolumes: 1, maxStorage: -1},
+			claim:     newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			expectErr: true,
+		},
+		{
+			name:         "asynchronous provision in progress & its reservation count once",
+			claims:       []*v1.PersistentVolumeClaim{newClaim("claim-0", "uid-0-1", "class-1", "", map[string]string{annProvisionHandle: "handle-0"})},
+			reservations: []string{"pvc-uid-0-1"},
+			quota:        classQuota{maxVolumes: 2, maxStorage: -1},
+			claim:        newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		},
+		{
+			name:    "asynchronous provision whose volume is saved counts once",
+			volumes: []*v1.PersistentVolume{newVolumeWithClaimRef(newVolume("pvc-uid-0-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, provisioned), v1.NamespaceDefault, "claim-0")},
+			claims:  []*v1.PersistentVolumeClaim{newClaim("claim-0", "uid-0-1", "class-1", "", map[string]string{annProvisionHandle: "handle-0"})},
+			quota:   classQuota{maxVolumes: 2, maxStorage: -1},
+			claim:   newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		},
+		{
+			name:   "claims not being provisioned asynchronously don't count",
+			claims: []*v1.PersistentVolumeClaim{newClaim("claim-0", "uid-0-1", "class-1", "", nil)},
+			quota:  classQuota{maxVolumes: 1, maxStorage: -1},
+			claim:  newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newTestProvisioner(), "v1.5.0")
+		for _, volume := range test.volumes {
+			ctrl.volumes.Add(volume)
+		}
+		for _, claim := range test.claims {
+			ctrl.claims.Add(claim)
+		}
+		for _, pvName := range test.reservations {
+			ctrl.quotaReservations[pvName] = quotaReservation{namespace: v1.NamespaceDefault, class: "class-1", storage: 1024 * 1024}
+		}
+		err := ctrl.reserveQuota(test.claim, "class-1", "pvc-uid-1-1", test.quota)
+		if test.expectErr != (err != nil) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error %v but got %v\n", test.expectErr, err)
+		}
+		_, reserved := ctrl.quotaReservations["pvc-uid-1-1"]
+		if reserved != (err == nil && (test.quota.maxVolumes >= 0 || test.quota.maxStorage >= 0)) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected reservation %v\n", reserved)
+		}
+
+		// A saved or asynchronously provisioning volume's storage stays
+		// reserved, otherwise it's released
+		ctrl.releaseQuota("pvc-uid-1-1", true)
+		if _, ok := ctrl.quotaReservations["pvc-uid-1-1"]; ok != reserved {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected saved volume's reservation to be kept")
+		}
+		ctrl.releaseQuota("pvc-uid-1-1", false)
+		if _, ok := ctrl.quotaReservations["pvc-uid-1-1"]; ok {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected reservation to be released")
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// claimListWatch lists & watches the claims in a set of namespaces that match
+// a label selector. Each namespace is listed & watched separately so that the
+// controller only needs RBAC permissions on claims in them.
+type claimListWatch struct {
+	client        kubernetes.Interface
+	namespaces    []string
+	labelSelector string
+}
+
+var _ cache.ListerWatcher = &claimListWatch{}
+
+// newClaimListWatch returns a ListerWatcher of the claims in the given
+// namespaces, or all namespaces if there are none, that match the given label
+// selector
+func newClaimListWatch(client kubernetes.Interface, namespaces []string, labelSelector string) cache.ListerWatcher {
+	if len(namespaces) == 0 {
+		namespaces = []string{v1.NamespaceAll}
+	}
+	return &claimListWatch{client: client, namespaces: namespaces, labelSelector: labelSelector}
+}
+
+func (lw *claimListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	options.LabelSelector = lw.labelSelector
+	if len(lw.namespaces) == 1 {
+		return lw.client.Core().PersistentVolumeClaims(lw.namespaces[0]).List(options)
+	}
+
+	list := &v1.PersistentVolumeClaimList{}
+	for i, namespace := range lw.namespaces {
+		namespaceList, err := lw.client.Core().PersistentVolumeClaims(namespace).List(options)
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, namespaceList.Items...)
+		// Watch from the oldest version listed so no namespace misses events
+		if i == 0 || isOlderResourceVersion(namespaceList.ResourceVersion, list.ResourceVersion) {
+			list.ResourceVersion = namespaceList.ResourceVersion
+		}
+	}
+	return list, nil
+}
+
+func (lw *claimListWatch) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	options.LabelSelector = lw.labelSelector
+	if len(lw.namespaces) == 1 {
+		return lw.client.Core().PersistentVolumeClaims(lw.namespaces[0]).Watch(options)
+	}
+
+	watches := make([]watch.Interface, 0, len(lw.namespaces))
+	for _, namespace := range lw.namespaces {
+		w, err := lw.client.Core().PersistentVolumeClaims(namespace).Watch(options)
+		if err != nil {
+			for _, w := range watches {
+				w.Stop()
+			}
+			return nil, err
+		}
+		watches = append(watches, w)
+	}
+	return newMultiWatch(watches), nil
+}
+
+// isOlderResourceVersion returns whether resource version a is older than b.
+// Resource versions are opaque, so if either is not a number it returns false.
+func isOlderResourceVersion(a, b string) bool {
+	aVersion, err := strconv.ParseUint(a, 10, 64)
+	if err != nil {
+		return false
+	}
+	bVersion, err := strconv.ParseUint(b, 10, 64)
+	if err != nil {
+		return false
+	}
+	return aVersion < bVersion
+}
+
+// multiWatch merges the events of several watches. When any of them ends, all
+// of them are stopped so the watcher lists & watches again.
+type multiWatch struct {
+	watches  []watch.Interface
+	result   chan watch.Event
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+var _ watch.Interface = &multiWatch{}
+
+func newMultiWatch(watches []watch.Interface) *multiWatch {
+	mw := &multiWatch{
+		watches: watches,
+		result:  make(chan watch.Event),
+		stopCh:  make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	for _, w := range watches {
+		wg.Add(1)
+		go func(w watch.Interface) {
+			defer wg.Done()
+			for {
+				select {
+				case event, ok := <-w.ResultChan():
+					if !ok {
+						mw.Stop()
+						return
+					}
+					select {
+					case mw.result <- event:
+					case <-mw.stopCh:
+						return
+					}
+				case <-mw.stopCh:
+					return
+				}
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(mw.result)
+	}()
+
+	return mw
+}
+
+func (mw *multiWatch) ResultChan() <-chan watch.Event {
+	return mw.result
+}
+
+func (mw *multiWatch) Stop() {
+	mw.stopOnce.Do(func() {
+		close(mw.stopCh)
+		for _, w := range mw.watches {
+			w.Stop()
+		}
+	})
+}
+
+// isNamespaceInScope returns whether the controller serves claims in the given
+// namespace
+func (ctrl *ProvisionController) isNamespaceInScope(namespace string) bool {
+	if len(ctrl.namespaces) == 0 {
+		return true
+	}
+	for _, n := range ctrl.namespaces {
+		if n == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// getScopeLabels returns the labels of the claim on the keys the controller's
+// ClaimLabelSelector selects on, formatted like "tenant=a"
+func (ctrl *ProvisionController) getScopeLabels(claim *v1.PersistentVolumeClaim) string {
+	scope := labels.Set{}
+	// The selector was validated by ClaimLabelSelector
+	selector, _ := labels.Parse(ctrl.claimLabelSelector)
+	requirements, _ := selector.Requirements()
+	for _, requirement := range requirements {
+		if value, ok := claim.Labels[requirement.Key()]; ok {
+			scope[requirement.Key()] = value
+		}
+	}
+	return scope.String()
+}
+
+// isVolumeLabelInScope returns whether the controller serves the claim the
+// given volume was provisioned for, judging by the claim labels recorded on
+// the volume. A volume without them is out of scope of a controller with a
+// ClaimLabelSelector.
+func (ctrl *ProvisionController) isVolumeLabelInScope(volume *v1.PersistentVolume) bool {
+	if ctrl.claimLabelSelector == "" {
+		return true
+	}
+	value, ok := volume.Annotations[annClaimLabels]
+	if !ok {
+		return false
+	}
+	scope, err := labels.ConvertSelectorToLabelsMap(value)
+	if err != nil {
+		glog.Errorf("Error parsing claim labels %q of volume %q: %v", value, volume.Name, err)
+		return false
+	}
+	selector, _ := labels.Parse(ctrl.claimLabelSelector)
+	return selector.Matches(scope)
+}
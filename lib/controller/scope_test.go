@@ -0,0 +1,146 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestIsOlderResourceVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        string
+		b        string
+		expected bool
+	}{
+		{
+			name:     "older",
+			a:        "9",
+			b:        "10",
+			expected: true,
+		},
+		{
+			name:     "newer",
+			a:        "10",
+			b:        "9",
+			expected: false,
+		},
+		{
+			name:     "equal",
+			a:        "10",
+			b:        "10",
+			expected: false,
+		},
+		{
+			name:     "not a number",
+			a:        "foo",
+			b:        "10",
+			expected: false,
+		},
+	}
+	for _, test := range tests {
+		if result := isOlderResourceVersion(test.a, test.b); test.expected != result {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %v but got %v\n", test.expected, result)
+		}
+	}
+}
+
+func TestIsVolumeLabelInScope(t *testing.T) {
+	tests := []struct {
+		name          string
+		labelSelector string
+		claimLabels   map[string]string
+		expected      bool
+	}{
+		{
+			name:          "no label selector",
+			labelSelector: "",
+			claimLabels:   nil,
+			expected:      true,
+		},
+		{
+			name:          "claim labels match",
+			labelSelector: "tenant=a",
+			claimLabels:   map[string]string{"tenant": "a", "app": "db"},
+			expected:      true,
+		},
+		{
+			name:          "claim labels don't match",
+			labelSelector: "tenant=a",
+			claimLabels:   map[string]string{"tenant": "b"},
+			expected:      false,
+		},
+		{
+			name:          "claim labels not recorded",
+			labelSelector: "!tenant",
+			claimLabels:   nil,
+			expected:      false,
+		},
+	}
+	for _, test := range tests {
+		ctrl := &ProvisionController{claimLabelSelector: test.labelSelector}
+		volume := newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, nil)
+		if test.claimLabels != nil {
+			claim := newClaimWithLabels(newClaim("claim-1", "uid-1-1", "class-1", "", nil), test.claimLabels)
+			volume.Annotations = map[string]string{annClaimLabels: ctrl.getScopeLabels(claim)}
+		}
+		if result := ctrl.isVolumeLabelInScope(volume); test.expected != result {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %v but got %v\n", test.expected, result)
+		}
+	}
+}
+
+func TestMultiWatch(t *testing.T) {
+	w1 := watch.NewFake()
+	w2 := watch.NewFake()
+	mw := newMultiWatch([]watch.Interface{w1, w2})
+
+	go w1.Add(newClaim("claim-1", "uid-1-1", "class-1", "", nil))
+	go w2.Add(newClaim("claim-2", "uid-2-1", "class-1", "", nil))
+	names := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-mw.ResultChan():
+			names[event.Object.(*v1.PersistentVolumeClaim).Name] = true
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+	if !names["claim-1"] || !names["claim-2"] {
+		t.Errorf("expected events for claim-1 & claim-2 but got %v", names)
+	}
+
+	// Ending one watch ends the merged watch
+	w1.Stop()
+	select {
+	case _, ok := <-mw.ResultChan():
+		if ok {
+			t.Errorf("expected merged watch to end but got an event")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for merged watch to end")
+	}
+	if !w2.Stopped {
+		t.Errorf("expected other watch to be stopped")
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// ValidateSelector returns an error if any of the requirements parsed from a
+// claim's selector is on a key that is not one of supportedKeys. Provisioners
+// call it with the keys they know how to satisfy, e.g. a zone or pool label,
+// to reject claims selecting on anything else.
+func ValidateSelector(requirements labels.Requirements, supportedKeys ...string) error {
+	for _, requirement := range requirements {
+		supported := false
+		for _, key := range supportedKeys {
+			if requirement.Key() == key {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			if len(supportedKeys) == 0 {
+				return fmt.Errorf("claim Selector is not supported")
+			}
+			return fmt.Errorf("claim Selector key %q is not supported, supported keys are %v", requirement.Key(), supportedKeys)
+		}
+	}
+	return nil
+}
+
+// SelectorValues returns the values the requirements parsed from a claim's
+// selector allow for key, and whether they constrain key to a set of values
+// at all, i.e. have an In or Equals requirement on it. A provisioner choosing
+// among several values must itself set the label on the PV it returns.
+func SelectorValues(requirements labels.Requirements, key string) ([]string, bool) {
+	for _, requirement := range requirements {
+		if requirement.Key() != key {
+			continue
+		}
+		switch requirement.Operator() {
+		case selection.In, selection.Equals, selection.DoubleEquals:
+			return requirement.Values().List(), true
+		}
+	}
+	return nil, false
+}
+
+// getSelectorRequirements parses the claim's selector into requirements, nil
+// if it has none.
+func getSelectorRequirements(claim *v1.PersistentVolumeClaim) (labels.Requirements, error) {
+	if claim.Spec.Selector == nil {
+		return nil, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(claim.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	requirements, _ := selector.Requirements()
+	return requirements, nil
+}
+
+// setSelectorLabels labels the volume to satisfy every requirement that allows
+// exactly one value for its key, unless the provisioner already set the label.
+// Returns whether the volume then matches the claim's selector.
+func setSelectorLabels(volume *v1.PersistentVolume, claim *v1.PersistentVolumeClaim, requirements labels.Requirements) (bool, error) {
+	if claim.Spec.Selector == nil {
+		return true, nil
+	}
+	for _, requirement := range requirements {
+		if _, found := volume.Labels[requirement.Key()]; found {
+			continue
+		}
+		if values, ok := SelectorValues(labels.Requirements{requirement}, requirement.Key()); ok && len(values) == 1 {
+			if volume.Labels == nil {
+				volume.Labels = make(map[string]string)
+			}
+			volume.Labels[requirement.Key()] = values[0]
+		}
+	}
+	selector, err := metav1.LabelSelectorAsSelector(claim.Spec.Selector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(volume.Labels)), nil
+}
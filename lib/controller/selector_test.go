@@ -0,0 +1,125 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateSelector(t *testing.T) {
+	tests := []struct {
+		name          string
+		selector      *metav1.LabelSelector
+		supportedKeys []string
+		expectError   bool
+	}{
+		{
+			name:        "no selector",
+			selector:    nil,
+			expectError: false,
+		},
+		{
+			name:        "empty selector",
+			selector:    &metav1.LabelSelector{},
+			expectError: false,
+		},
+		{
+			name:        "no supported keys",
+			selector:    &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "a"}},
+			expectError: true,
+		},
+		{
+			name:          "supported key",
+			selector:      &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "a"}},
+			supportedKeys: []string{"pool", "zone"},
+			expectError:   false,
+		},
+		{
+			name:          "unsupported key",
+			selector:      &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "a", "tier": "gold"}},
+			supportedKeys: []string{"zone"},
+			expectError:   true,
+		},
+	}
+	for _, test := range tests {
+		claim := newClaimWithSelector(newClaim("claim-1", "1-1", "class-1", "", nil), test.selector)
+		requirements, err := getSelectorRequirements(claim)
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error parsing selector: %v", err)
+			continue
+		}
+		err = ValidateSelector(requirements, test.supportedKeys...)
+		if test.expectError != (err != nil) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error %v but got %v", test.expectError, err)
+		}
+	}
+}
+
+func TestSelectorValues(t *testing.T) {
+	tests := []struct {
+		name           string
+		selector       *metav1.LabelSelector
+		key            string
+		expectedValues []string
+		expectedOk     bool
+	}{
+		{
+			name:           "match label",
+			selector:       &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "a"}},
+			key:            "zone",
+			expectedValues: []string{"a"},
+			expectedOk:     true,
+		},
+		{
+			name: "in expression",
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "zone", Operator: metav1.LabelSelectorOpIn, Values: []string{"b", "a"}},
+			}},
+			key:            "zone",
+			expectedValues: []string{"a", "b"},
+			expectedOk:     true,
+		},
+		{
+			name: "not in expression",
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "zone", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"a"}},
+			}},
+			key:        "zone",
+			expectedOk: false,
+		},
+		{
+			name:       "other key",
+			selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "a"}},
+			key:        "zone",
+			expectedOk: false,
+		},
+	}
+	for _, test := range tests {
+		claim := newClaimWithSelector(newClaim("claim-1", "1-1", "class-1", "", nil), test.selector)
+		requirements, _ := getSelectorRequirements(claim)
+		values, ok := SelectorValues(requirements, test.key)
+		if test.expectedOk != ok || !reflect.DeepEqual(test.expectedValues, values) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected values %v, %v but got %v, %v", test.expectedValues, test.expectedOk, values, ok)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/api/v1/helper"
+)
+
+// topologySelectorTerm is a StorageClass allowedTopologies term, which the
+// vendored storage/v1 StorageClass type does not have yet.
+type topologySelectorTerm struct {
+	MatchLabelExpressions []topologySelectorLabelRequirement `json:"matchLabelExpressions,omitempty"`
+}
+
+type topologySelectorLabelRequirement struct {
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+}
+
+// topologySelectorTermsToNodeSelectorTerms converts allowedTopologies terms to
+// the equivalent node selector terms
+func topologySelectorTermsToNodeSelectorTerms(topologies []topologySelectorTerm) []v1.NodeSelectorTerm {
+	if len(topologies) == 0 {
+		return nil
+	}
+	terms := make([]v1.NodeSelectorTerm, 0, len(topologies))
+	for _, topology := range topologies {
+		term := v1.NodeSelectorTerm{}
+		for _, requirement := range topology.MatchLabelExpressions {
+			term.MatchExpressions = append(term.MatchExpressions, v1.NodeSelectorRequirement{
+				Key:      requirement.Key,
+				Operator: v1.NodeSelectorOpIn,
+				Values:   requirement.Values,
+			})
+		}
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// NodeSelectorTermForNode returns a node selector term matching nodes with the
+// same values as the given node for the given label keys, e.g.
+// kubernetes.io/hostname for a volume local to the node or
+// failure-domain.beta.kubernetes.io/zone for a volume in the node's zone.
+// Returns an error if the node does not have one of the labels.
+func NodeSelectorTermForNode(node *v1.Node, keys ...string) (v1.NodeSelectorTerm, error) {
+	term := v1.NodeSelectorTerm{}
+	for _, key := range keys {
+		value, found := node.Labels[key]
+		if !found {
+			return v1.NodeSelectorTerm{}, fmt.Errorf("node %q has no label %q", node.Name, key)
+		}
+		term.MatchExpressions = append(term.MatchExpressions, v1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: v1.NodeSelectorOpIn,
+			Values:   []string{value},
+		})
+	}
+	return term, nil
+}
+
+// SetNodeAffinity sets the volume's node affinity so that only pods on nodes
+// matching at least one of the terms can use it. The vendored PV type has no
+// node affinity field, so it is set as the alpha node affinity annotation.
+func SetNodeAffinity(volume *v1.PersistentVolume, terms []v1.NodeSelectorTerm) error {
+	if len(terms) == 0 {
+		return nil
+	}
+	if volume.Annotations == nil {
+		volume.Annotations = make(map[string]string)
+	}
+	affinity := &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+			NodeSelectorTerms: terms,
+		},
+	}
+	return helper.StorageNodeAffinityToAlphaAnnotation(volume.Annotations, affinity)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/api/v1/helper"
+)
+
+func TestNodeSelectorTermForNode(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"zone": "a", "kubernetes.io/hostname": "node-1"}}}
+	tests := []struct {
+		name         string
+		keys         []string
+		expectedTerm v1.NodeSelectorTerm
+		expectError  bool
+	}{
+		{
+			name: "zone",
+			keys: []string{"zone"},
+			expectedTerm: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+				{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"a"}},
+			}},
+		},
+		{
+			name: "zone and hostname",
+			keys: []string{"zone", "kubernetes.io/hostname"},
+			expectedTerm: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+				{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"a"}},
+				{Key: "kubernetes.io/hostname", Operator: v1.NodeSelectorOpIn, Values: []string{"node-1"}},
+			}},
+		},
+		{
+			name:        "missing label",
+			keys:        []string{"region"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		term, err := NodeSelectorTermForNode(node, test.keys...)
+		if test.expectError != (err != nil) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error %v but got %v", test.expectError, err)
+		}
+		if !reflect.DeepEqual(test.expectedTerm, term) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected term %v but got %v", test.expectedTerm, term)
+		}
+	}
+}
+
+func TestSetNodeAffinity(t *testing.T) {
+	terms := topologySelectorTermsToNodeSelectorTerms([]topologySelectorTerm{
+		{MatchLabelExpressions: []topologySelectorLabelRequirement{{Key: "zone", Values: []string{"a", "b"}}}},
+	})
+	volume := &v1.PersistentVolume{}
+	if err := SetNodeAffinity(volume, terms); err != nil {
+		t.Fatalf("unexpected error setting node affinity: %v", err)
+	}
+
+	affinity, err := helper.GetStorageNodeAffinityFromAnnotation(volume.Annotations)
+	if err != nil {
+		t.Fatalf("unexpected error getting node affinity: %v", err)
+	}
+	expected := &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{{MatchExpressions: []v1.NodeSelectorRequirement{
+				{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"a", "b"}},
+			}}},
+		},
+	}
+	if !reflect.DeepEqual(expected, affinity) {
+		t.Errorf("expected node affinity %v but got %v", expected, affinity)
+	}
+}
@@ -18,8 +18,11 @@ package controller
 
 import (
 	"fmt"
+	"time"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 // Provisioner is an interface that creates templates for PersistentVolumes
@@ -29,6 +32,10 @@ import (
 type Provisioner interface {
 	// Provision creates a volume i.e. the storage asset and returns a PV object
 	// for the volume
+	//
+	// May return FinalError, TransientError or InProgressError to tell the
+	// controller how to retry. Any other error is retried with exponential
+	// backoff, up to the controller's FailedProvisionThreshold.
 	Provision(VolumeOptions) (*v1.PersistentVolume, error)
 	// Delete removes the storage asset that was created by Provision backing the
 	// given PV. Does not delete the PV object itself.
@@ -38,6 +45,89 @@ type Provisioner interface {
 	Delete(*v1.PersistentVolume) error
 }
 
+// Resizer is an optional interface a Provisioner can implement to expand the
+// volumes it provisioned. If the Provisioner passed to NewProvisionController
+// implements it, the controller watches for bound claims requesting more
+// storage than their volumes' capacity and calls Resize for them.
+type Resizer interface {
+	// Resize expands the storage asset backing the given PV to at least newSize
+	// and returns the asset's new size. Does not update the PV object itself.
+	Resize(volume *v1.PersistentVolume, newSize resource.Quantity) (resource.Quantity, error)
+}
+
+// Lister is an optional interface a Provisioner can implement to list the
+// storage assets it provisioned. If the Provisioner passed to
+// NewProvisionController implements it and option function
+// OrphanReconcilePeriod is set, the controller periodically looks for assets
+// whose PV no longer exists, e.g. because saving the PV failed and so did
+// deleting the asset, and reports or deletes them.
+type Lister interface {
+	// List returns a PV object for every storage asset the provisioner has
+	// provisioned and not deleted. Each must have the name of the PV the asset
+	// was provisioned for, i.e. VolumeOptions.PVName, and whatever else Delete
+	// needs to delete the asset. If the asset records the claim it was
+	// provisioned for, Spec.ClaimRef should be set to it: the controller then
+	// gets the claim to check it is gone or bound before treating the asset as
+	// an orphan. Without a ClaimRef, a controller restricted by Namespaces or
+	// ClaimLabelSelector never does.
+	List() ([]*v1.PersistentVolume, error)
+}
+
+// BlockProvisioner is an optional interface a Provisioner can implement to
+// provision raw block volumes. The controller fails to provision for claims
+// requesting volumeMode Block if the Provisioner doesn't implement it or
+// SupportsBlock returns false.
+type BlockProvisioner interface {
+	// SupportsBlock returns whether Provision can provision block volumes
+	SupportsBlock() bool
+}
+
+// Cloner is an optional interface a Provisioner can implement to provision
+// volumes pre-populated with the contents of another claim's volume. The
+// controller calls Clone instead of Provision for claims with a data source,
+// and fails to provision for them if the Provisioner doesn't implement it.
+type Cloner interface {
+	// Clone creates a volume like Provision, with the contents of
+	// options.DataSource.PV, and returns a PV object representing it.
+	Clone(options VolumeOptions) (*v1.PersistentVolume, error)
+}
+
+// HealthChecker is an optional interface a Provisioner can implement to report
+// whether it can reach the storage it provisions from, e.g. its server's API.
+// If option function HealthPort is set, the controller's liveness & readiness
+// checks fail while HealthCheck returns an error, so that Kubernetes restarts
+// a wedged provisioner.
+type HealthChecker interface {
+	// HealthCheck returns an error if the provisioner is unhealthy. It is
+	// called on every check and so should return within a few seconds.
+	HealthCheck() error
+}
+
+// AsyncProvisioner is an optional interface a Provisioner can implement to
+// provision volumes whose storage assets take long to create without blocking
+// a worker for the whole backend call. Provision starts the backend operation
+// and returns an InProgressError with a Handle for it; the controller then
+// polls ProvisionStatus with the handle.
+type AsyncProvisioner interface {
+	// ProvisionStatus returns the PV object for the volume once the backend
+	// operation identified by handle has succeeded, an InProgressError while it
+	// is still running, or any other error if it failed. After a failure, the
+	// next retry calls Provision again.
+	ProvisionStatus(handle string, options VolumeOptions) (*v1.PersistentVolume, error)
+}
+
+// Exister is an optional interface a Provisioner can implement to find the
+// storage asset an earlier Provision created for a claim, e.g. by
+// VolumeOptions.AssetName, in case the controller crashed or failed to save
+// its PV. If the Provisioner passed to NewProvisionController implements it,
+// the controller calls Exists before Provision and adopts the asset instead of
+// creating another.
+type Exister interface {
+	// Exists returns a PV object for the volume if its storage asset already
+	// exists, like Provision would, or nil if it doesn't.
+	Exists(VolumeOptions) (*v1.PersistentVolume, error)
+}
+
 // IgnoredError is the value for Delete to return to indicate that the call has
 // been ignored and no action taken. In case multiple provisioners are serving
 // the same storage class, provisioners may ignore PVs they are not responsible
@@ -51,6 +141,52 @@ func (e *IgnoredError) Error() string {
 	return fmt.Sprintf("ignored because %s", e.Reason)
 }
 
+// FinalError is the value for Provision to return to indicate that provisioning
+// failed and retrying won't help, e.g. because the StorageClass parameters are
+// invalid. The controller records the error in an annotation on the claim and,
+// whatever FailedProvisionThreshold is, won't retry until it is removed.
+type FinalError struct {
+	Reason string
+}
+
+func (e *FinalError) Error() string {
+	return e.Reason
+}
+
+// TransientError is the value for Provision to return to indicate that
+// provisioning failed for a reason expected to go away soon, e.g. a timeout or
+// a busy backend. The controller retries after RetryAfter, or a short default
+// interval if it is 0, without counting the failure towards
+// FailedProvisionThreshold.
+type TransientError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *TransientError) Error() string {
+	return e.Reason
+}
+
+// InProgressError is the value for Provision to return to indicate that the
+// backend is still creating the storage asset. The controller calls Provision
+// again after RetryAfter, or a default poll interval if it is 0, with the same
+// VolumeOptions; Provision must then return the asset, once it is ready,
+// rather than create another.
+//
+// If the Provisioner implements AsyncProvisioner, it can instead set Handle to
+// identify the backend operation. The controller saves the handle in the
+// claim's annotations and calls ProvisionStatus with it rather than Provision
+// until the operation finishes, even after a restart.
+type InProgressError struct {
+	Reason     string
+	RetryAfter time.Duration
+	Handle     string
+}
+
+func (e *InProgressError) Error() string {
+	return fmt.Sprintf("provisioning in progress: %s", e.Reason)
+}
+
 // VolumeOptions contains option information about a volume
 // https://github.com/kubernetes/kubernetes/blob/release-1.4/pkg/volume/plugins.go
 type VolumeOptions struct {
@@ -59,6 +195,11 @@ type VolumeOptions struct {
 	// PV.Name of the appropriate PersistentVolume. Used to generate cloud
 	// volume name.
 	PVName string
+	// AssetName is a name for the storage asset derived from the claim's UID,
+	// "kubernetes-dynamic-pvc-<uid>", so it is the same on every attempt to
+	// provision the claim. Provisioners that name assets after it can
+	// implement Exister to adopt an asset an earlier attempt created.
+	AssetName string
 	// PVC is reference to the claim that lead to provisioning of a new PV.
 	// Provisioners *must* create a PV that would be matched by this PVC,
 	// i.e. with required capacity, accessMode, labels matching PVC.Selector and
@@ -66,4 +207,56 @@ type VolumeOptions struct {
 	PVC *v1.PersistentVolumeClaim
 	// Volume provisioning parameters from StorageClass
 	Parameters map[string]string
+	// Mount options from StorageClass. The controller sets them on the PV
+	// returned by Provision, so provisioners need not handle them unless they
+	// want to validate them.
+	MountOptions []string
+	// Requirements parsed from PVC.Spec.Selector, nil if it has none.
+	// Provisioners should check them with ValidateSelector. The controller
+	// labels the PV returned by Provision to satisfy every requirement that
+	// allows exactly one value unless the label is already set, and fails
+	// provisioning if the PV then doesn't match the selector.
+	SelectorRequirements labels.Requirements
+	// Node selected by the scheduler for the pod using the PVC, from the PVC's
+	// selected-node annotation, nil if there is none. Set when the
+	// StorageClass has delayed binding, in which case the volume must be
+	// accessible from this node.
+	SelectedNode *v1.Node
+	// Topologies from StorageClass allowedTopologies, each term a set of
+	// label values a node must have, nil if the class does not restrict
+	// topology. The volume must be accessible from nodes matching at least
+	// one term. Use SetNodeAffinity to record where the volume is accessible
+	// on the returned PV.
+	AllowedTopologies []v1.NodeSelectorTerm
+	// Volume mode requested by the PVC, Filesystem unless it requests a raw
+	// Block volume (Kubernetes 1.9+). Block is only requested of provisioners
+	// implementing BlockProvisioner. The controller sets the mode on the PV
+	// returned by Provision.
+	VolumeMode PersistentVolumeMode
+	// Claim & volume to clone, from the PVC's data source annotation, nil if
+	// it has none. Only set when calling Cloner.Clone.
+	DataSource *VolumeDataSource
+}
+
+// VolumeDataSource is a claim to clone and the volume bound to it. The
+// controller checks that the claim is in the same namespace as the claim
+// being provisioned for, that it is bound to the volume and that the volume
+// was provisioned by the same provisioner and is no bigger than requested.
+type VolumeDataSource struct {
+	// The claim to clone
+	PVC *v1.PersistentVolumeClaim
+	// The volume bound to PVC, whose storage asset is to be cloned
+	PV *v1.PersistentVolume
 }
+
+// PersistentVolumeMode is the mode of a volume: mounted as a filesystem or
+// consumed as a raw block device. It is v1.PersistentVolumeMode, which the
+// vendored API types do not have yet.
+type PersistentVolumeMode string
+
+const (
+	// PersistentVolumeBlock means the volume is consumed as a raw block device
+	PersistentVolumeBlock PersistentVolumeMode = "Block"
+	// PersistentVolumeFilesystem means the volume is mounted as a filesystem
+	PersistentVolumeFilesystem PersistentVolumeMode = "Filesystem"
+)
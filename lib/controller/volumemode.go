@@ -0,0 +1,240 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	utilversion "k8s.io/kubernetes/pkg/util/version"
+)
+
+// volumeModeSpec holds the volumeMode field of claims & volumes, which the
+// vendored v1 types do not have yet.
+type volumeModeSpec struct {
+	Spec struct {
+		VolumeMode PersistentVolumeMode `json:"volumeMode,omitempty"`
+	} `json:"spec"`
+}
+
+// hasVolumeMode returns whether claims & volumes have the volumeMode field,
+// i.e. whether the typed objects drop it
+func (ctrl *ProvisionController) hasVolumeMode() bool {
+	return ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.9.0"))
+}
+
+// getClaimVolumeMode returns the volume mode requested by the claim. Before
+// Kubernetes 1.9 claims have none so Filesystem is returned.
+func (ctrl *ProvisionController) getClaimVolumeMode(claim *v1.PersistentVolumeClaim) (PersistentVolumeMode, error) {
+	if !ctrl.hasVolumeMode() {
+		return PersistentVolumeFilesystem, nil
+	}
+
+	// The claims cache holds typed objects that have dropped the field, so get
+	// the raw object from the API server.
+	raw, err := ctrl.client.Core().RESTClient().Get().Namespace(claim.Namespace).Resource("persistentvolumeclaims").Name(claim.Name).DoRaw()
+	if err != nil {
+		return "", err
+	}
+	spec := &volumeModeSpec{}
+	if err = json.Unmarshal(raw, spec); err != nil {
+		return "", fmt.Errorf("Error decoding claim %q: %v", claimToClaimKey(claim), err)
+	}
+
+	if spec.Spec.VolumeMode == "" {
+		return PersistentVolumeFilesystem, nil
+	}
+	return spec.Spec.VolumeMode, nil
+}
+
+// supportsBlock returns whether the provisioner can provision block volumes
+func supportsBlock(provisioner Provisioner) bool {
+	blockProvisioner, ok := provisioner.(BlockProvisioner)
+	return ok && blockProvisioner.SupportsBlock()
+}
+
+// createVolume creates the PV object with the given volume mode. Block volumes
+// are created from the raw object because the typed one would drop the mode.
+func (ctrl *ProvisionController) createVolume(volume *v1.PersistentVolume, volumeMode PersistentVolumeMode) (*v1.PersistentVolume, error) {
+	if volumeMode != PersistentVolumeBlock {
+		return ctrl.client.Core().PersistentVolumes().Create(volume)
+	}
+
+	body, err := json.Marshal(volume)
+	if err != nil {
+		return nil, err
+	}
+	object := map[string]interface{}{}
+	if err = json.Unmarshal(body, &object); err != nil {
+		return nil, err
+	}
+	object["apiVersion"] = "v1"
+	object["kind"] = "PersistentVolume"
+	spec, ok := object["spec"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Error encoding volume %q: no spec", volume.Name)
+	}
+	spec["volumeMode"] = volumeMode
+	if body, err = json.Marshal(object); err != nil {
+		return nil, err
+	}
+
+	raw, err := ctrl.client.Core().RESTClient().Post().Resource("persistentvolumes").Body(body).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	result := &v1.PersistentVolume{}
+	if err = json.Unmarshal(raw, result); err != nil {
+		return nil, fmt.Errorf("Error decoding volume %q: %v", volume.Name, err)
+	}
+	return result, nil
+}
+
+// copyClaim returns a deep copy of the claim, for saveClaim to diff against
+func copyClaim(claim *v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error) {
+	clone, err := scheme.Scheme.DeepCopy(claim)
+	if err != nil {
+		return nil, fmt.Errorf("Error cloning claim %q: %v", claimToClaimKey(claim), err)
+	}
+	return clone.(*v1.PersistentVolumeClaim), nil
+}
+
+// copyVolume returns a deep copy of the volume, for saveVolume to diff against
+func copyVolume(volume *v1.PersistentVolume) (*v1.PersistentVolume, error) {
+	clone, err := scheme.Scheme.DeepCopy(volume)
+	if err != nil {
+		return nil, fmt.Errorf("Error cloning volume %q: %v", volume.Name, err)
+	}
+	return clone.(*v1.PersistentVolume), nil
+}
+
+// saveClaim saves the changes made to modified, a modified copy of original.
+// Updating the claim with the typed object would reset a volumeMode the typed
+// object has dropped, which the API server rejects, so if claims have the
+// field only the changes are sent, as a patch.
+//
+// The saved claim is stored in the cache, which syncs read, so that they see
+// this controller's own changes before the informer catches up.
+func (ctrl *ProvisionController) saveClaim(original, modified *v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error) {
+	var saved *v1.PersistentVolumeClaim
+	var err error
+	if !ctrl.hasVolumeMode() {
+		saved, err = ctrl.client.Core().PersistentVolumeClaims(modified.Namespace).Update(modified)
+	} else {
+		var patch []byte
+		patch, err = createPatch(original, modified, v1.PersistentVolumeClaim{}, modified.ResourceVersion)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating patch for claim %q: %v", claimToClaimKey(modified), err)
+		}
+		saved, err = ctrl.client.Core().PersistentVolumeClaims(modified.Namespace).Patch(modified.Name, types.StrategicMergePatchType, patch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	storeObjectUpdate(ctrl.claims, saved)
+	return saved, nil
+}
+
+// saveVolume saves the changes made to modified, a modified copy of original,
+// and stores the saved volume in the cache, like saveClaim.
+func (ctrl *ProvisionController) saveVolume(original, modified *v1.PersistentVolume) (*v1.PersistentVolume, error) {
+	var saved *v1.PersistentVolume
+	var err error
+	if !ctrl.hasVolumeMode() {
+		saved, err = ctrl.client.Core().PersistentVolumes().Update(modified)
+	} else {
+		var patch []byte
+		patch, err = createPatch(original, modified, v1.PersistentVolume{}, modified.ResourceVersion)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating patch for volume %q: %v", modified.Name, err)
+		}
+		saved, err = ctrl.client.Core().PersistentVolumes().Patch(modified.Name, types.StrategicMergePatchType, patch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	storeObjectUpdate(ctrl.volumes, saved)
+	return saved, nil
+}
+
+// storeObjectUpdate stores the object in the cache, unless the cache already
+// has a newer version of it from the informer
+func storeObjectUpdate(store cache.Store, obj interface{}) {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		glog.Errorf("Error storing object %v in cache: %v", obj, err)
+		return
+	}
+	newVersion, err := strconv.ParseInt(objMeta.GetResourceVersion(), 10, 64)
+	if err != nil {
+		glog.Errorf("Error parsing resource version of object %q: %v", objMeta.GetName(), err)
+		return
+	}
+
+	oldObj, found, err := store.Get(obj)
+	if err == nil && found {
+		oldMeta, err := meta.Accessor(oldObj)
+		if err != nil {
+			return
+		}
+		oldVersion, err := strconv.ParseInt(oldMeta.GetResourceVersion(), 10, 64)
+		if err == nil && oldVersion > newVersion {
+			return
+		}
+	}
+	if err = store.Update(obj); err != nil {
+		glog.Errorf("Error storing object %q in cache: %v", objMeta.GetName(), err)
+	}
+}
+
+// createPatch returns a strategic merge patch of the changes from original to
+// modified, objects of dataStruct's type. The patch carries resourceVersion so
+// that, like an update, it fails with a conflict if the object has changed.
+func createPatch(original, modified, dataStruct interface{}, resourceVersion string) ([]byte, error) {
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return nil, err
+	}
+	modifiedJSON, err := json.Marshal(modified)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := strategicpatch.CreateTwoWayMergePatch(originalJSON, modifiedJSON, dataStruct)
+	if err != nil {
+		return nil, err
+	}
+
+	object := map[string]interface{}{}
+	if err = json.Unmarshal(patch, &object); err != nil {
+		return nil, err
+	}
+	metadata, ok := object["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		object["metadata"] = metadata
+	}
+	metadata["resourceVersion"] = resourceVersion
+	return json.Marshal(object)
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	rl "github.com/kubernetes-incubator/external-storage/lib/leaderelection/resourcelock"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestProvisionVolumeMode(t *testing.T) {
+	tests := []struct {
+		name               string
+		claim              string
+		serverGitVersion   string
+		supportsBlock      bool
+		expectFinalError   bool
+		expectedVolumeMode PersistentVolumeMode
+	}{
+		{
+			name:               "1.8 has no volume mode",
+			claim:              `{"spec": {"volumeMode": "Block"}}`,
+			serverGitVersion:   "v1.8.0",
+			expectedVolumeMode: "",
+		},
+		{
+			name:               "filesystem by default",
+			claim:              `{"spec": {}}`,
+			serverGitVersion:   "v1.9.0",
+			expectedVolumeMode: "",
+		},
+		{
+			name:               "block",
+			claim:              `{"spec": {"volumeMode": "Block"}}`,
+			serverGitVersion:   "v1.9.0",
+			supportsBlock:      true,
+			expectedVolumeMode: PersistentVolumeBlock,
+		},
+		{
+			name:             "block not supported",
+			claim:            `{"spec": {"volumeMode": "Block"}}`,
+			serverGitVersion: "v1.9.0",
+			supportsBlock:    false,
+			expectFinalError: true,
+		},
+	}
+	for _, test := range tests {
+		var created *volumeModeSpec
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch {
+			case r.Method == "GET" && r.URL.Path == "/apis/storage.k8s.io/v1/storageclasses/class-1":
+				fmt.Fprint(w, `{"provisioner": "foo.bar/baz"}`)
+			case r.Method == "GET" && r.URL.Path == "/api/v1/namespaces/default/persistentvolumeclaims/claim-1":
+				fmt.Fprint(w, test.claim)
+			case r.Method == "POST" && r.URL.Path == "/api/v1/persistentvolumes":
+				body, _ := ioutil.ReadAll(r.Body)
+				created = &volumeModeSpec{}
+				json.Unmarshal(body, created)
+				w.WriteHeader(http.StatusCreated)
+				w.Write(body)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		client := kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+		provisioner := &blockTestProvisioner{testProvisioner: newTestProvisioner(), block: test.supportsBlock}
+		ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, test.serverGitVersion)
+		class := newStorageClass("class-1", "foo.bar/baz")
+		ctrl.classes.Add(class)
+
+		err := ctrl.provisionClaimOperation(newClaim("claim-1", "uid-1-1", "class-1", "", nil))
+		if _, ok := err.(*FinalError); ok != test.expectFinalError {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected FinalError %v but got %v\n", test.expectFinalError, err)
+		}
+		if test.expectFinalError {
+			if created != nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected no volume to be created\n")
+			}
+		} else if created == nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume to be created\n")
+		} else if created.Spec.VolumeMode != test.expectedVolumeMode {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume mode %q but got %q\n", test.expectedVolumeMode, created.Spec.VolumeMode)
+		}
+		server.Close()
+	}
+}
+
+func TestSaveKeepsVolumeMode(t *testing.T) {
+	claim := newClaimWithRequest(newClaim("claim-1", "uid-1-1", "class-1", "volume-1", nil), "2Mi")
+	claim.ResourceVersion = "1"
+	volume := newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"})
+	volume.ResourceVersion = "1"
+	volume.Finalizers = []string{finalizerPV}
+
+	tests := []struct {
+		name    string
+		operate func(ctrl *ProvisionController) error
+	}{
+		{
+			name: "record provision failure",
+			operate: func(ctrl *ProvisionController) error {
+				ctrl.updateProvisionStats(claim, errors.New("fake error"))
+				return nil
+			},
+		},
+		{
+			name: "record final provision error",
+			operate: func(ctrl *ProvisionController) error {
+				ctrl.updateProvisionStats(claim, &FinalError{Reason: "invalid parameter"})
+				return nil
+			},
+		},
+		{
+			name: "set provision handle",
+			operate: func(ctrl *ProvisionController) error {
+				return ctrl.setProvisionHandle(claim, "handle-1")
+			},
+		},
+		{
+			name: "take claim lock",
+			operate: func(ctrl *ProvisionController) error {
+				lock := &rl.ProvisionPVCLock{PVCMeta: claim.ObjectMeta, Client: ctrl.client, PatchAnnotation: ctrl.hasVolumeMode()}
+				if _, err := lock.Get(); err != nil {
+					return err
+				}
+				return lock.Update(rl.LeaderElectionRecord{HolderIdentity: "foo"})
+			},
+		},
+		{
+			name: "record delete failure",
+			operate: func(ctrl *ProvisionController) error {
+				ctrl.updateDeleteStats(volume, errors.New("fake error"))
+				return nil
+			},
+		},
+		{
+			name: "remove finalizer",
+			operate: func(ctrl *ProvisionController) error {
+				return ctrl.removeFinalizer(volume)
+			},
+		},
+		{
+			name: "resize volume",
+			operate: func(ctrl *ProvisionController) error {
+				return ctrl.resizeVolumeOperation(claim)
+			},
+		},
+	}
+	for _, test := range tests {
+		var mutex sync.Mutex
+		writes, lost := 0, 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var obj interface{}
+			switch r.URL.Path {
+			case "/api/v1/namespaces/default/persistentvolumeclaims/claim-1":
+				obj = claim
+			case "/api/v1/persistentvolumes/volume-1":
+				obj = volume
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if r.Method != "GET" {
+				// An update must carry the Block mode and a patch must leave
+				// it be: the API server rejects changing it
+				body, _ := ioutil.ReadAll(r.Body)
+				written := &volumeModeSpec{}
+				json.Unmarshal(body, written)
+				mutex.Lock()
+				writes++
+				if (r.Method == "PUT" && written.Spec.VolumeMode != PersistentVolumeBlock) ||
+					(r.Method == "PATCH" && written.Spec.VolumeMode != "" && written.Spec.VolumeMode != PersistentVolumeBlock) {
+					lost++
+				}
+				mutex.Unlock()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, withVolumeMode(obj, PersistentVolumeBlock))
+		}))
+		client := kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newTestResizer(), "v1.9.0")
+
+		if err := test.operate(ctrl); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error: %v\n", err)
+		}
+		mutex.Lock()
+		if writes == 0 {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected the object to be written\n")
+		}
+		if lost > 0 {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected writes to keep volume mode %q\n", PersistentVolumeBlock)
+		}
+		mutex.Unlock()
+		server.Close()
+	}
+}
+
+// withVolumeMode returns the JSON of the claim or volume with the given mode
+func withVolumeMode(obj interface{}, volumeMode PersistentVolumeMode) string {
+	body, _ := json.Marshal(obj)
+	object := map[string]interface{}{}
+	json.Unmarshal(body, &object)
+	object["spec"].(map[string]interface{})["volumeMode"] = volumeMode
+	body, _ = json.Marshal(object)
+	return string(body)
+}
+
+// blockTestProvisioner's SupportsBlock returns block
+type blockTestProvisioner struct {
+	*testProvisioner
+	block bool
+}
+
+var _ BlockProvisioner = &blockTestProvisioner{}
+
+func (p *blockTestProvisioner) SupportsBlock() bool {
+	return p.block
+}
+
+func TestStoreObjectUpdate(t *testing.T) {
+	tests := []struct {
+		name            string
+		cachedVersion   string
+		savedVersion    string
+		expectedVersion string
+	}{
+		{
+			name:            "store newer claim",
+			cachedVersion:   "1",
+			savedVersion:    "2",
+			expectedVersion: "2",
+		},
+		{
+			name:            "keep newer cached claim",
+			cachedVersion:   "3",
+			savedVersion:    "2",
+			expectedVersion: "3",
+		},
+		{
+			name:            "store claim not in cache",
+			savedVersion:    "2",
+			expectedVersion: "2",
+		},
+	}
+	for _, test := range tests {
+		store := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+		if test.cachedVersion != "" {
+			cached := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+			cached.ResourceVersion = test.cachedVersion
+			store.Add(cached)
+		}
+		saved := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+		saved.ResourceVersion = test.savedVersion
+
+		storeObjectUpdate(store, saved)
+
+		obj, found, err := store.Get(saved)
+		if err != nil || !found {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected claim in cache but got found %v, error %v", found, err)
+			continue
+		}
+		if version := obj.(*v1.PersistentVolumeClaim).ResourceVersion; test.expectedVersion != version {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected cached resource version %q but got %q", test.expectedVersion, version)
+		}
+	}
+}
@@ -18,7 +18,9 @@ limitations under the License.
 // dummy endpoints object & its annotation as a lock. Here a pvc is used and
 // the lock is to help ensure only one provisioner (the leader) is trying to
 // provision a volume for the pvc at a time. So the election lasts only until
-// the task is completed. Adds also a 'TermLimit.'
+// the task is completed. Adds also a 'TermLimit.' A configmap or endpoints
+// lock with no task and no TermLimit gives the original behaviour of electing
+// a single leader for as long as it can renew its lease.
 // https://github.com/kubernetes/kubernetes/tree/release-1.5/pkg/client/leaderelection
 
 package leaderelection
@@ -128,10 +130,12 @@ func (le *LeaderElector) Run(task <-chan bool) {
 	stop := make(chan struct{})
 	go le.config.Callbacks.OnStartedLeading(stop)
 	timeout := make(chan bool, 1)
-	go func() {
-		time.Sleep(le.config.TermLimit)
-		timeout <- true
-	}()
+	if le.config.TermLimit > 0 {
+		go func() {
+			time.Sleep(le.config.TermLimit)
+			timeout <- true
+		}()
+	}
 	le.renew(task, timeout)
 	close(stop)
 	le.config.Callbacks.OnStoppedLeading()
@@ -17,13 +17,20 @@ limitations under the License.
 package resourcelock
 
 import (
+	"fmt"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 )
 
 const (
 	// LeaderElectionRecordAnnotationKey is the annotation key for records
 	LeaderElectionRecordAnnotationKey = "control-plane.alpha.kubernetes.io/leader"
+	// EndpointsResourceLock is the lock type of EndpointsLock
+	EndpointsResourceLock = "endpoints"
+	// ConfigMapsResourceLock is the lock type of ConfigMapLock
+	ConfigMapsResourceLock = "configmaps"
 )
 
 // LeaderElectionRecord is the record that is stored in the leader election annotation.
@@ -70,3 +77,30 @@ type Interface interface {
 	// into a string
 	Describe() string
 }
+
+// New creates a lock of the given type on the object with the given namespace
+// & name, to be created if it doesn't exist
+func New(lockType string, ns string, name string, client clientset.Interface, rlc Config) (Interface, error) {
+	switch lockType {
+	case EndpointsResourceLock:
+		return &EndpointsLock{
+			EndpointsMeta: metav1.ObjectMeta{
+				Namespace: ns,
+				Name:      name,
+			},
+			Client:     client,
+			LockConfig: rlc,
+		}, nil
+	case ConfigMapsResourceLock:
+		return &ConfigMapLock{
+			ConfigMapMeta: metav1.ObjectMeta{
+				Namespace: ns,
+				Name:      name,
+			},
+			Client:     client,
+			LockConfig: rlc,
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid lock-type %s", lockType)
+	}
+}
@@ -23,6 +23,7 @@ import (
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	clientset "k8s.io/client-go/kubernetes"
 )
 
@@ -33,7 +34,11 @@ type ProvisionPVCLock struct {
 	PVCMeta    metav1.ObjectMeta
 	Client     clientset.Interface
 	LockConfig Config
-	p          *v1.PersistentVolumeClaim
+	// PatchAnnotation makes Update patch only the lock annotation instead of
+	// updating the whole PVC, which would drop the fields the vendored v1
+	// type lacks, e.g. spec.volumeMode
+	PatchAnnotation bool
+	p               *v1.PersistentVolumeClaim
 }
 
 // Get returns the LeaderElectionRecord
@@ -70,6 +75,20 @@ func (pl *ProvisionPVCLock) Update(ler LeaderElectionRecord) error {
 	if err != nil {
 		return err
 	}
+	if pl.PatchAnnotation {
+		// Like an update, the patch fails if the PVC changed since Get
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations":     map[string]string{LeaderElectionRecordAnnotationKey: string(recordBytes)},
+				"resourceVersion": pl.p.ResourceVersion,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		pl.p, err = pl.Client.Core().PersistentVolumeClaims(pl.PVCMeta.Namespace).Patch(pl.PVCMeta.Name, types.MergePatchType, patch)
+		return err
+	}
 	pl.p.Annotations[LeaderElectionRecordAnnotationKey] = string(recordBytes)
 	pl.p, err = pl.Client.Core().PersistentVolumeClaims(pl.PVCMeta.Namespace).Update(pl.p)
 	return err
@@ -0,0 +1,178 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This is a trimmed down version of client-go's workqueue package, which is
+// not in the version of client-go vendored here. It keeps the semantics the
+// controller relies on: an item is never processed by more than one worker at
+// a time, an item added while it is being processed is processed again once
+// Done, and failed items can be re-added with per-item exponential backoff.
+// https://github.com/kubernetes/client-go/tree/release-4.0/util/workqueue
+
+package workqueue
+
+import (
+	"sync"
+)
+
+// Interface is a work queue
+type Interface interface {
+	// Add marks item as needing processing
+	Add(item interface{})
+	// Len returns the number of items waiting to be processed, not counting
+	// those being processed
+	Len() int
+	// Get blocks until it can return an item to be processed. If shutdown is
+	// true, the caller should end its goroutine. Done must be called with the
+	// item when the caller has finished processing it.
+	Get() (item interface{}, shutdown bool)
+	// Done marks item as done processing, and if it has been marked as dirty
+	// again while it was being processed, it will be re-added to the queue
+	Done(item interface{})
+	// ShutDown causes Get to return shutdown true once the queue is empty
+	ShutDown()
+	// ShuttingDown returns whether ShutDown has been called
+	ShuttingDown() bool
+}
+
+// New constructs a new work queue
+func New() *Type {
+	return &Type{
+		dirty:      set{},
+		processing: set{},
+		cond:       sync.NewCond(&sync.Mutex{}),
+	}
+}
+
+// Type is a work queue
+type Type struct {
+	// queue defines the order in which we will work on items. Every element of
+	// queue should be in the dirty set and not in the processing set.
+	queue []interface{}
+
+	// dirty defines all of the items that need to be processed.
+	dirty set
+
+	// Things that are currently being processed are in the processing set.
+	// These things may be simultaneously in the dirty set. When we finish
+	// processing something and remove it from this set, we'll check if it's in
+	// the dirty set, and if so, add it to the queue.
+	processing set
+
+	cond *sync.Cond
+
+	shuttingDown bool
+}
+
+var _ Interface = &Type{}
+
+type empty struct{}
+type set map[interface{}]empty
+
+func (s set) has(item interface{}) bool {
+	_, exists := s[item]
+	return exists
+}
+
+func (s set) insert(item interface{}) {
+	s[item] = empty{}
+}
+
+func (s set) delete(item interface{}) {
+	delete(s, item)
+}
+
+// Add marks item as needing processing.
+func (q *Type) Add(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if q.dirty.has(item) {
+		return
+	}
+
+	q.dirty.insert(item)
+	if q.processing.has(item) {
+		return
+	}
+
+	q.queue = append(q.queue, item)
+	q.cond.Signal()
+}
+
+// Len returns the current queue length, for informational purposes only. You
+// shouldn't e.g. gate a call to Add() or Get() on Len() being a particular
+// value, that can't be synchronized properly.
+func (q *Type) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return len(q.queue)
+}
+
+// Get blocks until it can return an item to be processed. If shutdown = true,
+// the caller should end their goroutine. You must call Done with item when you
+// have finished processing it.
+func (q *Type) Get() (item interface{}, shutdown bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.queue) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 {
+		// We must be shutting down.
+		return nil, true
+	}
+
+	item, q.queue = q.queue[0], q.queue[1:]
+
+	q.processing.insert(item)
+	q.dirty.delete(item)
+
+	return item, false
+}
+
+// Done marks item as done processing, and if it has been marked as dirty again
+// while it was being processed, it will be re-added to the queue for
+// re-processing.
+func (q *Type) Done(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	q.processing.delete(item)
+	if q.dirty.has(item) {
+		q.queue = append(q.queue, item)
+		q.cond.Signal()
+	}
+}
+
+// ShutDown will cause q to ignore all new items added to it. As soon as the
+// worker goroutines have drained the existing items in the queue, they will be
+// instructed to exit.
+func (q *Type) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// ShuttingDown returns whether ShutDown has been called
+func (q *Type) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	return q.shuttingDown
+}
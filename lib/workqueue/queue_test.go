@@ -0,0 +1,97 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workqueue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeduplication(t *testing.T) {
+	q := New()
+	q.Add("foo")
+	q.Add("foo")
+	if q.Len() != 1 {
+		t.Errorf("expected queue length 1 but got %d", q.Len())
+	}
+
+	item, _ := q.Get()
+	// Added while processing: not queued until Done
+	q.Add("foo")
+	if q.Len() != 0 {
+		t.Errorf("expected queue length 0 while processing but got %d", q.Len())
+	}
+	q.Done(item)
+	if q.Len() != 1 {
+		t.Errorf("expected queue length 1 after done but got %d", q.Len())
+	}
+}
+
+func TestShutDown(t *testing.T) {
+	q := New()
+	q.Add("foo")
+	q.ShutDown()
+	q.Add("bar")
+
+	if item, shutdown := q.Get(); item != "foo" || shutdown {
+		t.Errorf("expected to drain item foo but got %v, shutdown %v", item, shutdown)
+	}
+	if _, shutdown := q.Get(); !shutdown {
+		t.Errorf("expected shutdown once drained")
+	}
+}
+
+func TestItemExponentialFailureRateLimiter(t *testing.T) {
+	r := NewItemExponentialFailureRateLimiter(1*time.Millisecond, 1*time.Second)
+
+	expected := []time.Duration{1 * time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 8 * time.Millisecond}
+	for i, e := range expected {
+		if d := r.When("one"); d != e {
+			t.Errorf("failure %d: expected %v but got %v", i, e, d)
+		}
+	}
+	if n := r.NumRequeues("one"); n != len(expected) {
+		t.Errorf("expected %d requeues but got %d", len(expected), n)
+	}
+
+	for i := 0; i < 20; i++ {
+		r.When("one")
+	}
+	if d := r.When("one"); d != 1*time.Second {
+		t.Errorf("expected max delay %v but got %v", 1*time.Second, d)
+	}
+
+	r.Forget("one")
+	if n := r.NumRequeues("one"); n != 0 {
+		t.Errorf("expected 0 requeues after forget but got %d", n)
+	}
+	if d := r.When("one"); d != 1*time.Millisecond {
+		t.Errorf("expected base delay after forget but got %v", d)
+	}
+}
+
+func TestAddRateLimited(t *testing.T) {
+	q := NewRateLimitingQueue(NewItemExponentialFailureRateLimiter(10*time.Millisecond, time.Second))
+	q.AddRateLimited("foo")
+	if q.Len() != 0 {
+		t.Errorf("expected item not to be added before its delay")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if q.Len() != 1 {
+		t.Errorf("expected item to be added after its delay")
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workqueue
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitingInterface is an Interface that can re-add items after a delay
+// determined by how many times they have failed
+type RateLimitingInterface interface {
+	Interface
+
+	// AddAfter adds an item to the queue after the indicated duration has
+	// passed
+	AddAfter(item interface{}, duration time.Duration)
+	// AddRateLimited adds an item to the queue after the rate limiter says it's
+	// ok
+	AddRateLimited(item interface{})
+	// Forget indicates that an item is finished being retried. It doesn't
+	// matter whether it's for perm failing or for success, we'll stop the rate
+	// limiter from tracking it. This only clears the `rateLimiter`, you still
+	// have to call `Done` on the queue.
+	Forget(item interface{})
+	// NumRequeues returns back how many times the item was requeued
+	NumRequeues(item interface{}) int
+}
+
+// NewRateLimitingQueue constructs a new work queue with rate limited re-adding
+func NewRateLimitingQueue(rateLimiter RateLimiter) RateLimitingInterface {
+	return &rateLimitingType{
+		Type:        New(),
+		rateLimiter: rateLimiter,
+	}
+}
+
+type rateLimitingType struct {
+	*Type
+
+	rateLimiter RateLimiter
+}
+
+// AddAfter adds an item to the queue after the indicated duration has passed
+func (q *rateLimitingType) AddAfter(item interface{}, duration time.Duration) {
+	if q.ShuttingDown() {
+		return
+	}
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+	time.AfterFunc(duration, func() {
+		q.Add(item)
+	})
+}
+
+// AddRateLimited adds an item to the queue after the rate limiter says it's ok
+func (q *rateLimitingType) AddRateLimited(item interface{}) {
+	q.AddAfter(item, q.rateLimiter.When(item))
+}
+
+func (q *rateLimitingType) NumRequeues(item interface{}) int {
+	return q.rateLimiter.NumRequeues(item)
+}
+
+func (q *rateLimitingType) Forget(item interface{}) {
+	q.rateLimiter.Forget(item)
+}
+
+// RateLimiter decides how long an item should wait before being re-added
+type RateLimiter interface {
+	// When gets an item and gets to decide how long that item should wait
+	When(item interface{}) time.Duration
+	// Forget indicates that an item is finished being retried. Doesn't matter
+	// whether its for perm failing or for success, we'll stop tracking it
+	Forget(item interface{})
+	// NumRequeues returns back how many failures the item has had
+	NumRequeues(item interface{}) int
+}
+
+// ItemExponentialFailureRateLimiter does a simple baseDelay*2^<num-failures>
+// limit, dealing with max failures and expiration are up to the caller
+type ItemExponentialFailureRateLimiter struct {
+	failuresLock sync.Mutex
+	failures     map[interface{}]int
+
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+var _ RateLimiter = &ItemExponentialFailureRateLimiter{}
+
+// NewItemExponentialFailureRateLimiter constructs an
+// ItemExponentialFailureRateLimiter
+func NewItemExponentialFailureRateLimiter(baseDelay time.Duration, maxDelay time.Duration) *ItemExponentialFailureRateLimiter {
+	return &ItemExponentialFailureRateLimiter{
+		failures:  map[interface{}]int{},
+		baseDelay: baseDelay,
+		maxDelay:  maxDelay,
+	}
+}
+
+// When returns baseDelay*2^<num-failures>, at most maxDelay, and records a
+// failure of item
+func (r *ItemExponentialFailureRateLimiter) When(item interface{}) time.Duration {
+	r.failuresLock.Lock()
+	defer r.failuresLock.Unlock()
+
+	exp := r.failures[item]
+	r.failures[item] = r.failures[item] + 1
+
+	// The backoff is capped such that 'calculated' value never overflows.
+	backoff := float64(r.baseDelay.Nanoseconds()) * math.Pow(2, float64(exp))
+	if backoff > math.MaxInt64 {
+		return r.maxDelay
+	}
+
+	calculated := time.Duration(backoff)
+	if calculated > r.maxDelay {
+		return r.maxDelay
+	}
+
+	return calculated
+}
+
+// NumRequeues returns the number of failures of item
+func (r *ItemExponentialFailureRateLimiter) NumRequeues(item interface{}) int {
+	r.failuresLock.Lock()
+	defer r.failuresLock.Unlock()
+
+	return r.failures[item]
+}
+
+// Forget stops tracking item
+func (r *ItemExponentialFailureRateLimiter) Forget(item interface{}) {
+	r.failuresLock.Lock()
+	defer r.failuresLock.Unlock()
+
+	delete(r.failures, item)
+}
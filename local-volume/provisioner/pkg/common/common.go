@@ -47,6 +47,9 @@ const (
 	// DefaultMountDir is the container mount point for the default host dir.
 	DefaultMountDir = "/local-disks"
 
+	// VolumeModeBlock is the PV volumeMode of block type volumes
+	VolumeModeBlock = "Block"
+
 	// EventVolumeFailedDelete copied from k8s.io/kubernetes/pkg/controller/volume/events
 	EventVolumeFailedDelete = "VolumeFailedDelete"
 )
@@ -65,8 +68,16 @@ type MountConfig struct {
 	HostDir string `json:"hostDir"`
 	// The mount point of the hostpath volume
 	MountDir string `json:"mountDir"`
+	// The command run to clean up block volumes, with the device path in
+	// env LOCAL_PV_BLKDEVICE. Defaults to DefaultBlockCleanerCommand.
+	BlockCleanerCommand []string `json:"blockCleanerCommand,omitempty"`
 }
 
+// DefaultBlockCleanerCommand is the default command to clean up block volumes.
+// It wipes the whole device so no data is left for the next user: it discards
+// every block, or zeroes the device if it doesn't support discard.
+var DefaultBlockCleanerCommand = []string{"/bin/sh", "-c", "blkdiscard \"$LOCAL_PV_BLKDEVICE\" || shred -n 0 -z \"$LOCAL_PV_BLKDEVICE\""}
+
 // RuntimeConfig stores all the objects that the provisioner needs to run
 type RuntimeConfig struct {
 	*UserConfig
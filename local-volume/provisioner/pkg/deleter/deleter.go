@@ -79,19 +79,6 @@ func (d *Deleter) cleanupPV(pv *v1.PersistentVolume) error {
 		return fmt.Errorf("Unknown storage class name %v", pv.Spec.StorageClassName)
 	}
 
-	// TODO: Get volType from PV.
-	volType := common.VolumeTypeFile
-	switch volType {
-	case common.VolumeTypeFile:
-		return d.cleanupFileVolume(pv, config)
-	case common.VolumeTypeBlock:
-		return fmt.Errorf("Not yet implemented")
-	default:
-		return fmt.Errorf("Unexpected volume type %q for deleting path %q", volType, pv.Spec.Local.Path)
-	}
-}
-
-func (d *Deleter) cleanupFileVolume(pv *v1.PersistentVolume, config common.MountConfig) error {
 	specPath := pv.Spec.Local.Path
 	relativePath, err := filepath.Rel(config.HostDir, specPath)
 	if err != nil {
@@ -100,6 +87,33 @@ func (d *Deleter) cleanupFileVolume(pv *v1.PersistentVolume, config common.Mount
 
 	mountPath := filepath.Join(config.MountDir, relativePath)
 
+	// The cached PV has no volumeMode, so check what is at the path. If the
+	// check fails the path is treated as a directory, as it was discovered.
+	volType := common.VolumeTypeFile
+	if isBlock, _ := d.VolUtil.IsBlock(mountPath); isBlock {
+		volType = common.VolumeTypeBlock
+	}
+	switch volType {
+	case common.VolumeTypeFile:
+		return d.cleanupFileVolume(pv, specPath, mountPath)
+	case common.VolumeTypeBlock:
+		return d.cleanupBlockVolume(pv, specPath, mountPath, config)
+	default:
+		return fmt.Errorf("Unexpected volume type %q for deleting path %q", volType, specPath)
+	}
+}
+
+func (d *Deleter) cleanupFileVolume(pv *v1.PersistentVolume, specPath, mountPath string) error {
 	glog.Infof("Deleting PV %q contents at hostpath %q, mountpath %q", pv.Name, specPath, mountPath)
 	return d.VolUtil.DeleteContents(mountPath)
 }
+
+func (d *Deleter) cleanupBlockVolume(pv *v1.PersistentVolume, specPath, mountPath string, config common.MountConfig) error {
+	command := config.BlockCleanerCommand
+	if len(command) == 0 {
+		command = common.DefaultBlockCleanerCommand
+	}
+
+	glog.Infof("Cleaning PV %q block device at hostpath %q, mountpath %q", pv.Name, specPath, mountPath)
+	return d.VolUtil.CleanupBlockDevice(mountPath, command)
+}
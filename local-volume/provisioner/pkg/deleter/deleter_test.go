@@ -36,6 +36,8 @@ const (
 type testConfig struct {
 	apiShouldFail       bool
 	volDeleteShouldFail bool
+	// True if the volumes are block devices
+	blockVolumes bool
 	// Precreated PVs
 	vols map[string]*testVol
 	// Expected names of deleted PV
@@ -149,10 +151,57 @@ func TestDeleteVolumes_CleanupFails(t *testing.T) {
 	verifyPVExists(t, test)
 }
 
+func TestDeleteVolumes_Block(t *testing.T) {
+	vols := map[string]*testVol{
+		"pv4": {
+			pvPhase: v1.VolumeReleased,
+		},
+	}
+	test := &testConfig{
+		blockVolumes:       true,
+		vols:               vols,
+		expectedDeletedPVs: map[string]string{"pv4": ""},
+	}
+	d := testSetup(t, test)
+
+	d.DeletePVs()
+	verifyDeletedPVs(t, test)
+
+	cleaned := test.volUtil.GetAndResetCleanedBlockDevices()
+	expectedPath := filepath.Join(testMountDir, "test-dir")
+	if len(cleaned) != 1 || cleaned[0] != expectedPath {
+		t.Errorf("Expected block device %q to be cleaned up, got %v", expectedPath, cleaned)
+	}
+}
+
+func TestDeleteVolumes_BlockCleanupFails(t *testing.T) {
+	vols := map[string]*testVol{
+		"pv4": {
+			pvPhase: v1.VolumeReleased,
+		},
+	}
+	test := &testConfig{
+		volDeleteShouldFail: true,
+		blockVolumes:        true,
+		vols:                vols,
+		expectedDeletedPVs:  map[string]string{},
+	}
+	d := testSetup(t, test)
+
+	d.DeletePVs()
+	verifyDeletedPVs(t, test)
+	verifyPVExists(t, test)
+}
+
 func testSetup(t *testing.T, config *testConfig) *Deleter {
 	config.cache = cache.NewVolumeCache()
 	config.volUtil = util.NewFakeVolumeUtil(config.volDeleteShouldFail)
 	config.apiUtil = util.NewFakeAPIUtil(false, config.cache)
+	if config.blockVolumes {
+		config.volUtil.AddNewDirEntries(testMountDir, map[string][]*util.FakeDirEntry{
+			"": {{Name: "test-dir", VolumeType: util.FakeEntryBlock}},
+		})
+	}
 
 	fakePath := filepath.Join(testHostDir, "test-dir")
 	// Precreate PVs
@@ -160,7 +160,6 @@ func (d *Discoverer) createPV(file, class string, config common.MountConfig, cap
 	glog.Infof("Found new volume of volumeType %q at host path %q with capacity %d, creating Local PV %q",
 		volType, outsidePath, capacityByte, pvName)
 
-	// TODO: Set block volumeType when the API is ready.
 	pvSpec := common.CreateLocalPVSpec(&common.LocalPVConfig{
 		Name:            pvName,
 		HostPath:        outsidePath,
@@ -170,7 +169,12 @@ func (d *Discoverer) createPV(file, class string, config common.MountConfig, cap
 		AffinityAnn:     d.nodeAffinityAnn,
 	})
 
-	_, err := d.APIUtil.CreatePV(pvSpec)
+	var err error
+	if volType == common.VolumeTypeBlock {
+		_, err = d.APIUtil.CreateBlockPV(pvSpec)
+	} else {
+		_, err = d.APIUtil.CreatePV(pvSpec)
+	}
 	if err != nil {
 		glog.Errorf("Error creating PV %q for volume at %q: %v", pvName, outsidePath, err)
 		return
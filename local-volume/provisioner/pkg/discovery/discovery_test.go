@@ -335,6 +335,7 @@ type testPVInfo struct {
 	path         string
 	capacity     int64
 	storageClass string
+	volumeType   string
 }
 
 func verifyCreatedPVs(t *testing.T, test *testConfig) {
@@ -348,6 +349,7 @@ func verifyCreatedPVs(t *testing.T, test *testConfig) {
 				path:         path,
 				capacity:     file.Capacity,
 				storageClass: findSCName(t, dir, test),
+				volumeType:   file.VolumeType,
 			}
 		}
 	}
@@ -378,7 +380,10 @@ func verifyCreatedPVs(t *testing.T, test *testConfig) {
 		verifyProvisionerName(t, createdPV)
 		verifyNodeAffinity(t, createdPV)
 		verifyCapacity(t, createdPV, expectedPV)
-		// TODO: Verify volume type once that is supported in the API.
+		isBlock := test.apiUtil.IsBlockPV(pvName)
+		if isBlock != (expectedPV.volumeType == util.FakeEntryBlock) {
+			t.Errorf("Expected PV %q of volume type %q, got block %v", pvName, expectedPV.volumeType, isBlock)
+		}
 	}
 }
 
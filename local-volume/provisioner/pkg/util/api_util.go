@@ -17,6 +17,7 @@ limitations under the License.
 package util
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/kubernetes-incubator/external-storage/local-volume/provisioner/pkg/cache"
@@ -31,6 +32,9 @@ type APIUtil interface {
 	// Create PersistentVolume object
 	CreatePV(pv *v1.PersistentVolume) (*v1.PersistentVolume, error)
 
+	// Create PersistentVolume object with volumeMode Block
+	CreateBlockPV(pv *v1.PersistentVolume) (*v1.PersistentVolume, error)
+
 	// Delete PersistentVolume object
 	DeletePV(pvName string) error
 }
@@ -51,6 +55,39 @@ func (u *apiUtil) CreatePV(pv *v1.PersistentVolume) (*v1.PersistentVolume, error
 	return u.client.Core().PersistentVolumes().Create(pv)
 }
 
+// CreateBlockPV will create a PersistentVolume with volumeMode Block. The
+// vendored v1 types have no volumeMode so the raw object is posted.
+func (u *apiUtil) CreateBlockPV(pv *v1.PersistentVolume) (*v1.PersistentVolume, error) {
+	body, err := json.Marshal(pv)
+	if err != nil {
+		return nil, err
+	}
+	object := map[string]interface{}{}
+	if err = json.Unmarshal(body, &object); err != nil {
+		return nil, err
+	}
+	object["apiVersion"] = "v1"
+	object["kind"] = "PersistentVolume"
+	spec, ok := object["spec"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("PV %q has no spec", pv.Name)
+	}
+	spec["volumeMode"] = "Block"
+	if body, err = json.Marshal(object); err != nil {
+		return nil, err
+	}
+
+	raw, err := u.client.Core().RESTClient().Post().Resource("persistentvolumes").Body(body).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	result := &v1.PersistentVolume{}
+	if err = json.Unmarshal(raw, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // DeletePV will delete a PersistentVolume
 func (u *apiUtil) DeletePV(pvName string) error {
 	return u.client.Core().PersistentVolumes().Delete(pvName, &metav1.DeleteOptions{})
@@ -61,6 +98,7 @@ var _ APIUtil = &FakeAPIUtil{}
 // FakeAPIUtil is a fake API wrapper for unit testing
 type FakeAPIUtil struct {
 	createdPVs map[string]*v1.PersistentVolume
+	blockPVs   map[string]bool
 	deletedPVs map[string]*v1.PersistentVolume
 	shouldFail bool
 	cache      *cache.VolumeCache
@@ -70,6 +108,7 @@ type FakeAPIUtil struct {
 func NewFakeAPIUtil(shouldFail bool, cache *cache.VolumeCache) *FakeAPIUtil {
 	return &FakeAPIUtil{
 		createdPVs: map[string]*v1.PersistentVolume{},
+		blockPVs:   map[string]bool{},
 		deletedPVs: map[string]*v1.PersistentVolume{},
 		shouldFail: shouldFail,
 		cache:      cache,
@@ -87,6 +126,22 @@ func (u *FakeAPIUtil) CreatePV(pv *v1.PersistentVolume) (*v1.PersistentVolume, e
 	return pv, nil
 }
 
+// CreateBlockPV will add the PV to the created list and cache, and record it
+// as a block PV
+func (u *FakeAPIUtil) CreateBlockPV(pv *v1.PersistentVolume) (*v1.PersistentVolume, error) {
+	if _, err := u.CreatePV(pv); err != nil {
+		return nil, err
+	}
+	u.blockPVs[pv.Name] = true
+	return pv, nil
+}
+
+// IsBlockPV returns whether the PV was created with CreateBlockPV
+// This is only for testing
+func (u *FakeAPIUtil) IsBlockPV(pvName string) bool {
+	return u.blockPVs[pvName]
+}
+
 // DeletePV will delete the PV from the created list and cache, and also add it to the deleted list
 func (u *FakeAPIUtil) DeletePV(pvName string) error {
 	if u.shouldFail {
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"golang.org/x/sys/unix"
 	"os"
+	"os/exec"
 	"path/filepath"
 
 	"github.com/golang/glog"
@@ -47,6 +48,9 @@ type VolumeUtil interface {
 
 	// Get capacity of the block device
 	GetBlockCapacityByte(fullPath string) (int64, error)
+
+	// Run the given command to clean up the block device at the given path
+	CleanupBlockDevice(fullPath string, command []string) error
 }
 
 var _ VolumeUtil = &volumeUtil{}
@@ -150,6 +154,21 @@ func (u *volumeUtil) GetBlockCapacityByte(fullPath string) (int64, error) {
 	return size, err
 }
 
+// CleanupBlockDevice runs the given command with env LOCAL_PV_BLKDEVICE set
+// to the block device path
+func (u *volumeUtil) CleanupBlockDevice(fullPath string, command []string) error {
+	if len(command) == 0 {
+		return fmt.Errorf("No block cleaner command")
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), "LOCAL_PV_BLKDEVICE="+fullPath)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Block cleaner command %v failed: %v, output: %s", command, err, output)
+	}
+	return nil
+}
+
 var _ VolumeUtil = &FakeVolumeUtil{}
 
 // FakeVolumeUtil is a stub interface for unit testing
@@ -158,6 +177,8 @@ type FakeVolumeUtil struct {
 	directoryFiles map[string][]*FakeDirEntry
 	// True if DeleteContents should fail
 	deleteShouldFail bool
+	// Block devices cleaned up by CleanupBlockDevice
+	cleanedBlockDevices []string
 }
 
 const (
@@ -255,6 +276,24 @@ func (u *FakeVolumeUtil) GetBlockCapacityByte(fullPath string) (int64, error) {
 	return u.getDirEntryCapacity(fullPath, FakeEntryBlock)
 }
 
+// CleanupBlockDevice records the cleaned up block device
+func (u *FakeVolumeUtil) CleanupBlockDevice(fullPath string, command []string) error {
+	if u.deleteShouldFail {
+		return fmt.Errorf("Fake block cleanup failed")
+	}
+	u.cleanedBlockDevices = append(u.cleanedBlockDevices, fullPath)
+	return nil
+}
+
+// GetAndResetCleanedBlockDevices returns the block devices cleaned up and
+// resets the list
+// This is only for testing
+func (u *FakeVolumeUtil) GetAndResetCleanedBlockDevices() []string {
+	cleaned := u.cleanedBlockDevices
+	u.cleanedBlockDevices = nil
+	return cleaned
+}
+
 func (u *FakeVolumeUtil) getDirEntryCapacity(fullPath string, entryType string) (int64, error) {
 	dir, file := filepath.Split(fullPath)
 	dir = filepath.Clean(dir)
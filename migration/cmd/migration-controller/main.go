@@ -0,0 +1,78 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+
+	"github.com/golang/glog"
+	migrationclient "github.com/kubernetes-incubator/external-storage/migration/pkg/client"
+	"github.com/kubernetes-incubator/external-storage/migration/pkg/controller"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	master       = flag.String("master", "", "Master URL")
+	kubeconfig   = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
+	copyImage    = flag.String("copy-image", controller.DefaultCopyImage, "Image of the jobs that copy data between volumes")
+	copyTimeout  = flag.Duration("copy-timeout", controller.DefaultCopyTimeout, "How long a copy job may run before the migration is failed")
+	resyncPeriod = flag.Duration("resync-period", controller.DefaultResyncPeriod, "How often to check the progress of all VolumeMigrations")
+)
+
+func main() {
+	flag.Parse()
+	flag.Set("logtostderr", "true")
+
+	var config *rest.Config
+	var err error
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+	migrationClient, scheme, err := migrationclient.NewClient(config)
+	if err != nil {
+		glog.Fatalf("Failed to create migration client: %v", err)
+	}
+
+	if err = migrationclient.CreateCRD(clientset); err != nil {
+		glog.Fatalf("Failed to create migration custom resource definition: %v", err)
+	}
+	if err = migrationclient.WaitForMigrationResource(migrationClient); err != nil {
+		glog.Fatalf("Failed to wait for migration custom resource: %v", err)
+	}
+
+	// The controller needs to know what the server version is because claims
+	// only have volume modes since 1.9
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	ctrl := controller.NewMigrationController(clientset, migrationClient, scheme, serverVersion.GitVersion, *copyImage, *copyTimeout, *resyncPeriod)
+	ctrl.Run(wait.NeverStop)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	core_v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// VolumeMigrationResourcePlural is the plural of VolumeMigration
+const VolumeMigrationResourcePlural = "volumemigrations"
+
+// VolumeMigration moves the data of a claim to a new volume of another
+// StorageClass. When it succeeds the claim, recreated with the same name, is
+// bound to the new volume and the old volume is retained for rollback.
+type VolumeMigration struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata"`
+
+	// Spec represents the desired migration
+	Spec VolumeMigrationSpec `json:"spec"`
+
+	// Status represents the progress of the migration
+	// +optional
+	Status VolumeMigrationStatus `json:"status"`
+}
+
+// VolumeMigrationList is a list of VolumeMigration objects
+type VolumeMigrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ListMeta   `json:"metadata"`
+	Items           []VolumeMigration `json:"items"`
+}
+
+// VolumeMigrationSpec is the description of a migration
+type VolumeMigrationSpec struct {
+	// Name of the claim, in the VolumeMigration's namespace, whose data to
+	// migrate
+	PersistentVolumeClaimName string `json:"persistentVolumeClaimName"`
+	// Name of the StorageClass to migrate the claim's data to
+	StorageClassName string `json:"storageClassName"`
+}
+
+// VolumeMigrationPhase is the phase of a migration
+type VolumeMigrationPhase string
+
+// These are the phases of a migration, in order
+const (
+	// VolumeMigrationPending means the source claim has not been checked yet
+	// or is in use
+	VolumeMigrationPending VolumeMigrationPhase = "Pending"
+	// VolumeMigrationProvisioning means the target claim has been created and
+	// is waiting to be bound
+	VolumeMigrationProvisioning VolumeMigrationPhase = "Provisioning"
+	// VolumeMigrationCopying means the copy job is running
+	VolumeMigrationCopying VolumeMigrationPhase = "Copying"
+	// VolumeMigrationSwapping means the data has been copied & verified and
+	// the claim is being bound to the target volume
+	VolumeMigrationSwapping VolumeMigrationPhase = "Swapping"
+	// VolumeMigrationSucceeded means the claim is bound to the target volume
+	VolumeMigrationSucceeded VolumeMigrationPhase = "Succeeded"
+	// VolumeMigrationFailed means the migration failed before the swap and
+	// will not be retried. The source claim is untouched.
+	VolumeMigrationFailed VolumeMigrationPhase = "Failed"
+)
+
+// VolumeMigrationStatus is the progress of a migration
+type VolumeMigrationStatus struct {
+	// Phase of the migration
+	// +optional
+	Phase VolumeMigrationPhase `json:"phase,omitempty"`
+	// Human-readable message about the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+	// UID of the source claim, so that the claim recreated by the swap is not
+	// mistaken for it
+	// +optional
+	SourceClaimUID types.UID `json:"sourceClaimUID,omitempty"`
+	// Copy of the source claim, saved before the swap deletes it so that it
+	// can be recreated
+	// +optional
+	SourceClaim *core_v1.PersistentVolumeClaim `json:"sourceClaim,omitempty"`
+	// Name of the source volume, retained for rollback once the claim is
+	// swapped
+	// +optional
+	SourceVolumeName string `json:"sourceVolumeName,omitempty"`
+	// Volume mode of the source & target volumes, Filesystem or Block
+	// +optional
+	VolumeMode string `json:"volumeMode,omitempty"`
+	// Name of the claim the target volume is provisioned for, in the
+	// VolumeMigration's namespace
+	// +optional
+	TargetClaimName string `json:"targetClaimName,omitempty"`
+	// Name of the target volume
+	// +optional
+	TargetVolumeName string `json:"targetVolumeName,omitempty"`
+	// Checksums of the source & target data computed by the copy job
+	// +optional
+	SourceChecksum string `json:"sourceChecksum,omitempty"`
+	// +optional
+	TargetChecksum string `json:"targetChecksum,omitempty"`
+	// When the migration entered its current phase
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// GetObjectKind is required to satisfy Object interface
+func (m *VolumeMigration) GetObjectKind() schema.ObjectKind {
+	return &m.TypeMeta
+}
+
+// GetObjectMeta is required to satisfy ObjectMetaAccessor interface
+func (m *VolumeMigration) GetObjectMeta() metav1.Object {
+	return &m.Metadata
+}
+
+// GetObjectKind is required to satisfy Object interface
+func (ml *VolumeMigrationList) GetObjectKind() schema.ObjectKind {
+	return &ml.TypeMeta
+}
+
+// GetListMeta is required to satisfy ListMetaAccessor interface
+func (ml *VolumeMigrationList) GetListMeta() metav1.List {
+	return &ml.Metadata
+}
@@ -0,0 +1,471 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	crdv1 "github.com/kubernetes-incubator/external-storage/migration/pkg/apis/crd/v1"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/api/v1/helper"
+	"k8s.io/kubernetes/pkg/util/goroutinemap"
+	utilversion "k8s.io/kubernetes/pkg/util/version"
+)
+
+const (
+	// targetNamePrefix is the prefix of the names of the target claim & copy
+	// job of a migration, followed by the migration's UID
+	targetNamePrefix = "migration-"
+
+	// labelMigrationUID labels the target claim & copy job pods of a
+	// migration with its UID
+	labelMigrationUID = "volumemigration.external-storage.k8s.io/uid"
+)
+
+// Event reasons
+const (
+	migrationProvisioning = "MigrationProvisioning"
+	migrationCopying      = "MigrationCopying"
+	migrationSwapping     = "MigrationSwapping"
+	migrationSucceeded    = "MigrationSucceeded"
+	migrationFailed       = "MigrationFailed"
+)
+
+// Defaults used when the corresponding flags of the controller are omitted
+const (
+	DefaultResyncPeriod = 15 * time.Second
+	DefaultCopyImage    = "quay.io/external_storage/volume-migration-copier:latest"
+	DefaultCopyTimeout  = 24 * time.Hour
+)
+
+// MigrationController migrates the data of claims to volumes of other
+// StorageClasses as requested by VolumeMigrations. For each it creates a
+// target claim of the new class, which the class's provisioner provisions like
+// any other claim, runs a job that copies the data from the source claim to
+// the target claim and verifies checksums, and then recreates the source claim
+// bound to the target volume, retaining the source volume for rollback.
+//
+// Each migration is driven through its phases by its status alone, so it is
+// resumed wherever it was if the controller restarts. Progress is polled every
+// resyncPeriod.
+type MigrationController struct {
+	client          kubernetes.Interface
+	migrationClient rest.Interface
+
+	kubeVersion *utilversion.Version
+
+	// Image of the copy job, which needs sh, rsync, GNU find, sort & xargs,
+	// sha256sum, dd and blockdev
+	copyImage string
+	// How long the copy job may run before it is failed
+	copyTimeout time.Duration
+
+	eventRecorder record.EventRecorder
+
+	migrationStore      cache.Store
+	migrationController cache.Controller
+
+	// Operations on migrations, by UID. Only one may run at a time.
+	runningOperations goroutinemap.GoRoutineMap
+}
+
+// NewMigrationController creates a new migration controller. migrationClient
+// & scheme are those returned by client.NewClient.
+func NewMigrationController(
+	client kubernetes.Interface,
+	migrationClient rest.Interface,
+	scheme *runtime.Scheme,
+	kubeVersion string,
+	copyImage string,
+	copyTimeout time.Duration,
+	resyncPeriod time.Duration,
+) *MigrationController {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.Core().Events(v1.NamespaceAll)})
+
+	ctrl := &MigrationController{
+		client:            client,
+		migrationClient:   migrationClient,
+		kubeVersion:       utilversion.MustParseSemantic(kubeVersion),
+		copyImage:         copyImage,
+		copyTimeout:       copyTimeout,
+		eventRecorder:     broadcaster.NewRecorder(scheme, v1.EventSource{Component: "volume-migration-controller"}),
+		runningOperations: goroutinemap.NewGoRoutineMap(false),
+	}
+
+	ctrl.migrationStore, ctrl.migrationController = cache.NewInformer(
+		cache.NewListWatchFromClient(migrationClient, crdv1.VolumeMigrationResourcePlural, v1.NamespaceAll, fields.Everything()),
+		&crdv1.VolumeMigration{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    ctrl.addMigration,
+			UpdateFunc: ctrl.updateMigration,
+			DeleteFunc: ctrl.deleteMigration,
+		},
+	)
+
+	return ctrl
+}
+
+// Run starts the controller's control loop
+func (ctrl *MigrationController) Run(stopCh <-chan struct{}) {
+	glog.Info("Starting migration controller")
+	go ctrl.migrationController.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, ctrl.migrationController.HasSynced) {
+		return
+	}
+	glog.Info("Migration controller synced")
+	<-stopCh
+	glog.Info("Stopping migration controller")
+}
+
+func (ctrl *MigrationController) addMigration(obj interface{}) {
+	migration, ok := obj.(*crdv1.VolumeMigration)
+	if !ok {
+		glog.Errorf("Expected VolumeMigration but handler received %#v", obj)
+		return
+	}
+	ctrl.scheduleOperation(string(migration.Metadata.UID), func() error {
+		return ctrl.syncMigration(migration)
+	})
+}
+
+func (ctrl *MigrationController) updateMigration(oldObj, newObj interface{}) {
+	ctrl.addMigration(newObj)
+}
+
+// deleteMigration cancels a migration that hasn't started swapping the claim
+// by deleting its target claim & copy job
+func (ctrl *MigrationController) deleteMigration(obj interface{}) {
+	if unknown, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = unknown.Obj
+	}
+	migration, ok := obj.(*crdv1.VolumeMigration)
+	if !ok {
+		glog.Errorf("Expected VolumeMigration but handler received %#v", obj)
+		return
+	}
+	phase := migration.Status.Phase
+	if phase != crdv1.VolumeMigrationProvisioning && phase != crdv1.VolumeMigrationCopying {
+		return
+	}
+	ctrl.scheduleOperation(string(migration.Metadata.UID), func() error {
+		glog.Infof("Migration %s deleted, cleaning up", migrationKey(migration))
+		return ctrl.cleanupTarget(migration)
+	})
+}
+
+// scheduleOperation runs the operation unless one with the same name is
+// already running, in which case the next event or resync will retry
+func (ctrl *MigrationController) scheduleOperation(name string, operation func() error) {
+	err := ctrl.runningOperations.Run(name, func() error {
+		err := operation()
+		if err != nil {
+			glog.Errorf("Error syncing migration %s: %v", name, err)
+		}
+		return err
+	})
+	if err != nil && !goroutinemap.IsAlreadyExists(err) {
+		glog.Errorf("Error scheduling operation %s: %v", name, err)
+	}
+}
+
+// syncMigration advances the migration from its current phase if it can
+func (ctrl *MigrationController) syncMigration(migration *crdv1.VolumeMigration) error {
+	switch migration.Status.Phase {
+	case "", crdv1.VolumeMigrationPending:
+		return ctrl.startMigration(migration)
+	case crdv1.VolumeMigrationProvisioning:
+		return ctrl.syncProvisioning(migration)
+	case crdv1.VolumeMigrationCopying:
+		return ctrl.syncCopying(migration)
+	case crdv1.VolumeMigrationSwapping:
+		return ctrl.swapClaim(migration)
+	}
+	return nil
+}
+
+// startMigration checks the source claim & target class and creates the
+// target claim
+func (ctrl *MigrationController) startMigration(migration *crdv1.VolumeMigration) error {
+	namespace := migration.Metadata.Namespace
+	claimName := migration.Spec.PersistentVolumeClaimName
+
+	claim, err := ctrl.client.Core().PersistentVolumeClaims(namespace).Get(claimName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ctrl.failMigration(migration, fmt.Sprintf("claim %q not found", claimName))
+	} else if err != nil {
+		return fmt.Errorf("error getting claim %q: %v", claimName, err)
+	}
+	if claim.Status.Phase != v1.ClaimBound {
+		return ctrl.setPending(migration, fmt.Sprintf("waiting for claim %q to be bound", claimName))
+	}
+	if helper.GetPersistentVolumeClaimClass(claim) == migration.Spec.StorageClassName {
+		return ctrl.failMigration(migration, fmt.Sprintf("claim %q is already of StorageClass %q", claimName, migration.Spec.StorageClassName))
+	}
+	_, err = ctrl.client.StorageV1().StorageClasses().Get(migration.Spec.StorageClassName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ctrl.failMigration(migration, fmt.Sprintf("StorageClass %q not found", migration.Spec.StorageClassName))
+	} else if err != nil {
+		return fmt.Errorf("error getting StorageClass %q: %v", migration.Spec.StorageClassName, err)
+	}
+	sourceVolume, err := ctrl.client.Core().PersistentVolumes().Get(claim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting claim %q's volume %q: %v", claimName, claim.Spec.VolumeName, err)
+	}
+	volumeMode, err := ctrl.getClaimVolumeMode(claim)
+	if err != nil {
+		return fmt.Errorf("error getting claim %q's volume mode: %v", claimName, err)
+	}
+
+	// The data must not change while it is copied
+	pods, err := ctrl.podsUsingClaim(namespace, claimName)
+	if err != nil {
+		return err
+	}
+	if len(pods) > 0 {
+		return ctrl.setPending(migration, fmt.Sprintf("waiting for pods %v to stop using claim %q", pods, claimName))
+	}
+
+	targetClaim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      targetNamePrefix + string(migration.Metadata.UID),
+			Namespace: namespace,
+			Labels:    map[string]string{labelMigrationUID: string(migration.Metadata.UID)},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: claim.Spec.AccessModes,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): sourceVolume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)],
+				},
+			},
+			StorageClassName: &migration.Spec.StorageClassName,
+		},
+	}
+	err = ctrl.createClaim(targetClaim, volumeMode)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("error creating target claim %q: %v", targetClaim.Name, err)
+	}
+
+	status := migration.Status
+	status.Phase = crdv1.VolumeMigrationProvisioning
+	status.Message = fmt.Sprintf("waiting for target claim %q to be bound", targetClaim.Name)
+	status.SourceClaimUID = claim.UID
+	status.SourceVolumeName = sourceVolume.Name
+	status.VolumeMode = volumeMode
+	status.TargetClaimName = targetClaim.Name
+	ctrl.eventRecorder.Event(migration, v1.EventTypeNormal, migrationProvisioning, fmt.Sprintf("Provisioning claim %q of StorageClass %q", targetClaim.Name, migration.Spec.StorageClassName))
+	_, err = ctrl.updateStatus(migration, status)
+	return err
+}
+
+// syncProvisioning starts the copy job once the target claim is bound
+func (ctrl *MigrationController) syncProvisioning(migration *crdv1.VolumeMigration) error {
+	targetClaim, err := ctrl.client.Core().PersistentVolumeClaims(migration.Metadata.Namespace).Get(migration.Status.TargetClaimName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ctrl.failMigration(migration, fmt.Sprintf("target claim %q was deleted", migration.Status.TargetClaimName))
+	} else if err != nil {
+		return fmt.Errorf("error getting target claim %q: %v", migration.Status.TargetClaimName, err)
+	}
+	if targetClaim.Status.Phase != v1.ClaimBound {
+		return nil
+	}
+
+	pods, err := ctrl.podsUsingClaim(migration.Metadata.Namespace, migration.Spec.PersistentVolumeClaimName)
+	if err != nil {
+		return err
+	}
+	if len(pods) > 0 {
+		return ctrl.setMessage(migration, fmt.Sprintf("waiting for pods %v to stop using claim %q", pods, migration.Spec.PersistentVolumeClaimName))
+	}
+
+	job := ctrl.newCopyJob(migration)
+	err = ctrl.createJob(job, migration.Status.VolumeMode)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("error creating copy job %q: %v", job.Name, err)
+	}
+
+	status := migration.Status
+	status.Phase = crdv1.VolumeMigrationCopying
+	status.Message = fmt.Sprintf("copying data to volume %q", targetClaim.Spec.VolumeName)
+	status.TargetVolumeName = targetClaim.Spec.VolumeName
+	ctrl.eventRecorder.Event(migration, v1.EventTypeNormal, migrationCopying, fmt.Sprintf("Copying data from volume %q to volume %q", migration.Status.SourceVolumeName, targetClaim.Spec.VolumeName))
+	_, err = ctrl.updateStatus(migration, status)
+	return err
+}
+
+// syncCopying verifies the checksums reported by the copy job once it has
+// finished, and that no pod started using the source claim meanwhile
+func (ctrl *MigrationController) syncCopying(migration *crdv1.VolumeMigration) error {
+	jobName := targetNamePrefix + string(migration.Metadata.UID)
+	job, err := ctrl.client.BatchV1().Jobs(migration.Metadata.Namespace).Get(jobName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ctrl.failMigration(migration, fmt.Sprintf("copy job %q was deleted", jobName))
+	} else if err != nil {
+		return fmt.Errorf("error getting copy job %q: %v", jobName, err)
+	}
+
+	succeeded, failed := jobFinished(job)
+	if !succeeded && !failed {
+		return nil
+	}
+	message, err := ctrl.getCopyMessage(migration, failed)
+	if err != nil {
+		return err
+	}
+	if failed {
+		return ctrl.failMigration(migration, fmt.Sprintf("copy job %q failed: %s", jobName, message))
+	}
+	// A pod that started using the claim during the copy may have changed the
+	// source data after it was copied, even if the checksums match
+	pods, err := ctrl.podsUsingClaim(migration.Metadata.Namespace, migration.Spec.PersistentVolumeClaimName)
+	if err != nil {
+		return err
+	}
+	if len(pods) > 0 {
+		return ctrl.failMigration(migration, fmt.Sprintf("pods %v started using claim %q during the copy", pods, migration.Spec.PersistentVolumeClaimName))
+	}
+	sourceChecksum, targetChecksum, err := parseChecksums(message)
+	if err != nil {
+		return ctrl.failMigration(migration, fmt.Sprintf("copy job %q reported no checksums: %v", jobName, err))
+	}
+	if sourceChecksum != targetChecksum {
+		return ctrl.failMigration(migration, fmt.Sprintf("checksum of copied data %s does not match checksum of source data %s", targetChecksum, sourceChecksum))
+	}
+
+	status := migration.Status
+	status.Phase = crdv1.VolumeMigrationSwapping
+	status.Message = fmt.Sprintf("binding claim %q to volume %q", migration.Spec.PersistentVolumeClaimName, migration.Status.TargetVolumeName)
+	status.SourceChecksum = sourceChecksum
+	status.TargetChecksum = targetChecksum
+	ctrl.eventRecorder.Event(migration, v1.EventTypeNormal, migrationSwapping, fmt.Sprintf("Data copied & verified, checksum %s", targetChecksum))
+	_, err = ctrl.updateStatus(migration, status)
+	return err
+}
+
+// failMigration deletes the target claim & copy job, leaving the source claim
+// untouched, and marks the migration failed so it is not retried
+func (ctrl *MigrationController) failMigration(migration *crdv1.VolumeMigration, message string) error {
+	glog.Errorf("Migration %s failed: %s", migrationKey(migration), message)
+	ctrl.eventRecorder.Event(migration, v1.EventTypeWarning, migrationFailed, message)
+	if err := ctrl.cleanupTarget(migration); err != nil {
+		return err
+	}
+	status := migration.Status
+	status.Phase = crdv1.VolumeMigrationFailed
+	status.Message = message
+	_, err := ctrl.updateStatus(migration, status)
+	return err
+}
+
+// cleanupTarget deletes the migration's copy job and target claim, and so
+// the target volume if its reclaim policy is Delete
+func (ctrl *MigrationController) cleanupTarget(migration *crdv1.VolumeMigration) error {
+	if err := ctrl.deleteJob(migration); err != nil {
+		return err
+	}
+	if migration.Status.TargetClaimName == "" {
+		return nil
+	}
+	err := ctrl.client.Core().PersistentVolumeClaims(migration.Metadata.Namespace).Delete(migration.Status.TargetClaimName, nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting target claim %q: %v", migration.Status.TargetClaimName, err)
+	}
+	return nil
+}
+
+// setPending records why the migration can't start yet
+func (ctrl *MigrationController) setPending(migration *crdv1.VolumeMigration, message string) error {
+	if migration.Status.Phase == crdv1.VolumeMigrationPending && migration.Status.Message == message {
+		return nil
+	}
+	glog.Infof("Migration %s pending: %s", migrationKey(migration), message)
+	status := migration.Status
+	status.Phase = crdv1.VolumeMigrationPending
+	status.Message = message
+	_, err := ctrl.updateStatus(migration, status)
+	return err
+}
+
+// setMessage records why the migration can't advance from its phase yet
+func (ctrl *MigrationController) setMessage(migration *crdv1.VolumeMigration, message string) error {
+	if migration.Status.Message == message {
+		return nil
+	}
+	glog.Infof("Migration %s %s: %s", migrationKey(migration), migration.Status.Phase, message)
+	status := migration.Status
+	status.Message = message
+	_, err := ctrl.updateStatus(migration, status)
+	return err
+}
+
+// updateStatus saves the migration with the given status and returns the
+// result
+func (ctrl *MigrationController) updateStatus(migration *crdv1.VolumeMigration, status crdv1.VolumeMigrationStatus) (*crdv1.VolumeMigration, error) {
+	if status.Phase != migration.Status.Phase {
+		status.LastTransitionTime = metav1.Now()
+	}
+	migrationCopy := *migration
+	migrationCopy.Status = status
+	result := &crdv1.VolumeMigration{}
+	err := ctrl.migrationClient.Put().Namespace(migration.Metadata.Namespace).Resource(crdv1.VolumeMigrationResourcePlural).Name(migration.Metadata.Name).Body(&migrationCopy).Do().Into(result)
+	if err != nil {
+		return nil, fmt.Errorf("error updating migration %s: %v", migrationKey(migration), err)
+	}
+	return result, nil
+}
+
+// podsUsingClaim returns the names of the pods, other than copy job pods, that
+// use the claim and haven't terminated
+func (ctrl *MigrationController) podsUsingClaim(namespace, claimName string) ([]string, error) {
+	pods, err := ctrl.client.Core().Pods(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing pods: %v", err)
+	}
+	names := []string{}
+	for _, pod := range pods.Items {
+		if _, ok := pod.Labels[labelMigrationUID]; ok {
+			continue
+		}
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claimName {
+				names = append(names, pod.Name)
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+func migrationKey(migration *crdv1.VolumeMigration) string {
+	return migration.Metadata.Namespace + "/" + migration.Metadata.Name
+}
@@ -0,0 +1,534 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	crdv1 "github.com/kubernetes-incubator/external-storage/migration/pkg/apis/crd/v1"
+	migrationclient "github.com/kubernetes-incubator/external-storage/migration/pkg/client"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+const migrationPath = "/apis/" + crdv1.GroupName + "/v1/namespaces/default/volumemigrations/migration-1"
+
+func TestStartMigration(t *testing.T) {
+	tests := []struct {
+		name              string
+		objs              []runtime.Object
+		expectedPhase     crdv1.VolumeMigrationPhase
+		expectTargetClaim bool
+	}{
+		{
+			name: "start migration",
+			objs: []runtime.Object{
+				newClaim("claim-1", "uid-1", "class-1", "pv-1", v1.ClaimBound),
+				newVolume("pv-1", v1.PersistentVolumeReclaimDelete, "claim-1"),
+				newStorageClass("class-2"),
+			},
+			expectedPhase:     crdv1.VolumeMigrationProvisioning,
+			expectTargetClaim: true,
+		},
+		{
+			name:          "claim not found",
+			objs:          []runtime.Object{newStorageClass("class-2")},
+			expectedPhase: crdv1.VolumeMigrationFailed,
+		},
+		{
+			name: "claim not bound",
+			objs: []runtime.Object{
+				newClaim("claim-1", "uid-1", "class-1", "", v1.ClaimPending),
+				newStorageClass("class-2"),
+			},
+			expectedPhase: crdv1.VolumeMigrationPending,
+		},
+		{
+			name: "claim already of class",
+			objs: []runtime.Object{
+				newClaim("claim-1", "uid-1", "class-2", "pv-1", v1.ClaimBound),
+				newVolume("pv-1", v1.PersistentVolumeReclaimDelete, "claim-1"),
+				newStorageClass("class-2"),
+			},
+			expectedPhase: crdv1.VolumeMigrationFailed,
+		},
+		{
+			name: "class not found",
+			objs: []runtime.Object{
+				newClaim("claim-1", "uid-1", "class-1", "pv-1", v1.ClaimBound),
+				newVolume("pv-1", v1.PersistentVolumeReclaimDelete, "claim-1"),
+			},
+			expectedPhase: crdv1.VolumeMigrationFailed,
+		},
+		{
+			name: "claim in use",
+			objs: []runtime.Object{
+				newClaim("claim-1", "uid-1", "class-1", "pv-1", v1.ClaimBound),
+				newVolume("pv-1", v1.PersistentVolumeReclaimDelete, "claim-1"),
+				newStorageClass("class-2"),
+				newPod("pod-1", "claim-1"),
+			},
+			expectedPhase: crdv1.VolumeMigrationPending,
+		},
+	}
+	for _, test := range tests {
+		migration := newMigration(crdv1.VolumeMigrationStatus{})
+		client, server, ctrl := newTestController(t, migration, test.objs)
+
+		err := ctrl.syncMigration(migration)
+		result := server.getMigration()
+		server.Close()
+
+		t.Logf("test case: %s", test.name)
+		if err != nil {
+			t.Errorf("expected no error but got: %v", err)
+		}
+		if result.Status.Phase != test.expectedPhase {
+			t.Errorf("expected phase %q but got %q: %s", test.expectedPhase, result.Status.Phase, result.Status.Message)
+		}
+		claim, err := client.Core().PersistentVolumeClaims("default").Get("migration-uid-m", metav1.GetOptions{})
+		if !test.expectTargetClaim {
+			if err == nil {
+				t.Errorf("expected no target claim but got %v", claim)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected target claim but got error: %v", err)
+			continue
+		}
+		if claim.Spec.StorageClassName == nil || *claim.Spec.StorageClassName != "class-2" {
+			t.Errorf("expected target claim of class-2 but got %v", claim.Spec.StorageClassName)
+		}
+		request := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+		if request.Cmp(resource.MustParse("2Gi")) != 0 {
+			t.Errorf("expected target claim to request the source volume's capacity 2Gi but got %s", request.String())
+		}
+		if result.Status.SourceClaimUID != "uid-1" || result.Status.SourceVolumeName != "pv-1" || result.Status.TargetClaimName != claim.Name {
+			t.Errorf("expected status to record source & target but got %+v", result.Status)
+		}
+	}
+}
+
+func TestSyncCopying(t *testing.T) {
+	tests := []struct {
+		name              string
+		jobStatus         batchv1.JobStatus
+		podMessage        string
+		podExitCode       int32
+		claimInUse        bool
+		expectedPhase     crdv1.VolumeMigrationPhase
+		expectTargetClaim bool
+	}{
+		{
+			name:              "copy running",
+			jobStatus:         batchv1.JobStatus{Active: 1},
+			expectedPhase:     crdv1.VolumeMigrationCopying,
+			expectTargetClaim: true,
+		},
+		{
+			name:              "copy verified",
+			jobStatus:         batchv1.JobStatus{Succeeded: 1},
+			podMessage:        "source=abc target=abc\n",
+			expectedPhase:     crdv1.VolumeMigrationSwapping,
+			expectTargetClaim: true,
+		},
+		{
+			name:          "claim used during copy",
+			jobStatus:     batchv1.JobStatus{Succeeded: 1},
+			podMessage:    "source=abc target=abc\n",
+			claimInUse:    true,
+			expectedPhase: crdv1.VolumeMigrationFailed,
+		},
+		{
+			name:          "checksum mismatch",
+			jobStatus:     batchv1.JobStatus{Succeeded: 1},
+			podMessage:    "source=abc target=def\n",
+			expectedPhase: crdv1.VolumeMigrationFailed,
+		},
+		{
+			name:          "no checksums",
+			jobStatus:     batchv1.JobStatus{Succeeded: 1},
+			expectedPhase: crdv1.VolumeMigrationFailed,
+		},
+		{
+			name:          "copy failed",
+			jobStatus:     batchv1.JobStatus{Failed: 1},
+			podExitCode:   1,
+			expectedPhase: crdv1.VolumeMigrationFailed,
+		},
+	}
+	for _, test := range tests {
+		migration := newMigration(crdv1.VolumeMigrationStatus{
+			Phase:            crdv1.VolumeMigrationCopying,
+			SourceClaimUID:   "uid-1",
+			SourceVolumeName: "pv-1",
+			VolumeMode:       volumeModeFilesystem,
+			TargetClaimName:  "migration-uid-m",
+			TargetVolumeName: "pv-2",
+		})
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "migration-uid-m", Namespace: "default"},
+			Status:     test.jobStatus,
+		}
+		pod := newPod("migration-uid-m-abcde", "claim-1")
+		pod.Labels = map[string]string{labelMigrationUID: "uid-m"}
+		if test.jobStatus.Active == 0 {
+			pod.Status.ContainerStatuses = []v1.ContainerStatus{
+				{State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: test.podExitCode, Message: test.podMessage}}},
+			}
+		}
+		objs := []runtime.Object{
+			newClaim("claim-1", "uid-1", "class-1", "pv-1", v1.ClaimBound),
+			newClaim("migration-uid-m", "uid-2", "class-2", "pv-2", v1.ClaimBound),
+			job,
+			pod,
+		}
+		if test.claimInUse {
+			objs = append(objs, newPod("pod-1", "claim-1"))
+		}
+		client, server, ctrl := newTestController(t, migration, objs)
+
+		err := ctrl.syncMigration(migration)
+		result := server.getMigration()
+		server.Close()
+
+		t.Logf("test case: %s", test.name)
+		if err != nil {
+			t.Errorf("expected no error but got: %v", err)
+		}
+		if result.Status.Phase != test.expectedPhase {
+			t.Errorf("expected phase %q but got %q: %s", test.expectedPhase, result.Status.Phase, result.Status.Message)
+		}
+		_, err = client.Core().PersistentVolumeClaims("default").Get("migration-uid-m", metav1.GetOptions{})
+		if exists := err == nil; exists != test.expectTargetClaim {
+			t.Errorf("expected target claim to exist %v but got %v", test.expectTargetClaim, exists)
+		}
+	}
+}
+
+func TestSwapClaim(t *testing.T) {
+	tests := []struct {
+		name          string
+		objs          []runtime.Object
+		expectedPhase crdv1.VolumeMigrationPhase
+		expectSwapped bool
+	}{
+		{
+			name: "swap claim",
+			objs: []runtime.Object{
+				newClaim("claim-1", "uid-1", "class-1", "pv-1", v1.ClaimBound),
+				newVolume("pv-1", v1.PersistentVolumeReclaimDelete, "claim-1"),
+				newClaim("migration-uid-m", "uid-2", "class-2", "pv-2", v1.ClaimBound),
+				newVolume("pv-2", v1.PersistentVolumeReclaimDelete, "migration-uid-m"),
+			},
+			expectedPhase: crdv1.VolumeMigrationSucceeded,
+			expectSwapped: true,
+		},
+		{
+			name: "resume after claim recreated",
+			objs: []runtime.Object{
+				newClaim("claim-1", "uid-3", "class-2", "pv-2", v1.ClaimPending),
+				newVolume("pv-1", v1.PersistentVolumeReclaimRetain, "claim-1"),
+				newVolume("pv-2", v1.PersistentVolumeReclaimDelete, "claim-1"),
+			},
+			expectedPhase: crdv1.VolumeMigrationSucceeded,
+			expectSwapped: true,
+		},
+		{
+			name: "claim in use",
+			objs: []runtime.Object{
+				newClaim("claim-1", "uid-1", "class-1", "pv-1", v1.ClaimBound),
+				newVolume("pv-1", v1.PersistentVolumeReclaimDelete, "claim-1"),
+				newClaim("migration-uid-m", "uid-2", "class-2", "pv-2", v1.ClaimBound),
+				newVolume("pv-2", v1.PersistentVolumeReclaimDelete, "migration-uid-m"),
+				newPod("pod-1", "claim-1"),
+			},
+			expectedPhase: crdv1.VolumeMigrationSwapping,
+		},
+	}
+	for _, test := range tests {
+		migration := newMigration(crdv1.VolumeMigrationStatus{
+			Phase:            crdv1.VolumeMigrationSwapping,
+			SourceClaimUID:   "uid-1",
+			SourceVolumeName: "pv-1",
+			VolumeMode:       volumeModeFilesystem,
+			TargetClaimName:  "migration-uid-m",
+			TargetVolumeName: "pv-2",
+		})
+		client, server, ctrl := newTestController(t, migration, test.objs)
+
+		err := ctrl.syncMigration(migration)
+		result := server.getMigration()
+		server.Close()
+
+		t.Logf("test case: %s", test.name)
+		if err != nil {
+			t.Errorf("expected no error but got: %v", err)
+		}
+		if result.Status.Phase != test.expectedPhase {
+			t.Errorf("expected phase %q but got %q: %s", test.expectedPhase, result.Status.Phase, result.Status.Message)
+		}
+		if !test.expectSwapped {
+			continue
+		}
+		claim, err := client.Core().PersistentVolumeClaims("default").Get("claim-1", metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("expected claim to be recreated but got error: %v", err)
+		} else if claim.Spec.VolumeName != "pv-2" {
+			t.Errorf("expected claim bound to pv-2 but got %q", claim.Spec.VolumeName)
+		}
+		if _, err = client.Core().PersistentVolumeClaims("default").Get("migration-uid-m", metav1.GetOptions{}); err == nil {
+			t.Errorf("expected target claim to be deleted")
+		}
+		source, _ := client.Core().PersistentVolumes().Get("pv-1", metav1.GetOptions{})
+		if source.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
+			t.Errorf("expected source volume to be retained but got %q", source.Spec.PersistentVolumeReclaimPolicy)
+		}
+		target, _ := client.Core().PersistentVolumes().Get("pv-2", metav1.GetOptions{})
+		if target.Spec.ClaimRef == nil || target.Spec.ClaimRef.Name != "claim-1" {
+			t.Errorf("expected target volume bound to claim-1 but got %v", target.Spec.ClaimRef)
+		}
+		if target.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete {
+			t.Errorf("expected target volume reclaim policy restored to Delete but got %q", target.Spec.PersistentVolumeReclaimPolicy)
+		}
+	}
+}
+
+func TestUpdateVolumeKeepsVolumeMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(ctrl *MigrationController, volume *v1.PersistentVolume) error
+	}{
+		{
+			name: "retain volume",
+			update: func(ctrl *MigrationController, volume *v1.PersistentVolume) error {
+				_, err := ctrl.retainVolume(volume, true)
+				return err
+			},
+		},
+		{
+			name: "restore reclaim policy",
+			update: func(ctrl *MigrationController, volume *v1.PersistentVolume) error {
+				volume.Annotations = map[string]string{annReclaimPolicy: string(v1.PersistentVolumeReclaimRecycle)}
+				return ctrl.restoreReclaimPolicy(volume)
+			},
+		},
+	}
+	for _, test := range tests {
+		volume := newVolume("pv-2", v1.PersistentVolumeReclaimDelete, "claim-1")
+		volume.ResourceVersion = "1"
+		writes, lost := 0, 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/persistentvolumes/pv-2" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			// An update must carry the Block mode and a patch must leave it
+			// be: the API server rejects changing it
+			body, _ := ioutil.ReadAll(r.Body)
+			written := &volumeModeSpec{}
+			json.Unmarshal(body, written)
+			writes++
+			if (r.Method == "PUT" && written.Spec.VolumeMode != volumeModeBlock) ||
+				(r.Method == "PATCH" && written.Spec.VolumeMode != "" && written.Spec.VolumeMode != volumeModeBlock) {
+				lost++
+			}
+			body, _ = json.Marshal(volume)
+			object := map[string]interface{}{}
+			json.Unmarshal(body, &object)
+			object["spec"].(map[string]interface{})["volumeMode"] = volumeModeBlock
+			body, _ = json.Marshal(object)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+		}))
+		client := kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+		ctrl := NewMigrationController(client, nil, nil, "v1.9.0", DefaultCopyImage, DefaultCopyTimeout, DefaultResyncPeriod)
+
+		err := test.update(ctrl, volume)
+		server.Close()
+
+		t.Logf("test case: %s", test.name)
+		if err != nil {
+			t.Errorf("expected no error but got: %v", err)
+		}
+		if writes == 0 {
+			t.Errorf("expected the volume to be written")
+		}
+		if lost > 0 {
+			t.Errorf("expected writes to keep volume mode %q", volumeModeBlock)
+		}
+	}
+}
+
+func newTestController(t *testing.T, migration *crdv1.VolumeMigration, objs []runtime.Object) (*fake.Clientset, *fakeServer, *MigrationController) {
+	server := newFakeServer(migration)
+	migrationClient, scheme, err := migrationclient.NewClient(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client := fake.NewSimpleClientset(objs...)
+	ctrl := NewMigrationController(client, migrationClient, scheme, "v1.8.0", DefaultCopyImage, DefaultCopyTimeout, DefaultResyncPeriod)
+	return client, server, ctrl
+}
+
+func newMigration(status crdv1.VolumeMigrationStatus) *crdv1.VolumeMigration {
+	return &crdv1.VolumeMigration{
+		TypeMeta: metav1.TypeMeta{Kind: "VolumeMigration", APIVersion: crdv1.SchemeGroupVersion.String()},
+		Metadata: metav1.ObjectMeta{
+			Name:      "migration-1",
+			Namespace: "default",
+			UID:       "uid-m",
+		},
+		Spec: crdv1.VolumeMigrationSpec{
+			PersistentVolumeClaimName: "claim-1",
+			StorageClassName:          "class-2",
+		},
+		Status: status,
+	}
+}
+
+func newClaim(name, uid, class, volumeName string, phase v1.PersistentVolumeClaimPhase) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(uid),
+			Labels:    map[string]string{"app": "test"},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi"),
+				},
+			},
+			StorageClassName: &class,
+			VolumeName:       volumeName,
+		},
+		Status: v1.PersistentVolumeClaimStatus{
+			Phase: phase,
+		},
+	}
+}
+
+func newVolume(name string, policy v1.PersistentVolumeReclaimPolicy, claimName string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: policy,
+			AccessModes:                   []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): resource.MustParse("2Gi"),
+			},
+			ClaimRef: &v1.ObjectReference{
+				Kind:      "PersistentVolumeClaim",
+				Namespace: "default",
+				Name:      claimName,
+			},
+		},
+	}
+}
+
+func newStorageClass(name string) *storage.StorageClass {
+	return &storage.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Provisioner: "foo.bar/baz",
+	}
+}
+
+func newPod(name, claimName string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{
+				{
+					Name: "data",
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+					},
+				},
+			},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+		},
+	}
+}
+
+// fakeServer is an API server that serves a single VolumeMigration
+type fakeServer struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	migration []byte
+}
+
+func newFakeServer(migration *crdv1.VolumeMigration) *fakeServer {
+	s := &fakeServer{}
+	s.migration, _ = json.Marshal(migration)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *fakeServer) getMigration() *crdv1.VolumeMigration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	migration := &crdv1.VolumeMigration{}
+	json.Unmarshal(s.migration, migration)
+	return migration
+}
+
+func (s *fakeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path != migrationPath {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+		return
+	}
+	switch r.Method {
+	case "GET":
+		w.Write(s.migration)
+	case "PUT":
+		s.migration, _ = ioutil.ReadAll(r.Body)
+		w.Write(s.migration)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
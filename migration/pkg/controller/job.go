@@ -0,0 +1,359 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	crdv1 "github.com/kubernetes-incubator/external-storage/migration/pkg/apis/crd/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	utilversion "k8s.io/kubernetes/pkg/util/version"
+)
+
+// Volume modes of claims & volumes, which the vendored v1 types do not have
+// yet
+const (
+	volumeModeFilesystem = "Filesystem"
+	volumeModeBlock      = "Block"
+)
+
+// copyFilesystemScript copies the source volume's files to the target volume
+// and writes the checksums of both to the termination message
+const copyFilesystemScript = `set -e
+rsync -aH --numeric-ids --delete /mnt/source/ /mnt/target/
+checksum() (
+	cd "$1"
+	find . -type f -print0 | sort -z | xargs -0 -r sha256sum | sha256sum | cut -d' ' -f1
+)
+source=$(checksum /mnt/source)
+target=$(checksum /mnt/target)
+echo "source=$source target=$target" > /dev/termination-log
+`
+
+// copyBlockScript copies the source device to the target device, which may be
+// bigger, and writes the checksums of the source device and of as many bytes
+// of the target device to the termination message
+const copyBlockScript = `set -e
+size=$(blockdev --getsize64 /dev/source)
+dd if=/dev/source of=/dev/target bs=4M conv=fsync
+source=$(head -c "$size" /dev/source | sha256sum | cut -d' ' -f1)
+target=$(head -c "$size" /dev/target | sha256sum | cut -d' ' -f1)
+echo "source=$source target=$target" > /dev/termination-log
+`
+
+// volumeModeSpec holds the volumeMode field of claims
+type volumeModeSpec struct {
+	Spec struct {
+		VolumeMode string `json:"volumeMode,omitempty"`
+	} `json:"spec"`
+}
+
+// hasVolumeMode returns whether claims & volumes have a volume mode, i.e. the
+// cluster is Kubernetes 1.9+
+func (ctrl *MigrationController) hasVolumeMode() bool {
+	return ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.9.0"))
+}
+
+// getClaimVolumeMode returns the volume mode of the claim. Before Kubernetes
+// 1.9 claims have none so Filesystem is returned.
+func (ctrl *MigrationController) getClaimVolumeMode(claim *v1.PersistentVolumeClaim) (string, error) {
+	if !ctrl.hasVolumeMode() {
+		return volumeModeFilesystem, nil
+	}
+
+	// The typed claim has dropped the field, so get the raw object
+	raw, err := ctrl.client.Core().RESTClient().Get().Namespace(claim.Namespace).Resource("persistentvolumeclaims").Name(claim.Name).DoRaw()
+	if err != nil {
+		return "", err
+	}
+	spec := &volumeModeSpec{}
+	if err = json.Unmarshal(raw, spec); err != nil {
+		return "", err
+	}
+	if spec.Spec.VolumeMode == "" {
+		return volumeModeFilesystem, nil
+	}
+	return spec.Spec.VolumeMode, nil
+}
+
+// copyVolume returns a deep copy of the volume, for updateVolume to diff
+// against
+func copyVolume(volume *v1.PersistentVolume) (*v1.PersistentVolume, error) {
+	clone, err := scheme.Scheme.DeepCopy(volume)
+	if err != nil {
+		return nil, fmt.Errorf("error copying volume %q: %v", volume.Name, err)
+	}
+	return clone.(*v1.PersistentVolume), nil
+}
+
+// updateVolume saves the changes made to modified, a modified copy of
+// original. Updating the volume with the typed object would reset a volume
+// mode the typed object has dropped, which the API server rejects, so if
+// volumes have the field only the changes are sent, as a strategic merge
+// patch. The patch carries the resource version so that, like an update, it
+// fails if the volume has changed.
+func (ctrl *MigrationController) updateVolume(original, modified *v1.PersistentVolume) (*v1.PersistentVolume, error) {
+	if !ctrl.hasVolumeMode() {
+		return ctrl.client.Core().PersistentVolumes().Update(modified)
+	}
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return nil, err
+	}
+	modifiedJSON, err := json.Marshal(modified)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := strategicpatch.CreateTwoWayMergePatch(originalJSON, modifiedJSON, v1.PersistentVolume{})
+	if err != nil {
+		return nil, err
+	}
+	object := map[string]interface{}{}
+	if err = json.Unmarshal(patch, &object); err != nil {
+		return nil, err
+	}
+	metadata, ok := object["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		object["metadata"] = metadata
+	}
+	metadata["resourceVersion"] = modified.ResourceVersion
+	if patch, err = json.Marshal(object); err != nil {
+		return nil, err
+	}
+	return ctrl.client.Core().PersistentVolumes().Patch(modified.Name, types.StrategicMergePatchType, patch)
+}
+
+// createClaim creates the claim with the given volume mode. Block claims are
+// created from the raw object because the typed one would drop the mode.
+func (ctrl *MigrationController) createClaim(claim *v1.PersistentVolumeClaim, volumeMode string) error {
+	if volumeMode != volumeModeBlock {
+		_, err := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Create(claim)
+		return err
+	}
+	return createRaw(ctrl.client.Core().RESTClient(), claim.Namespace, "persistentvolumeclaims", "v1", "PersistentVolumeClaim", claim, func(object map[string]interface{}) error {
+		spec, ok := object["spec"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("claim %q has no spec", claim.Name)
+		}
+		spec["volumeMode"] = volumeModeBlock
+		return nil
+	})
+}
+
+// newCopyJob returns the job that copies the migration's source claim to its
+// target claim
+func (ctrl *MigrationController) newCopyJob(migration *crdv1.VolumeMigration) *batchv1.Job {
+	labels := map[string]string{labelMigrationUID: string(migration.Metadata.UID)}
+	deadline := int64(ctrl.copyTimeout / time.Second)
+
+	container := v1.Container{
+		Name:    "copy",
+		Image:   ctrl.copyImage,
+		Command: []string{"/bin/sh", "-c", copyFilesystemScript},
+	}
+	if migration.Status.VolumeMode == volumeModeBlock {
+		// createJob adds the devices
+		container.Command = []string{"/bin/sh", "-c", copyBlockScript}
+	} else {
+		container.VolumeMounts = []v1.VolumeMount{
+			{Name: "source", MountPath: "/mnt/source", ReadOnly: true},
+			{Name: "target", MountPath: "/mnt/target"},
+		}
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      targetNamePrefix + string(migration.Metadata.UID),
+			Namespace: migration.Metadata.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			ActiveDeadlineSeconds: &deadline,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Containers:    []v1.Container{container},
+					Volumes: []v1.Volume{
+						{
+							Name: "source",
+							VolumeSource: v1.VolumeSource{
+								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+									ClaimName: migration.Spec.PersistentVolumeClaimName,
+									ReadOnly:  true,
+								},
+							},
+						},
+						{
+							Name: "target",
+							VolumeSource: v1.VolumeSource{
+								PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+									ClaimName: migration.Status.TargetClaimName,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createJob creates the copy job. Jobs copying block volumes are created from
+// the raw object because the typed container has no volumeDevices.
+func (ctrl *MigrationController) createJob(job *batchv1.Job, volumeMode string) error {
+	if volumeMode != volumeModeBlock {
+		_, err := ctrl.client.BatchV1().Jobs(job.Namespace).Create(job)
+		return err
+	}
+	return createRaw(ctrl.client.BatchV1().RESTClient(), job.Namespace, "jobs", "batch/v1", "Job", job, func(object map[string]interface{}) error {
+		spec, _ := object["spec"].(map[string]interface{})
+		template, _ := spec["template"].(map[string]interface{})
+		podSpec, _ := template["spec"].(map[string]interface{})
+		containers, _ := podSpec["containers"].([]interface{})
+		if len(containers) != 1 {
+			return fmt.Errorf("job %q has no container", job.Name)
+		}
+		container := containers[0].(map[string]interface{})
+		container["volumeDevices"] = []map[string]string{
+			{"name": "source", "devicePath": "/dev/source"},
+			{"name": "target", "devicePath": "/dev/target"},
+		}
+		return nil
+	})
+}
+
+// deleteJob deletes the migration's copy job and its pods
+func (ctrl *MigrationController) deleteJob(migration *crdv1.VolumeMigration) error {
+	jobName := targetNamePrefix + string(migration.Metadata.UID)
+	propagation := metav1.DeletePropagationBackground
+	err := ctrl.client.BatchV1().Jobs(migration.Metadata.Namespace).Delete(jobName, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting copy job %q: %v", jobName, err)
+	}
+	return nil
+}
+
+// jobFinished returns whether the job has succeeded or failed. A job whose
+// pod failed is failed even though the job would retry it: a copy that failed
+// once is not trusted.
+func jobFinished(job *batchv1.Job) (succeeded bool, failed bool) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != v1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			succeeded = true
+		case batchv1.JobFailed:
+			failed = true
+		}
+	}
+	if job.Status.Failed > 0 {
+		failed = true
+	}
+	if job.Status.Succeeded > 0 && !failed {
+		succeeded = true
+	}
+	return succeeded, failed
+}
+
+// getCopyMessage returns the termination message of the copy job's pod that
+// succeeded, or of one that failed
+func (ctrl *MigrationController) getCopyMessage(migration *crdv1.VolumeMigration, failed bool) (string, error) {
+	selector := labelMigrationUID + "=" + string(migration.Metadata.UID)
+	pods, err := ctrl.client.Core().Pods(migration.Metadata.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return "", fmt.Errorf("error listing copy job pods: %v", err)
+	}
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			terminated := status.State.Terminated
+			if terminated == nil {
+				continue
+			}
+			if !failed && terminated.ExitCode == 0 {
+				return strings.TrimSpace(terminated.Message), nil
+			}
+			if failed && terminated.ExitCode != 0 {
+				return fmt.Sprintf("exit code %d: %s", terminated.ExitCode, strings.TrimSpace(terminated.Reason+" "+terminated.Message)), nil
+			}
+		}
+	}
+	if failed {
+		return "no failed pod found", nil
+	}
+	return "", nil
+}
+
+// parseChecksums parses the "source=<checksum> target=<checksum>" message of
+// the copy job
+func parseChecksums(message string) (string, string, error) {
+	var source, target string
+	for _, field := range strings.Fields(message) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "source":
+			source = parts[1]
+		case "target":
+			target = parts[1]
+		}
+	}
+	if source == "" || target == "" {
+		return "", "", fmt.Errorf("message %q has no source & target checksums", message)
+	}
+	return source, target, nil
+}
+
+// createRaw creates the object after mutating its raw form to set fields the
+// vendored types don't have
+func createRaw(client rest.Interface, namespace, resource, apiVersion, kind string, obj interface{}, mutate func(map[string]interface{}) error) error {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	object := map[string]interface{}{}
+	if err = json.Unmarshal(body, &object); err != nil {
+		return err
+	}
+	object["apiVersion"] = apiVersion
+	object["kind"] = kind
+	if err = mutate(object); err != nil {
+		return err
+	}
+	if body, err = json.Marshal(object); err != nil {
+		return err
+	}
+	_, err = client.Post().Namespace(namespace).Resource(resource).Body(body).DoRaw()
+	return err
+}
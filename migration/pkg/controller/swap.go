@@ -0,0 +1,255 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	crdv1 "github.com/kubernetes-incubator/external-storage/migration/pkg/apis/crd/v1"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// annReclaimPolicy records on the target volume its reclaim policy while
+	// the swap retains it
+	annReclaimPolicy = "volumemigration.external-storage.k8s.io/reclaim-policy"
+
+	// annMigratedFrom records on the recreated claim the volume it was
+	// migrated from
+	annMigratedFrom = "volumemigration.external-storage.k8s.io/migrated-from"
+
+	annBoundByController = "pv.kubernetes.io/bound-by-controller"
+)
+
+// claimBindingAnnotations are set on claims by the PV controller & provisioners
+// and are not copied to the recreated claim
+var claimBindingAnnotations = []string{
+	"pv.kubernetes.io/bind-completed",
+	annBoundByController,
+	"volume.beta.kubernetes.io/storage-provisioner",
+	"volume.beta.kubernetes.io/storage-class",
+	"volume.kubernetes.io/selected-node",
+}
+
+// swapClaim recreates the source claim bound to the target volume. Claims
+// can't be rebound, so the source & target claims are deleted with their
+// volumes retained and the target volume is pre-bound to a new claim with the
+// source claim's name. Every step checks whether it has been done already, so
+// the swap is resumed if interrupted.
+func (ctrl *MigrationController) swapClaim(migration *crdv1.VolumeMigration) error {
+	namespace := migration.Metadata.Namespace
+	claimName := migration.Spec.PersistentVolumeClaimName
+
+	pods, err := ctrl.podsUsingClaim(namespace, claimName)
+	if err != nil {
+		return err
+	}
+	if len(pods) > 0 {
+		return ctrl.setMessage(migration, fmt.Sprintf("waiting for pods %v to stop using claim %q", pods, claimName))
+	}
+
+	// Retain both volumes so that deleting their claims doesn't delete them.
+	// The source volume stays retained for rollback.
+	sourceVolume, err := ctrl.client.Core().PersistentVolumes().Get(migration.Status.SourceVolumeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting source volume %q: %v", migration.Status.SourceVolumeName, err)
+	}
+	if _, err = ctrl.retainVolume(sourceVolume, false); err != nil {
+		return err
+	}
+	targetVolume, err := ctrl.client.Core().PersistentVolumes().Get(migration.Status.TargetVolumeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting target volume %q: %v", migration.Status.TargetVolumeName, err)
+	}
+	if targetVolume, err = ctrl.retainVolume(targetVolume, true); err != nil {
+		return err
+	}
+
+	err = ctrl.client.Core().PersistentVolumeClaims(namespace).Delete(migration.Status.TargetClaimName, nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting target claim %q: %v", migration.Status.TargetClaimName, err)
+	}
+
+	recreated, migration, err := ctrl.deleteSourceClaim(migration)
+	if err != nil || migration == nil {
+		return err
+	}
+
+	if ref := targetVolume.Spec.ClaimRef; ref == nil || ref.Namespace != namespace || ref.Name != claimName {
+		boundVolume, err := copyVolume(targetVolume)
+		if err != nil {
+			return err
+		}
+		boundVolume.Spec.ClaimRef = &v1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Namespace:  namespace,
+			Name:       claimName,
+		}
+		delete(boundVolume.Annotations, annBoundByController)
+		if targetVolume, err = ctrl.updateVolume(targetVolume, boundVolume); err != nil {
+			return fmt.Errorf("error binding target volume %q to claim %q: %v", boundVolume.Name, claimName, err)
+		}
+	}
+
+	if !recreated {
+		claim := ctrl.newSwappedClaim(migration, targetVolume)
+		err = ctrl.createClaim(claim, migration.Status.VolumeMode)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("error recreating claim %q: %v", claimName, err)
+		}
+	}
+
+	if err = ctrl.restoreReclaimPolicy(targetVolume); err != nil {
+		return err
+	}
+	if err = ctrl.deleteJob(migration); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("claim %q bound to volume %q, volume %q retained", claimName, targetVolume.Name, sourceVolume.Name)
+	glog.Infof("Migration %s succeeded: %s", migrationKey(migration), message)
+	ctrl.eventRecorder.Event(migration, v1.EventTypeNormal, migrationSucceeded, message)
+	status := migration.Status
+	status.Phase = crdv1.VolumeMigrationSucceeded
+	status.Message = message
+	_, err = ctrl.updateStatus(migration, status)
+	return err
+}
+
+// deleteSourceClaim saves a copy of the source claim in the migration's status
+// and deletes the claim. It returns whether the claim has been recreated
+// already and the updated migration, nil if the claim is still being deleted.
+func (ctrl *MigrationController) deleteSourceClaim(migration *crdv1.VolumeMigration) (bool, *crdv1.VolumeMigration, error) {
+	namespace := migration.Metadata.Namespace
+	claimName := migration.Spec.PersistentVolumeClaimName
+	claims := ctrl.client.Core().PersistentVolumeClaims(namespace)
+
+	claim, err := claims.Get(claimName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, migration, nil
+	} else if err != nil {
+		return false, nil, fmt.Errorf("error getting claim %q: %v", claimName, err)
+	}
+	if claim.UID != migration.Status.SourceClaimUID {
+		return true, migration, nil
+	}
+
+	if migration.Status.SourceClaim == nil {
+		status := migration.Status
+		status.SourceClaim = claim
+		if migration, err = ctrl.updateStatus(migration, status); err != nil {
+			return false, nil, err
+		}
+	}
+	if claim.DeletionTimestamp == nil {
+		err = claims.Delete(claimName, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &claim.UID}})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, nil, fmt.Errorf("error deleting claim %q: %v", claimName, err)
+		}
+	}
+
+	// The claim may not be deleted at once, e.g. if it is protected
+	_, err = claims.Get(claimName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, migration, nil
+	} else if err != nil {
+		return false, nil, fmt.Errorf("error getting claim %q: %v", claimName, err)
+	}
+	return false, nil, ctrl.setMessage(migration, fmt.Sprintf("waiting for claim %q to be deleted", claimName))
+}
+
+// newSwappedClaim returns the claim to recreate, a copy of the source claim of
+// the target StorageClass bound to the target volume
+func (ctrl *MigrationController) newSwappedClaim(migration *crdv1.VolumeMigration, targetVolume *v1.PersistentVolume) *v1.PersistentVolumeClaim {
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        migration.Spec.PersistentVolumeClaimName,
+			Namespace:   migration.Metadata.Namespace,
+			Annotations: map[string]string{},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: targetVolume.Spec.AccessModes,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): targetVolume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)],
+				},
+			},
+			StorageClassName: &migration.Spec.StorageClassName,
+			VolumeName:       targetVolume.Name,
+		},
+	}
+	// The source claim was deleted before it could be saved if it is missing
+	if source := migration.Status.SourceClaim; source != nil {
+		claim.Labels = source.Labels
+		for k, v := range source.Annotations {
+			claim.Annotations[k] = v
+		}
+		for _, ann := range claimBindingAnnotations {
+			delete(claim.Annotations, ann)
+		}
+		claim.Spec.AccessModes = source.Spec.AccessModes
+		claim.Spec.Resources = source.Spec.Resources
+	}
+	claim.Annotations[annMigratedFrom] = migration.Status.SourceVolumeName
+	return claim
+}
+
+// retainVolume sets the volume's reclaim policy to Retain, recording the
+// policy it had if remember is true, and returns the updated volume
+func (ctrl *MigrationController) retainVolume(volume *v1.PersistentVolume, remember bool) (*v1.PersistentVolume, error) {
+	if volume.Spec.PersistentVolumeReclaimPolicy == v1.PersistentVolumeReclaimRetain {
+		return volume, nil
+	}
+	retainedVolume, err := copyVolume(volume)
+	if err != nil {
+		return nil, err
+	}
+	if remember {
+		if retainedVolume.Annotations == nil {
+			retainedVolume.Annotations = map[string]string{}
+		}
+		retainedVolume.Annotations[annReclaimPolicy] = string(volume.Spec.PersistentVolumeReclaimPolicy)
+	}
+	retainedVolume.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+	result, err := ctrl.updateVolume(volume, retainedVolume)
+	if err != nil {
+		return nil, fmt.Errorf("error retaining volume %q: %v", volume.Name, err)
+	}
+	return result, nil
+}
+
+// restoreReclaimPolicy restores the reclaim policy recorded by retainVolume
+func (ctrl *MigrationController) restoreReclaimPolicy(volume *v1.PersistentVolume) error {
+	policy, ok := volume.Annotations[annReclaimPolicy]
+	if !ok {
+		return nil
+	}
+	restoredVolume, err := copyVolume(volume)
+	if err != nil {
+		return err
+	}
+	restoredVolume.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimPolicy(policy)
+	delete(restoredVolume.Annotations, annReclaimPolicy)
+	if _, err = ctrl.updateVolume(volume, restoredVolume); err != nil {
+		return fmt.Errorf("error restoring reclaim policy of volume %q: %v", volume.Name, err)
+	}
+	return nil
+}
@@ -42,6 +42,8 @@ var (
 	gracePeriod    = flag.Uint("grace-period", 90, "NFS Ganesha grace period to use in seconds, from 0-180. If the server is not expected to survive restarts, i.e. it is running as a pod & its export directory is not persisted, this can be set to 0. Can only be set if both run-server and use-ganesha are true. Default 90.")
 	enableXfsQuota = flag.Bool("enable-xfs-quota", false, "If the provisioner will set xfs quotas for each volume it provisions. Requires that the directory it creates volumes in ('/export') is xfs mounted with option prjquota/pquota, and that it has the privilege to run xfs_quota. Default false.")
 	serverHostname = flag.String("server-hostname", "", "The hostname for the NFS server to export from. Only applicable when running out-of-cluster i.e. it can only be set if either master or kubeconfig are set. If unset, the first IP output by `hostname -i` is used.")
+	addFinalizer   = flag.Bool("add-finalizer", false, "If the provisioner will add a finalizer to the PVs it provisions, so that deleting a PV directly does not leak its export directory. PVs can then only be deleted while the provisioner is running. Default false.")
+	healthPort     = flag.Int("health-port", 0, "The port to serve liveness and readiness checks on, at /healthz and /readyz. The liveness check fails if NFS Ganesha can't be reached over D-Bus. If 0, they are not served. Default 0.")
 )
 
 const (
@@ -131,6 +133,8 @@ func main() {
 		*provisioner,
 		nfsProvisioner,
 		serverVersion.GitVersion,
+		controller.AddFinalizer(*addFinalizer),
+		controller.HealthPort(*healthPort),
 	)
 
 	pc.Run(wait.NeverStop)
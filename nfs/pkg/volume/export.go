@@ -119,6 +119,21 @@ func (e *ganeshaExporter) Export(path string) error {
 	return nil
 }
 
+// Ping checks that NFS Ganesha can be reached using D-Bus.
+func (e *ganeshaExporter) Ping() error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("error getting dbus session bus: %v", err)
+	}
+	obj := conn.Object("org.ganesha.nfsd", "/org/ganesha/nfsd/ExportMgr")
+	call := obj.Call("org.freedesktop.DBus.Peer.Ping", 0)
+	if call.Err != nil {
+		return fmt.Errorf("error calling org.freedesktop.DBus.Peer.Ping: %v", call.Err)
+	}
+
+	return nil
+}
+
 func (e *ganeshaExporter) Unexport(volume *v1.PersistentVolume) error {
 	ann, ok := volume.Annotations[annExportID]
 	if !ok {
@@ -172,6 +172,8 @@ type nfsProvisioner struct {
 }
 
 var _ controller.Provisioner = &nfsProvisioner{}
+var _ controller.HealthChecker = &nfsProvisioner{}
+var _ controller.Cloner = &nfsProvisioner{}
 
 // Provision creates a volume i.e. the storage asset and returns a PV object for
 // the volume.
@@ -220,6 +222,21 @@ func (p *nfsProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 	return pv, nil
 }
 
+// Clone creates a volume like Provision, with a copy of the contents of the
+// source volume's directory. The source volume must have been provisioned by
+// this provisioner, i.e. its directory must be in this provisioner's exportDir.
+func (p *nfsProvisioner) Clone(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	provisioned, err := p.provisioned(options.DataSource.PV)
+	if err != nil {
+		return nil, fmt.Errorf("error determining if this provisioner was the one to provision source volume %q: %v", options.DataSource.PV.Name, err)
+	}
+	if !provisioned {
+		return nil, fmt.Errorf("this provisioner id %s didn't provision source volume %q and so can't clone it; id %s did", p.identity, options.DataSource.PV.Name, options.DataSource.PV.Annotations[annProvisionerID])
+	}
+
+	return p.Provision(options)
+}
+
 type volume struct {
 	server       string
 	path         string
@@ -231,8 +248,18 @@ type volume struct {
 	mountOptions string
 }
 
+// HealthCheck checks that NFS Ganesha can be reached, if the provisioner
+// exports volumes using it.
+func (p *nfsProvisioner) HealthCheck() error {
+	if e, ok := p.exporter.(*ganeshaExporter); ok {
+		return e.Ping()
+	}
+	return nil
+}
+
 // createVolume creates a volume i.e. the storage asset. It creates a unique
-// directory under /export and exports it. Returns the server IP, the path, a
+// directory under /export, copies the data source's contents into it if there
+// is one, and exports it. Returns the server IP, the path, a
 // zero/non-zero supplemental group, the block it added to either the ganesha
 // config or /etc/exports, and the exportID
 // TODO return values
@@ -254,6 +281,14 @@ func (p *nfsProvisioner) createVolume(options controller.VolumeOptions) (volume,
 		return volume{}, fmt.Errorf("error creating directory for volume: %v", err)
 	}
 
+	if options.DataSource != nil {
+		err = p.copyDirectory(options.DataSource.PV.Name, options.PVName)
+		if err != nil {
+			os.RemoveAll(path)
+			return volume{}, fmt.Errorf("error copying source volume %q's directory for volume: %v", options.DataSource.PV.Name, err)
+		}
+	}
+
 	exportBlock, exportID, err := p.createExport(options.PVName, rootSquash)
 	if err != nil {
 		os.RemoveAll(path)
@@ -305,11 +340,9 @@ func (p *nfsProvisioner) validateOptions(options controller.VolumeOptions) (stri
 		}
 	}
 
-	// TODO implement options.ProvisionerSelector parsing
-	// pv.Labels MUST be set to match claim.spec.selector
-	// gid selector? with or without pv annotation?
-	if options.PVC.Spec.Selector != nil {
-		return "", false, "", fmt.Errorf("claim.Spec.Selector is not supported")
+	// TODO support selecting on e.g. gid
+	if err := controller.ValidateSelector(options.SelectorRequirements); err != nil {
+		return "", false, "", err
 	}
 
 	var stat syscall.Statfs_t
@@ -448,6 +481,23 @@ func (p *nfsProvisioner) createDirectory(directory, gid string) error {
 	return nil
 }
 
+// copyDirectory copies the contents of the given source directory in exportDir
+// to the given directory, preserving ownership & permissions.
+func (p *nfsProvisioner) copyDirectory(source, directory string) error {
+	sourcePath := path.Join(p.exportDir, source)
+	if _, err := os.Stat(sourcePath); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("cp", "-a", sourcePath+"/.", path.Join(p.exportDir, directory))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cp failed with error: %v, output: %s", err, out)
+	}
+
+	return nil
+}
+
 // createExport creates the export by adding a block to the appropriate config
 // file and exporting it
 func (p *nfsProvisioner) createExport(directory string, rootSquash bool) (string, uint16, error) {
@@ -32,7 +32,9 @@ import (
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/client-go/kubernetes/fake"
 	utiltesting "k8s.io/client-go/util/testing"
 )
@@ -177,6 +179,8 @@ func TestValidateOptions(t *testing.T) {
 	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
 	defer os.RemoveAll(tmpDir)
 
+	fooRequirement, _ := labels.NewRequirement("foo", selection.Equals, []string{"bar"})
+
 	tests := []struct {
 		name               string
 		options            controller.VolumeOptions
@@ -274,12 +278,21 @@ func TestValidateOptions(t *testing.T) {
 			expectedGid: "none",
 			expectError: false,
 		},
-		// TODO implement options.ProvisionerSelector parsing
 		{
-			name: "non-nil selector",
+			name: "empty selector",
 			options: controller.VolumeOptions{
 				PVC: newClaim(resource.MustParse("1Ki"), nil, &metav1.LabelSelector{MatchLabels: nil}),
 			},
+			expectedGid: "none",
+			expectError: false,
+		},
+		// TODO support selecting on e.g. gid
+		{
+			name: "unsupported selector",
+			options: controller.VolumeOptions{
+				PVC:                  newClaim(resource.MustParse("1Ki"), nil, &metav1.LabelSelector{MatchLabels: map[string]string{"foo": "bar"}}),
+				SelectorRequirements: labels.Requirements{*fooRequirement},
+			},
 			expectedGid: "",
 			expectError: true,
 		},
@@ -381,6 +394,61 @@ func TestCreateDirectory(t *testing.T) {
 	}
 }
 
+func TestCopyDirectory(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name         string
+		source       string
+		directory    string
+		expectedData string
+		expectError  bool
+	}{
+		{
+			name:         "copy",
+			source:       "source",
+			directory:    "foo",
+			expectedData: "data",
+			expectError:  false,
+		},
+		{
+			name:         "source doesn't exist",
+			source:       "bar",
+			directory:    "baz",
+			expectedData: "",
+			expectError:  true,
+		},
+	}
+
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+
+	if err := os.MkdirAll(p.exportDir+"source/dir", 0755); err != nil {
+		t.Fatalf("Error creating source directory: %v", err)
+	}
+	if err := ioutil.WriteFile(p.exportDir+"source/dir/file", []byte("data"), 0600); err != nil {
+		t.Fatalf("Error creating source file: %v", err)
+	}
+
+	for _, test := range tests {
+		if err := p.createDirectory(test.directory, "none"); err != nil {
+			t.Fatalf("Error creating directory %s: %v", test.directory, err)
+		}
+
+		err := p.copyDirectory(test.source, test.directory)
+
+		var data string
+		if !test.expectError {
+			var bytes []byte
+			bytes, err = ioutil.ReadFile(p.exportDir + test.directory + "/dir/file")
+			data = string(bytes)
+		}
+
+		evaluate(t, test.name, test.expectError, err, test.expectedData, data, "copied file contents")
+	}
+}
+
 func TestAddToRemoveFromFile(t *testing.T) {
 	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
 	defer os.RemoveAll(tmpDir)
@@ -0,0 +1,238 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	core_v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// VolumeSnapshotDataResourcePlural is the plural of VolumeSnapshotData
+	VolumeSnapshotDataResourcePlural = "volumesnapshotdatas"
+	// VolumeSnapshotResourcePlural is the plural of VolumeSnapshot
+	VolumeSnapshotResourcePlural = "volumesnapshots"
+)
+
+// VolumeSnapshot is the volume snapshot object accessible to the user. Upon
+// successful creation of the actual snapshot by the volume provider it is
+// bound to the corresponding VolumeSnapshotData through the VolumeSnapshotSpec
+type VolumeSnapshot struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata"`
+
+	// Spec represents the desired state of the snapshot
+	// +optional
+	Spec VolumeSnapshotSpec `json:"spec" protobuf:"bytes,2,opt,name=spec"`
+
+	// Status represents the latest observer state of the snapshot
+	// +optional
+	Status VolumeSnapshotStatus `json:"status" protobuf:"bytes,3,opt,name=status"`
+}
+
+// VolumeSnapshotList is a list of VolumeSnapshot objects
+type VolumeSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ListMeta  `json:"metadata"`
+	Items           []VolumeSnapshot `json:"items"`
+}
+
+// VolumeSnapshotSpec is the description of the volume snapshot
+type VolumeSnapshotSpec struct {
+	// Source represents the source of the volume snapshot
+	VolumeSnapshotSource `json:",inline" protobuf:"bytes,1,opt,name=volumeSnapshotSource"`
+	// VolumeSnapshotDataName binds the VolumeSnapshot object with the VolumeSnapshotData
+	// +optional
+	VolumeSnapshotDataName string `json:"volumeSnapshotDataName" protobuf:"bytes,2,opt,name=volumeSnapshotDataName"`
+}
+
+// VolumeSnapshotSource is the source of the volume snapshot
+type VolumeSnapshotSource struct {
+	// PersistentVolumeClaimName is the name of the PVC being snapshotted
+	// +optional
+	PersistentVolumeClaimName string `json:"persistentVolumeClaimName" protobuf:"bytes,1,opt,name=persistentVolumeClaimName"`
+}
+
+// VolumeSnapshotStatus is the status of the volume snapshot
+type VolumeSnapshotStatus struct {
+	// The time the snapshot was successfully created
+	// +optional
+	CreationTimestamp metav1.Time `json:"creationTimestamp" protobuf:"bytes,1,opt,name=creationTimestamp"`
+
+	// Represents the latest available observations about the volume snapshot
+	Conditions []VolumeSnapshotCondition `json:"conditions" protobuf:"bytes,2,rep,name=conditions"`
+}
+
+// VolumeSnapshotConditionType is the type of a VolumeSnapshotCondition
+type VolumeSnapshotConditionType string
+
+// These are valid conditions of a volume snapshot.
+const (
+	// VolumeSnapshotConditionCreated is present and set to ConditionTrue when the snapshot has been successfully created
+	// The snapshot might not be ready to use yet: there are still some pending operations that don't need the
+	// original volume being snapshotted (eg. the snapshot data need to be uploaded to a dedicated storage)
+	VolumeSnapshotConditionCreated VolumeSnapshotConditionType = "Created"
+	// VolumeSnapshotConditionReady is present and set to ConditionTrue when the snapshot is ready to be used
+	VolumeSnapshotConditionReady VolumeSnapshotConditionType = "Ready"
+	// VolumeSnapshotConditionError is present and set to ConditionTrue when creating the snapshot failed. The
+	// controller doesn't retry: delete the VolumeSnapshot and create it again to retry.
+	VolumeSnapshotConditionError VolumeSnapshotConditionType = "Error"
+)
+
+// VolumeSnapshotCondition describes the state of a volume snapshot at a certain point.
+type VolumeSnapshotCondition struct {
+	// Type of volume snapshot condition.
+	Type VolumeSnapshotConditionType `json:"type" protobuf:"bytes,1,opt,name=type,casttype=VolumeSnapshotConditionType"`
+	// Status of the condition, one of True, False, Unknown.
+	Status core_v1.ConditionStatus `json:"status" protobuf:"bytes,2,opt,name=status,casttype=ConditionStatus"`
+	// The last time the condition transitioned from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime" protobuf:"bytes,3,opt,name=lastTransitionTime"`
+	// The reason for the condition's last transition.
+	// +optional
+	Reason string `json:"reason" protobuf:"bytes,4,opt,name=reason"`
+	// A human readable message indicating details about the transition.
+	// +optional
+	Message string `json:"message" protobuf:"bytes,5,opt,name=message"`
+}
+
+// VolumeSnapshotData represents the actual "on-disk" snapshot object
+type VolumeSnapshotData struct {
+	metav1.TypeMeta `json:",inline"`
+	// +optional
+	Metadata metav1.ObjectMeta `json:"metadata"`
+
+	// Spec represents the desired state of the snapshot
+	// +optional
+	Spec VolumeSnapshotDataSpec `json:"spec" protobuf:"bytes,2,opt,name=spec"`
+
+	// Status represents the latest observed state of the snapshot
+	// +optional
+	Status VolumeSnapshotDataStatus `json:"status" protobuf:"bytes,3,opt,name=status"`
+}
+
+// VolumeSnapshotDataList is a list of VolumeSnapshotData objects
+type VolumeSnapshotDataList struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ListMeta      `json:"metadata"`
+	Items           []VolumeSnapshotData `json:"items"`
+}
+
+// VolumeSnapshotDataSpec is the desired state of the volume snapshot
+type VolumeSnapshotDataSpec struct {
+	// Source represents the location and type of the volume snapshot
+	VolumeSnapshotDataSource `json:",inline" protobuf:"bytes,1,opt,name=volumeSnapshotDataSource"`
+
+	// VolumeSnapshotRef is part of bi-directional binding between VolumeSnapshot
+	// and VolumeSnapshotData
+	// +optional
+	VolumeSnapshotRef *core_v1.ObjectReference `json:"volumeSnapshotRef" protobuf:"bytes,2,opt,name=volumeSnapshotRef"`
+
+	// PersistentVolumeRef represents the PersistentVolume that the snapshot has been
+	// taken from
+	// +optional
+	PersistentVolumeRef *core_v1.ObjectReference `json:"persistentVolumeRef" protobuf:"bytes,3,opt,name=persistentVolumeRef"`
+}
+
+// VolumeSnapshotDataStatus is the actual state of the volume snapshot
+type VolumeSnapshotDataStatus struct {
+	// The time the snapshot was successfully created
+	// +optional
+	CreationTimestamp metav1.Time `json:"creationTimestamp" protobuf:"bytes,1,opt,name=creationTimestamp"`
+
+	// Represents the latest available observations about the volume snapshot
+	Conditions []VolumeSnapshotCondition `json:"conditions" protobuf:"bytes,2,rep,name=conditions"`
+}
+
+// VolumeSnapshotDataSource represents the actual location and type of the
+// snapshot. Only one of its members may be specified.
+type VolumeSnapshotDataSource struct {
+	// HostPath represents a directory on the host.
+	// Provisioned by a developer or tester.
+	// This is useful for single-node development and testing only!
+	// On-host storage is not supported in any way and WILL NOT WORK in a multi-node cluster.
+	// More info: https://kubernetes.io/docs/concepts/storage/volumes#hostpath
+	// +optional
+	HostPath *HostPathVolumeSnapshotSource `json:"hostPath,omitempty"`
+	// RBD represents a snapshot of a Rados Block Device image.
+	// +optional
+	RBD *RBDVolumeSnapshotSource `json:"rbd,omitempty"`
+}
+
+// HostPathVolumeSnapshotSource is a hostPath volume snapshot source
+type HostPathVolumeSnapshotSource struct {
+	// Path of the snapshot tarball on the host
+	Path string `json:"snapshot"`
+}
+
+// RBDVolumeSnapshotSource is a Ceph RBD volume snapshot source
+type RBDVolumeSnapshotSource struct {
+	// A collection of Ceph monitors
+	CephMonitors []string `json:"monitors"`
+	// The rados pool name
+	RBDPool string `json:"pool"`
+	// The rados image name
+	RBDImage string `json:"image"`
+	// The name of the snapshot of the image
+	RBDSnapshot string `json:"snapshot"`
+	// The rados user name
+	RadosUser string `json:"user"`
+	// The namespace & name of the secret holding the rados user's key
+	SecretNamespace string `json:"secretNamespace"`
+	SecretName      string `json:"secretName"`
+}
+
+// GetObjectKind is required to satisfy Object interface
+func (v *VolumeSnapshot) GetObjectKind() schema.ObjectKind {
+	return &v.TypeMeta
+}
+
+// GetObjectMeta is required to satisfy ObjectMetaAccessor interface
+func (v *VolumeSnapshot) GetObjectMeta() metav1.Object {
+	return &v.Metadata
+}
+
+// GetObjectKind is required to satisfy Object interface
+func (vd *VolumeSnapshotData) GetObjectKind() schema.ObjectKind {
+	return &vd.TypeMeta
+}
+
+// GetObjectMeta is required to satisfy ObjectMetaAccessor interface
+func (vd *VolumeSnapshotData) GetObjectMeta() metav1.Object {
+	return &vd.Metadata
+}
+
+// GetObjectKind is required to satisfy Object interface
+func (vd *VolumeSnapshotDataList) GetObjectKind() schema.ObjectKind {
+	return &vd.TypeMeta
+}
+
+// GetListMeta is required to satisfy ListMetaAccessor interface
+func (vd *VolumeSnapshotDataList) GetListMeta() metav1.List {
+	return &vd.Metadata
+}
+
+// GetObjectKind is required to satisfy Object interface
+func (vd *VolumeSnapshotList) GetObjectKind() schema.ObjectKind {
+	return &vd.TypeMeta
+}
+
+// GetListMeta is required to satisfy ListMetaAccessor interface
+func (vd *VolumeSnapshotList) GetListMeta() metav1.List {
+	return &vd.Metadata
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	crdv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/crd/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// NewClient returns a REST client for the snapshot custom resources and the
+// scheme it decodes them with
+func NewClient(cfg *rest.Config) (*rest.RESTClient, *runtime.Scheme, error) {
+	scheme := runtime.NewScheme()
+	if err := crdv1.AddToScheme(scheme); err != nil {
+		return nil, nil, err
+	}
+
+	config := *cfg
+	config.GroupVersion = &crdv1.SchemeGroupVersion
+	config.APIPath = "/apis"
+	config.ContentType = runtime.ContentTypeJSON
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+
+	client, err := rest.RESTClientFor(&config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, scheme, nil
+}
+
+// customResourceDefinition is an apiextensions.k8s.io/v1beta1
+// CustomResourceDefinition, which the vendored client doesn't have
+type customResourceDefinition struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta            `json:"metadata"`
+	Spec            customResourceDefinitionSpec `json:"spec"`
+}
+
+type customResourceDefinitionSpec struct {
+	Group   string                        `json:"group"`
+	Version string                        `json:"version"`
+	Scope   string                        `json:"scope"`
+	Names   customResourceDefinitionNames `json:"names"`
+}
+
+type customResourceDefinitionNames struct {
+	Plural string `json:"plural"`
+	Kind   string `json:"kind"`
+}
+
+// CreateCRD creates the VolumeSnapshot & VolumeSnapshotData custom resource
+// definitions, if they don't exist yet
+func CreateCRD(clientset kubernetes.Interface) error {
+	crds := []customResourceDefinition{
+		{
+			Metadata: metav1.ObjectMeta{Name: crdv1.VolumeSnapshotResourcePlural + "." + crdv1.GroupName},
+			Spec: customResourceDefinitionSpec{
+				Group:   crdv1.GroupName,
+				Version: crdv1.SchemeGroupVersion.Version,
+				Scope:   "Namespaced",
+				Names: customResourceDefinitionNames{
+					Plural: crdv1.VolumeSnapshotResourcePlural,
+					Kind:   "VolumeSnapshot",
+				},
+			},
+		},
+		{
+			Metadata: metav1.ObjectMeta{Name: crdv1.VolumeSnapshotDataResourcePlural + "." + crdv1.GroupName},
+			Spec: customResourceDefinitionSpec{
+				Group:   crdv1.GroupName,
+				Version: crdv1.SchemeGroupVersion.Version,
+				Scope:   "Cluster",
+				Names: customResourceDefinitionNames{
+					Plural: crdv1.VolumeSnapshotDataResourcePlural,
+					Kind:   "VolumeSnapshotData",
+				},
+			},
+		},
+	}
+
+	for _, crd := range crds {
+		crd.APIVersion = "apiextensions.k8s.io/v1beta1"
+		crd.Kind = "CustomResourceDefinition"
+		body, err := json.Marshal(crd)
+		if err != nil {
+			return err
+		}
+		_, err = clientset.Core().RESTClient().Post().AbsPath("/apis/apiextensions.k8s.io/v1beta1/customresourcedefinitions").Body(body).DoRaw()
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("error creating custom resource definition %q: %v", crd.Metadata.Name, err)
+		}
+		glog.Infof("Custom resource definition %q exists", crd.Metadata.Name)
+	}
+
+	return nil
+}
+
+// WaitForSnapshotResource waits until the API server serves the snapshot
+// custom resources
+func WaitForSnapshotResource(client rest.Interface) error {
+	return wait.Poll(100*time.Millisecond, 60*time.Second, func() (bool, error) {
+		_, err := client.Get().Resource(crdv1.VolumeSnapshotDataResourcePlural).DoRaw()
+		if err == nil {
+			return true, nil
+		}
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	})
+}
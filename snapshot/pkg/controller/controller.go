@@ -0,0 +1,460 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	crdv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/crd/v1"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/util/goroutinemap"
+)
+
+// snapshotDataNamePrefix is the prefix of the name of the VolumeSnapshotData
+// the controller creates for a VolumeSnapshot, followed by the snapshot's UID.
+// The name is deterministic so that a restarted controller finds the
+// VolumeSnapshotData it created but didn't bind to its VolumeSnapshot yet.
+const snapshotDataNamePrefix = "k8s-volume-snapshot-"
+
+// Event reasons
+const (
+	snapshotCreated      = "SnapshotCreated"
+	snapshotCreateFailed = "SnapshotCreateFailed"
+	snapshotDeleteFailed = "SnapshotDeleteFailed"
+)
+
+// DefaultResyncPeriod is used when option function ResyncPeriod is omitted
+const DefaultResyncPeriod = 60 * time.Second
+
+// SnapshotController creates snapshots of the volumes bound to the claims
+// VolumeSnapshots reference and deletes them when their VolumeSnapshots are
+// deleted. The VolumeSnapshots are its desired state of the world and the
+// VolumeSnapshotDatas, which represent the snapshots, its actual state: it
+// reconciles the two on every event and every resyncPeriod.
+type SnapshotController struct {
+	client         kubernetes.Interface
+	snapshotClient rest.Interface
+
+	// Snapshotters by plugin name, see volume.GetPluginName
+	snapshotters map[string]volume.Snapshotter
+
+	eventRecorder record.EventRecorder
+
+	snapshotStore      cache.Store
+	snapshotController cache.Controller
+	dataStore          cache.Store
+	dataController     cache.Controller
+
+	// Operations on a VolumeSnapshot & its VolumeSnapshotData, by the
+	// VolumeSnapshotData's name. Only one may run at a time.
+	runningOperations goroutinemap.GoRoutineMap
+}
+
+// NewSnapshotController creates a new snapshot controller. snapshotClient &
+// scheme are those returned by client.NewClient.
+func NewSnapshotController(
+	client kubernetes.Interface,
+	snapshotClient rest.Interface,
+	scheme *runtime.Scheme,
+	snapshotters map[string]volume.Snapshotter,
+	resyncPeriod time.Duration,
+) *SnapshotController {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.Core().Events(v1.NamespaceAll)})
+
+	ctrl := &SnapshotController{
+		client:            client,
+		snapshotClient:    snapshotClient,
+		snapshotters:      snapshotters,
+		eventRecorder:     broadcaster.NewRecorder(scheme, v1.EventSource{Component: "volume-snapshot-controller"}),
+		runningOperations: goroutinemap.NewGoRoutineMap(false),
+	}
+
+	ctrl.snapshotStore, ctrl.snapshotController = cache.NewInformer(
+		cache.NewListWatchFromClient(snapshotClient, crdv1.VolumeSnapshotResourcePlural, v1.NamespaceAll, fields.Everything()),
+		&crdv1.VolumeSnapshot{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    ctrl.addSnapshot,
+			UpdateFunc: ctrl.updateSnapshot,
+			DeleteFunc: ctrl.deleteSnapshot,
+		},
+	)
+
+	ctrl.dataStore, ctrl.dataController = cache.NewInformer(
+		cache.NewListWatchFromClient(snapshotClient, crdv1.VolumeSnapshotDataResourcePlural, v1.NamespaceAll, fields.Everything()),
+		&crdv1.VolumeSnapshotData{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    ctrl.addSnapshotData,
+			UpdateFunc: ctrl.updateSnapshotData,
+		},
+	)
+
+	return ctrl
+}
+
+// Run starts all of this controller's control loops
+func (ctrl *SnapshotController) Run(stopCh <-chan struct{}) {
+	glog.Info("Starting snapshot controller")
+	go ctrl.snapshotController.Run(stopCh)
+	go ctrl.dataController.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, ctrl.snapshotController.HasSynced, ctrl.dataController.HasSynced) {
+		return
+	}
+	glog.Info("Snapshot controller synced")
+	<-stopCh
+	glog.Info("Stopping snapshot controller")
+}
+
+func (ctrl *SnapshotController) addSnapshot(obj interface{}) {
+	snapshot, ok := obj.(*crdv1.VolumeSnapshot)
+	if !ok {
+		glog.Errorf("Expected VolumeSnapshot but handler received %#v", obj)
+		return
+	}
+	ctrl.scheduleOperation(snapshotDataNamePrefix+string(snapshot.Metadata.UID), func() error {
+		return ctrl.syncSnapshot(snapshot)
+	})
+}
+
+func (ctrl *SnapshotController) updateSnapshot(oldObj, newObj interface{}) {
+	ctrl.addSnapshot(newObj)
+}
+
+func (ctrl *SnapshotController) deleteSnapshot(obj interface{}) {
+	if unknown, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = unknown.Obj
+	}
+	snapshot, ok := obj.(*crdv1.VolumeSnapshot)
+	if !ok {
+		glog.Errorf("Expected VolumeSnapshot but handler received %#v", obj)
+		return
+	}
+	if snapshot.Spec.VolumeSnapshotDataName == "" {
+		return
+	}
+	obj, found, err := ctrl.dataStore.GetByKey(snapshot.Spec.VolumeSnapshotDataName)
+	if err != nil || !found {
+		// Deleted on the next resync of the VolumeSnapshotData, if it exists
+		return
+	}
+	ctrl.addSnapshotData(obj)
+}
+
+func (ctrl *SnapshotController) addSnapshotData(obj interface{}) {
+	data, ok := obj.(*crdv1.VolumeSnapshotData)
+	if !ok {
+		glog.Errorf("Expected VolumeSnapshotData but handler received %#v", obj)
+		return
+	}
+	ctrl.scheduleOperation(data.Metadata.Name, func() error {
+		return ctrl.syncSnapshotData(data)
+	})
+}
+
+func (ctrl *SnapshotController) updateSnapshotData(oldObj, newObj interface{}) {
+	ctrl.addSnapshotData(newObj)
+}
+
+// scheduleOperation runs the operation unless one with the same name is
+// already running, in which case the next event or resync will retry
+func (ctrl *SnapshotController) scheduleOperation(name string, operation func() error) {
+	err := ctrl.runningOperations.Run(name, func() error {
+		err := operation()
+		if err != nil {
+			glog.Errorf("Error syncing %s: %v", name, err)
+		}
+		return err
+	})
+	if err != nil && !goroutinemap.IsAlreadyExists(err) {
+		glog.Errorf("Error scheduling operation %s: %v", name, err)
+	}
+}
+
+// syncSnapshot creates the snapshot a VolumeSnapshot requests, or updates its
+// conditions until it is ready
+func (ctrl *SnapshotController) syncSnapshot(snapshot *crdv1.VolumeSnapshot) error {
+	if isConditionTrue(snapshot.Status.Conditions, crdv1.VolumeSnapshotConditionError) {
+		// Creating the snapshot failed and is not retried
+		return nil
+	}
+	if snapshot.Spec.VolumeSnapshotDataName == "" {
+		return ctrl.createSnapshot(snapshot)
+	}
+	if !isConditionTrue(snapshot.Status.Conditions, crdv1.VolumeSnapshotConditionReady) {
+		return ctrl.describeSnapshot(snapshot)
+	}
+	return nil
+}
+
+// createSnapshot creates a snapshot of the volume bound to the VolumeSnapshot's
+// claim and a VolumeSnapshotData to represent it, and binds the two
+func (ctrl *SnapshotController) createSnapshot(snapshot *crdv1.VolumeSnapshot) error {
+	key := snapshotKey(snapshot)
+	dataName := snapshotDataNamePrefix + string(snapshot.Metadata.UID)
+
+	// An earlier attempt may have created the VolumeSnapshotData already
+	data, err := ctrl.getSnapshotData(dataName)
+	if err == nil {
+		glog.Infof("VolumeSnapshotData %s for snapshot %s already exists, binding it", dataName, key)
+		return ctrl.bindSnapshot(snapshot, data)
+	} else if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error getting VolumeSnapshotData %s: %v", dataName, err)
+	}
+
+	pv, err := ctrl.getClaimVolume(snapshot)
+	if err != nil {
+		// The claim may not be bound yet, so retry
+		ctrl.eventRecorder.Event(snapshot, v1.EventTypeWarning, snapshotCreateFailed, err.Error())
+		return err
+	}
+	snapshotter, ok := ctrl.snapshotters[volume.GetPluginName(pv)]
+	if !ok {
+		return ctrl.failSnapshot(snapshot, fmt.Errorf("no snapshotter for volume %q", pv.Name))
+	}
+
+	glog.Infof("Creating snapshot %s of volume %q", key, pv.Name)
+	source, conditions, err := snapshotter.SnapshotCreate(pv)
+	if err != nil {
+		return ctrl.failSnapshot(snapshot, fmt.Errorf("error creating snapshot of volume %q: %v", pv.Name, err))
+	}
+
+	data = &crdv1.VolumeSnapshotData{
+		Metadata: metav1.ObjectMeta{
+			Name: dataName,
+		},
+		Spec: crdv1.VolumeSnapshotDataSpec{
+			VolumeSnapshotDataSource: *source,
+			VolumeSnapshotRef: &v1.ObjectReference{
+				Kind:      "VolumeSnapshot",
+				Namespace: snapshot.Metadata.Namespace,
+				Name:      snapshot.Metadata.Name,
+				UID:       snapshot.Metadata.UID,
+			},
+			PersistentVolumeRef: &v1.ObjectReference{
+				Kind: "PersistentVolume",
+				Name: pv.Name,
+				UID:  pv.UID,
+			},
+		},
+		Status: crdv1.VolumeSnapshotDataStatus{
+			CreationTimestamp: metav1.Now(),
+			Conditions:        setConditionTimes(conditions),
+		},
+	}
+	result := &crdv1.VolumeSnapshotData{}
+	err = ctrl.snapshotClient.Post().Resource(crdv1.VolumeSnapshotDataResourcePlural).Body(data).Do().Into(result)
+	if err != nil {
+		// Without a VolumeSnapshotData the snapshot would be leaked, so
+		// delete it and retry
+		strerr := fmt.Sprintf("Error creating VolumeSnapshotData for snapshot %s: %v. Deleting the snapshot.", key, err)
+		glog.Error(strerr)
+		ctrl.eventRecorder.Event(snapshot, v1.EventTypeWarning, snapshotCreateFailed, strerr)
+		if deleteErr := snapshotter.SnapshotDelete(source, pv); deleteErr != nil {
+			glog.Errorf("Error deleting snapshot %s: %v", key, deleteErr)
+		}
+		return err
+	}
+
+	return ctrl.bindSnapshot(snapshot, result)
+}
+
+// bindSnapshot binds the VolumeSnapshot to the VolumeSnapshotData & copies its
+// status
+func (ctrl *SnapshotController) bindSnapshot(snapshot *crdv1.VolumeSnapshot, data *crdv1.VolumeSnapshotData) error {
+	snapshotCopy := *snapshot
+	snapshotCopy.Spec.VolumeSnapshotDataName = data.Metadata.Name
+	snapshotCopy.Status.CreationTimestamp = data.Status.CreationTimestamp
+	snapshotCopy.Status.Conditions = data.Status.Conditions
+	if err := ctrl.putSnapshot(&snapshotCopy); err != nil {
+		return fmt.Errorf("error binding snapshot %s to VolumeSnapshotData %s: %v", snapshotKey(snapshot), data.Metadata.Name, err)
+	}
+	glog.Infof("Snapshot %s bound to VolumeSnapshotData %s", snapshotKey(snapshot), data.Metadata.Name)
+	ctrl.eventRecorder.Event(snapshot, v1.EventTypeNormal, snapshotCreated, fmt.Sprintf("Snapshot created, VolumeSnapshotData %s", data.Metadata.Name))
+	return nil
+}
+
+// describeSnapshot updates the conditions of a VolumeSnapshot that isn't ready
+// yet & its VolumeSnapshotData
+func (ctrl *SnapshotController) describeSnapshot(snapshot *crdv1.VolumeSnapshot) error {
+	data, err := ctrl.getSnapshotData(snapshot.Spec.VolumeSnapshotDataName)
+	if err != nil {
+		return fmt.Errorf("error getting VolumeSnapshotData %s: %v", snapshot.Spec.VolumeSnapshotDataName, err)
+	}
+	snapshotter, ok := ctrl.snapshotters[volume.GetSnapshotDataPluginName(&data.Spec.VolumeSnapshotDataSource)]
+	if !ok {
+		return fmt.Errorf("no snapshotter for VolumeSnapshotData %s", data.Metadata.Name)
+	}
+	conditions, _, err := snapshotter.DescribeSnapshot(data)
+	if err != nil {
+		return fmt.Errorf("error describing snapshot %s: %v", snapshotKey(snapshot), err)
+	}
+	conditions = setConditionTimes(conditions)
+
+	data.Status.Conditions = conditions
+	err = ctrl.snapshotClient.Put().Resource(crdv1.VolumeSnapshotDataResourcePlural).Name(data.Metadata.Name).Body(data).Do().Error()
+	if err != nil {
+		return fmt.Errorf("error updating VolumeSnapshotData %s: %v", data.Metadata.Name, err)
+	}
+	snapshotCopy := *snapshot
+	snapshotCopy.Status.Conditions = conditions
+	return ctrl.putSnapshot(&snapshotCopy)
+}
+
+// failSnapshot records on the VolumeSnapshot that creating the snapshot failed
+// so that it isn't retried
+func (ctrl *SnapshotController) failSnapshot(snapshot *crdv1.VolumeSnapshot, err error) error {
+	glog.Errorf("Failed to create snapshot %s: %v", snapshotKey(snapshot), err)
+	ctrl.eventRecorder.Event(snapshot, v1.EventTypeWarning, snapshotCreateFailed, err.Error())
+	snapshotCopy := *snapshot
+	snapshotCopy.Status.Conditions = setConditionTimes([]crdv1.VolumeSnapshotCondition{
+		{
+			Type:    crdv1.VolumeSnapshotConditionError,
+			Status:  v1.ConditionTrue,
+			Reason:  snapshotCreateFailed,
+			Message: err.Error(),
+		},
+	})
+	if putErr := ctrl.putSnapshot(&snapshotCopy); putErr != nil {
+		return fmt.Errorf("error recording failure on snapshot %s: %v", snapshotKey(snapshot), putErr)
+	}
+	return err
+}
+
+// syncSnapshotData deletes the snapshot a VolumeSnapshotData represents if its
+// VolumeSnapshot no longer exists
+func (ctrl *SnapshotController) syncSnapshotData(data *crdv1.VolumeSnapshotData) error {
+	ref := data.Spec.VolumeSnapshotRef
+	if ref == nil {
+		// Not created by the controller, e.g. imported by an admin
+		return nil
+	}
+	obj, found, err := ctrl.snapshotStore.GetByKey(ref.Namespace + "/" + ref.Name)
+	if err == nil && found && obj.(*crdv1.VolumeSnapshot).Metadata.UID == ref.UID {
+		return nil
+	}
+	// The cache may not have the VolumeSnapshot yet, so check the API server
+	// before deleting anything
+	snapshot := &crdv1.VolumeSnapshot{}
+	err = ctrl.snapshotClient.Get().Namespace(ref.Namespace).Resource(crdv1.VolumeSnapshotResourcePlural).Name(ref.Name).Do().Into(snapshot)
+	if err == nil && snapshot.Metadata.UID == ref.UID {
+		return nil
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error getting snapshot %s/%s: %v", ref.Namespace, ref.Name, err)
+	}
+
+	return ctrl.deleteSnapshotData(data)
+}
+
+// deleteSnapshotData deletes the snapshot a VolumeSnapshotData represents and
+// then the VolumeSnapshotData
+func (ctrl *SnapshotController) deleteSnapshotData(data *crdv1.VolumeSnapshotData) error {
+	snapshotter, ok := ctrl.snapshotters[volume.GetSnapshotDataPluginName(&data.Spec.VolumeSnapshotDataSource)]
+	if !ok {
+		return fmt.Errorf("no snapshotter for VolumeSnapshotData %s", data.Metadata.Name)
+	}
+	var pv *v1.PersistentVolume
+	if data.Spec.PersistentVolumeRef != nil {
+		var err error
+		pv, err = ctrl.client.Core().PersistentVolumes().Get(data.Spec.PersistentVolumeRef.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			pv = nil
+		} else if err != nil {
+			return fmt.Errorf("error getting volume %q: %v", data.Spec.PersistentVolumeRef.Name, err)
+		}
+	}
+
+	glog.Infof("Deleting snapshot of VolumeSnapshotData %s", data.Metadata.Name)
+	if err := snapshotter.SnapshotDelete(&data.Spec.VolumeSnapshotDataSource, pv); err != nil {
+		strerr := fmt.Sprintf("Error deleting snapshot: %v", err)
+		ctrl.eventRecorder.Event(data, v1.EventTypeWarning, snapshotDeleteFailed, strerr)
+		return fmt.Errorf("error deleting snapshot of VolumeSnapshotData %s: %v", data.Metadata.Name, err)
+	}
+	err := ctrl.snapshotClient.Delete().Resource(crdv1.VolumeSnapshotDataResourcePlural).Name(data.Metadata.Name).Do().Error()
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting VolumeSnapshotData %s: %v", data.Metadata.Name, err)
+	}
+	glog.Infof("Deleted VolumeSnapshotData %s", data.Metadata.Name)
+	return nil
+}
+
+// getClaimVolume returns the volume bound to the VolumeSnapshot's claim
+func (ctrl *SnapshotController) getClaimVolume(snapshot *crdv1.VolumeSnapshot) (*v1.PersistentVolume, error) {
+	claimName := snapshot.Spec.PersistentVolumeClaimName
+	claim, err := ctrl.client.Core().PersistentVolumeClaims(snapshot.Metadata.Namespace).Get(claimName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting claim %q: %v", claimName, err)
+	}
+	if claim.Status.Phase != v1.ClaimBound || claim.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("claim %q is not bound", claimName)
+	}
+	pv, err := ctrl.client.Core().PersistentVolumes().Get(claim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting claim %q's volume %q: %v", claimName, claim.Spec.VolumeName, err)
+	}
+	return pv, nil
+}
+
+func (ctrl *SnapshotController) getSnapshotData(name string) (*crdv1.VolumeSnapshotData, error) {
+	data := &crdv1.VolumeSnapshotData{}
+	err := ctrl.snapshotClient.Get().Resource(crdv1.VolumeSnapshotDataResourcePlural).Name(name).Do().Into(data)
+	return data, err
+}
+
+func (ctrl *SnapshotController) putSnapshot(snapshot *crdv1.VolumeSnapshot) error {
+	return ctrl.snapshotClient.Put().Namespace(snapshot.Metadata.Namespace).Resource(crdv1.VolumeSnapshotResourcePlural).Name(snapshot.Metadata.Name).Body(snapshot).Do().Error()
+}
+
+func snapshotKey(snapshot *crdv1.VolumeSnapshot) string {
+	return snapshot.Metadata.Namespace + "/" + snapshot.Metadata.Name
+}
+
+// isConditionTrue returns whether the conditions include one of the given type
+// with status True
+func isConditionTrue(conditions []crdv1.VolumeSnapshotCondition, conditionType crdv1.VolumeSnapshotConditionType) bool {
+	for _, condition := range conditions {
+		if condition.Type == conditionType {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// setConditionTimes sets the transition time of conditions that have none to
+// now
+func setConditionTimes(conditions []crdv1.VolumeSnapshotCondition) []crdv1.VolumeSnapshotCondition {
+	now := metav1.Now()
+	for i := range conditions {
+		if conditions[i].LastTransitionTime.IsZero() {
+			conditions[i].LastTransitionTime = now
+		}
+	}
+	return conditions
+}
@@ -0,0 +1,370 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+
+	crdv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/crd/v1"
+	snapshotclient "github.com/kubernetes-incubator/external-storage/snapshot/pkg/client"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+const apiPrefix = "/apis/" + crdv1.GroupName + "/v1"
+
+func TestCreateSnapshot(t *testing.T) {
+	tests := []struct {
+		name              string
+		objs              []runtime.Object
+		data              *crdv1.VolumeSnapshotData
+		createErr         error
+		expectErr         bool
+		expectCreated     bool
+		expectDataName    string
+		expectedCondition crdv1.VolumeSnapshotConditionType
+	}{
+		{
+			name:              "create snapshot",
+			objs:              []runtime.Object{newClaim("claim-1", "pv-1"), newVolume("pv-1")},
+			expectCreated:     true,
+			expectDataName:    "k8s-volume-snapshot-uid-1",
+			expectedCondition: crdv1.VolumeSnapshotConditionReady,
+		},
+		{
+			name:      "claim not bound",
+			objs:      []runtime.Object{newClaim("claim-1", ""), newVolume("pv-1")},
+			expectErr: true,
+		},
+		{
+			name:              "snapshotter fails",
+			objs:              []runtime.Object{newClaim("claim-1", "pv-1"), newVolume("pv-1")},
+			createErr:         errors.New("fake error"),
+			expectErr:         true,
+			expectedCondition: crdv1.VolumeSnapshotConditionError,
+		},
+		{
+			name:              "data already created",
+			objs:              []runtime.Object{newClaim("claim-1", "pv-1"), newVolume("pv-1")},
+			data:              newSnapshotData("k8s-volume-snapshot-uid-1", "snapshot-1", "uid-1"),
+			expectDataName:    "k8s-volume-snapshot-uid-1",
+			expectedCondition: crdv1.VolumeSnapshotConditionReady,
+		},
+	}
+	for _, test := range tests {
+		server := newFakeServer()
+		snapshot := newSnapshot("snapshot-1", "uid-1", "claim-1")
+		server.add(apiPrefix+"/namespaces/default/volumesnapshots/snapshot-1", snapshot)
+		if test.data != nil {
+			server.add(apiPrefix+"/volumesnapshotdatas/"+test.data.Metadata.Name, test.data)
+		}
+		snapshotter := &fakeSnapshotter{createErr: test.createErr}
+		ctrl := newTestController(t, server, test.objs, snapshotter)
+
+		err := ctrl.syncSnapshot(snapshot)
+		server.Close()
+
+		t.Logf("test case: %s", test.name)
+		if !test.expectErr && err != nil {
+			t.Errorf("expected no error but got: %v", err)
+		} else if test.expectErr && err == nil {
+			t.Errorf("expected error but got none")
+		}
+		if snapshotter.created != test.expectCreated {
+			t.Errorf("expected snapshot created %v but got %v", test.expectCreated, snapshotter.created)
+		}
+		result := &crdv1.VolumeSnapshot{}
+		server.get(apiPrefix+"/namespaces/default/volumesnapshots/snapshot-1", result)
+		if result.Spec.VolumeSnapshotDataName != test.expectDataName {
+			t.Errorf("expected snapshot bound to %q but got %q", test.expectDataName, result.Spec.VolumeSnapshotDataName)
+		}
+		if test.expectDataName != "" {
+			data := &crdv1.VolumeSnapshotData{}
+			if !server.get(apiPrefix+"/volumesnapshotdatas/"+test.expectDataName, data) {
+				t.Errorf("expected VolumeSnapshotData %s but it doesn't exist", test.expectDataName)
+			} else if data.Spec.VolumeSnapshotRef == nil || data.Spec.VolumeSnapshotRef.UID != "uid-1" {
+				t.Errorf("expected VolumeSnapshotData bound to snapshot uid-1 but got %v", data.Spec.VolumeSnapshotRef)
+			}
+		}
+		var conditionType crdv1.VolumeSnapshotConditionType
+		if len(result.Status.Conditions) > 0 {
+			conditionType = result.Status.Conditions[0].Type
+		}
+		if conditionType != test.expectedCondition {
+			t.Errorf("expected condition %q but got %q", test.expectedCondition, conditionType)
+		}
+	}
+}
+
+func TestSyncSnapshotData(t *testing.T) {
+	tests := []struct {
+		name          string
+		snapshot      *crdv1.VolumeSnapshot
+		expectDeleted bool
+	}{
+		{
+			name:          "snapshot exists",
+			snapshot:      newSnapshot("snapshot-1", "uid-1", "claim-1"),
+			expectDeleted: false,
+		},
+		{
+			name:          "snapshot deleted",
+			snapshot:      nil,
+			expectDeleted: true,
+		},
+		{
+			name:          "snapshot recreated",
+			snapshot:      newSnapshot("snapshot-1", "uid-2", "claim-1"),
+			expectDeleted: true,
+		},
+	}
+	for _, test := range tests {
+		server := newFakeServer()
+		if test.snapshot != nil {
+			server.add(apiPrefix+"/namespaces/default/volumesnapshots/snapshot-1", test.snapshot)
+		}
+		data := newSnapshotData("k8s-volume-snapshot-uid-1", "snapshot-1", "uid-1")
+		server.add(apiPrefix+"/volumesnapshotdatas/"+data.Metadata.Name, data)
+		snapshotter := &fakeSnapshotter{}
+		ctrl := newTestController(t, server, []runtime.Object{newVolume("pv-1")}, snapshotter)
+
+		err := ctrl.syncSnapshotData(data)
+		server.Close()
+
+		t.Logf("test case: %s", test.name)
+		if err != nil {
+			t.Errorf("expected no error but got: %v", err)
+		}
+		if snapshotter.deleted != test.expectDeleted {
+			t.Errorf("expected snapshot deleted %v but got %v", test.expectDeleted, snapshotter.deleted)
+		}
+		exists := server.get(apiPrefix+"/volumesnapshotdatas/"+data.Metadata.Name, &crdv1.VolumeSnapshotData{})
+		if exists == test.expectDeleted {
+			t.Errorf("expected VolumeSnapshotData deleted %v but got %v", test.expectDeleted, !exists)
+		}
+	}
+}
+
+func newTestController(t *testing.T, server *fakeServer, objs []runtime.Object, snapshotter volume.Snapshotter) *SnapshotController {
+	snapshotClient, scheme, err := snapshotclient.NewClient(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	client := fake.NewSimpleClientset(objs...)
+	snapshotters := map[string]volume.Snapshotter{volume.HostPathPluginName: snapshotter}
+	return NewSnapshotController(client, snapshotClient, scheme, snapshotters, DefaultResyncPeriod)
+}
+
+func newSnapshot(name, uid, claimName string) *crdv1.VolumeSnapshot {
+	return &crdv1.VolumeSnapshot{
+		TypeMeta: metav1.TypeMeta{Kind: "VolumeSnapshot", APIVersion: crdv1.SchemeGroupVersion.String()},
+		Metadata: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(uid),
+		},
+		Spec: crdv1.VolumeSnapshotSpec{
+			VolumeSnapshotSource: crdv1.VolumeSnapshotSource{PersistentVolumeClaimName: claimName},
+		},
+	}
+}
+
+func newSnapshotData(name, snapshotName, snapshotUID string) *crdv1.VolumeSnapshotData {
+	return &crdv1.VolumeSnapshotData{
+		TypeMeta: metav1.TypeMeta{Kind: "VolumeSnapshotData", APIVersion: crdv1.SchemeGroupVersion.String()},
+		Metadata: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: crdv1.VolumeSnapshotDataSpec{
+			VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+				HostPath: &crdv1.HostPathVolumeSnapshotSource{Path: "/tmp/snapshot.tgz"},
+			},
+			VolumeSnapshotRef: &v1.ObjectReference{
+				Kind:      "VolumeSnapshot",
+				Namespace: "default",
+				Name:      snapshotName,
+				UID:       types.UID(snapshotUID),
+			},
+			PersistentVolumeRef: &v1.ObjectReference{Kind: "PersistentVolume", Name: "pv-1"},
+		},
+		Status: crdv1.VolumeSnapshotDataStatus{
+			Conditions: []crdv1.VolumeSnapshotCondition{
+				{Type: crdv1.VolumeSnapshotConditionReady, Status: v1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func newClaim(name, volumeName string) *v1.PersistentVolumeClaim {
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			VolumeName: volumeName,
+		},
+		Status: v1.PersistentVolumeClaimStatus{
+			Phase: v1.ClaimPending,
+		},
+	}
+	if volumeName != "" {
+		claim.Status.Phase = v1.ClaimBound
+	}
+	return claim
+}
+
+func newVolume(name string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: "/tmp/pv-1"},
+			},
+		},
+	}
+}
+
+type fakeSnapshotter struct {
+	createErr error
+	created   bool
+	deleted   bool
+}
+
+var _ volume.Snapshotter = &fakeSnapshotter{}
+
+func (s *fakeSnapshotter) SnapshotCreate(pv *v1.PersistentVolume) (*crdv1.VolumeSnapshotDataSource, []crdv1.VolumeSnapshotCondition, error) {
+	if s.createErr != nil {
+		return nil, nil, s.createErr
+	}
+	s.created = true
+	source := &crdv1.VolumeSnapshotDataSource{
+		HostPath: &crdv1.HostPathVolumeSnapshotSource{Path: "/tmp/snapshot.tgz"},
+	}
+	return source, volume.NewReadyConditions("fake snapshot created"), nil
+}
+
+func (s *fakeSnapshotter) SnapshotDelete(source *crdv1.VolumeSnapshotDataSource, pv *v1.PersistentVolume) error {
+	s.deleted = true
+	return nil
+}
+
+func (s *fakeSnapshotter) DescribeSnapshot(data *crdv1.VolumeSnapshotData) ([]crdv1.VolumeSnapshotCondition, bool, error) {
+	return volume.NewReadyConditions("fake snapshot ready"), true, nil
+}
+
+func (s *fakeSnapshotter) SnapshotRestore(data *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (*v1.PersistentVolumeSource, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeSnapshotter) VolumeDelete(pv *v1.PersistentVolume) error {
+	return nil
+}
+
+// fakeServer is an API server that stores the snapshot custom resources in
+// memory, by path
+type fakeServer struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeServer() *fakeServer {
+	s := &fakeServer{objects: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *fakeServer) add(path string, obj interface{}) {
+	data, _ := json.Marshal(obj)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.objects[path] = data
+}
+
+func (s *fakeServer) get(path string, obj interface{}) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, ok := s.objects[path]
+	if !ok {
+		return false
+	}
+	json.Unmarshal(data, obj)
+	return true
+}
+
+func (s *fakeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	key := r.URL.Path
+	switch r.Method {
+	case "GET":
+		data, ok := s.objects[key]
+		if !ok {
+			s.notFound(w)
+			return
+		}
+		w.Write(data)
+	case "POST":
+		data, _ := ioutil.ReadAll(r.Body)
+		obj := struct {
+			Metadata metav1.ObjectMeta `json:"metadata"`
+		}{}
+		json.Unmarshal(data, &obj)
+		s.objects[path.Join(key, obj.Metadata.Name)] = data
+		w.WriteHeader(http.StatusCreated)
+		w.Write(data)
+	case "PUT":
+		data, _ := ioutil.ReadAll(r.Body)
+		if _, ok := s.objects[key]; !ok {
+			s.notFound(w)
+			return
+		}
+		s.objects[key] = data
+		w.Write(data)
+	case "DELETE":
+		if _, ok := s.objects[key]; !ok {
+			s.notFound(w)
+			return
+		}
+		delete(s.objects, key)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Success"}`))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *fakeServer) notFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+}
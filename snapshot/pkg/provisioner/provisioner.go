@@ -0,0 +1,153 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	crdv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/crd/v1"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// ProvisionerName is the name StorageClasses use to have claims restored
+	// from snapshots
+	ProvisionerName = "volumesnapshot.external-storage.k8s.io/snapshot-promoter"
+
+	// AnnSnapshot is the annotation of a claim naming the VolumeSnapshot, in
+	// the claim's namespace, to restore
+	AnnSnapshot = "snapshot.alpha.kubernetes.io/snapshot"
+
+	provisionerIDAnn = "snapshotProvisionerIdentity"
+)
+
+type snapshotProvisioner struct {
+	snapshotClient rest.Interface
+	// Snapshotters by plugin name, see volume.GetPluginName
+	snapshotters map[string]volume.Snapshotter
+	// Identity of this snapshotProvisioner. Used to identify "this"
+	// provisioner's PVs.
+	identity string
+}
+
+var _ controller.Provisioner = &snapshotProvisioner{}
+
+// NewSnapshotProvisioner creates a provisioner that restores claims from the
+// snapshots their AnnSnapshot annotation names
+func NewSnapshotProvisioner(snapshotClient rest.Interface, snapshotters map[string]volume.Snapshotter, identity string) controller.Provisioner {
+	return &snapshotProvisioner{
+		snapshotClient: snapshotClient,
+		snapshotters:   snapshotters,
+		identity:       identity,
+	}
+}
+
+// Provision creates a volume with the contents of the claim's snapshot
+func (p *snapshotProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	snapshotName, ok := options.PVC.Annotations[AnnSnapshot]
+	if !ok || snapshotName == "" {
+		return nil, &controller.FinalError{Reason: fmt.Sprintf("claim has no %s annotation", AnnSnapshot)}
+	}
+	data, err := p.getSnapshotData(options.PVC.Namespace, snapshotName)
+	if err != nil {
+		return nil, err
+	}
+	snapshotter, ok := p.snapshotters[volume.GetSnapshotDataPluginName(&data.Spec.VolumeSnapshotDataSource)]
+	if !ok {
+		return nil, &controller.FinalError{Reason: fmt.Sprintf("no snapshotter for VolumeSnapshotData %s", data.Metadata.Name)}
+	}
+
+	source, err := snapshotter.SnapshotRestore(data, options.PVC, options.PVName, options.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("error restoring snapshot %s: %v", snapshotName, err)
+	}
+	glog.Infof("Restored snapshot %s/%s to volume %s", options.PVC.Namespace, snapshotName, options.PVName)
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				provisionerIDAnn: p.identity,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: *source,
+		},
+	}
+	return pv, nil
+}
+
+// getSnapshotData returns the VolumeSnapshotData of the named VolumeSnapshot
+// in the given namespace, checking that the snapshot is ready and that the
+// two are bound to each other
+func (p *snapshotProvisioner) getSnapshotData(namespace, snapshotName string) (*crdv1.VolumeSnapshotData, error) {
+	snapshot := &crdv1.VolumeSnapshot{}
+	err := p.snapshotClient.Get().Namespace(namespace).Resource(crdv1.VolumeSnapshotResourcePlural).Name(snapshotName).Do().Into(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("error getting snapshot %s: %v", snapshotName, err)
+	}
+	if !isReady(snapshot) || snapshot.Spec.VolumeSnapshotDataName == "" {
+		return nil, fmt.Errorf("snapshot %s is not ready", snapshotName)
+	}
+
+	data := &crdv1.VolumeSnapshotData{}
+	err = p.snapshotClient.Get().Resource(crdv1.VolumeSnapshotDataResourcePlural).Name(snapshot.Spec.VolumeSnapshotDataName).Do().Into(data)
+	if err != nil {
+		return nil, fmt.Errorf("error getting VolumeSnapshotData %s: %v", snapshot.Spec.VolumeSnapshotDataName, err)
+	}
+	ref := data.Spec.VolumeSnapshotRef
+	if ref == nil || ref.Namespace != namespace || ref.Name != snapshotName || ref.UID != snapshot.Metadata.UID {
+		return nil, &controller.FinalError{Reason: fmt.Sprintf("VolumeSnapshotData %s is not bound to snapshot %s", data.Metadata.Name, snapshotName)}
+	}
+	return data, nil
+}
+
+// Delete deletes a volume created by Provision
+func (p *snapshotProvisioner) Delete(pv *v1.PersistentVolume) error {
+	ann, ok := pv.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	snapshotter, ok := p.snapshotters[volume.GetPluginName(pv)]
+	if !ok {
+		return fmt.Errorf("no snapshotter for volume %s", pv.Name)
+	}
+	return snapshotter.VolumeDelete(pv)
+}
+
+func isReady(snapshot *crdv1.VolumeSnapshot) bool {
+	for _, condition := range snapshot.Status.Conditions {
+		if condition.Type == crdv1.VolumeSnapshotConditionReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
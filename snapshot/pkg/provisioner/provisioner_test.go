@@ -0,0 +1,208 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	crdv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/crd/v1"
+	snapshotclient "github.com/kubernetes-incubator/external-storage/snapshot/pkg/client"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+const apiPrefix = "/apis/" + crdv1.GroupName + "/v1"
+
+func TestProvision(t *testing.T) {
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		ready           bool
+		snapshotRefUID  string
+		expectErr       bool
+		expectFinal     bool
+		expectRestored  bool
+		expectedHostDir string
+	}{
+		{
+			name:            "restore snapshot",
+			annotations:     map[string]string{AnnSnapshot: "snapshot-1"},
+			ready:           true,
+			snapshotRefUID:  "uid-1",
+			expectRestored:  true,
+			expectedHostDir: "/tmp/restore/pv-1",
+		},
+		{
+			name:        "no annotation",
+			annotations: nil,
+			expectErr:   true,
+			expectFinal: true,
+		},
+		{
+			name:           "snapshot not found",
+			annotations:    map[string]string{AnnSnapshot: "snapshot-2"},
+			ready:          true,
+			snapshotRefUID: "uid-1",
+			expectErr:      true,
+		},
+		{
+			name:           "snapshot not ready",
+			annotations:    map[string]string{AnnSnapshot: "snapshot-1"},
+			ready:          false,
+			snapshotRefUID: "uid-1",
+			expectErr:      true,
+		},
+		{
+			name:           "data bound to another snapshot",
+			annotations:    map[string]string{AnnSnapshot: "snapshot-1"},
+			ready:          true,
+			snapshotRefUID: "uid-2",
+			expectErr:      true,
+			expectFinal:    true,
+		},
+	}
+	for _, test := range tests {
+		objects := map[string]interface{}{
+			apiPrefix + "/namespaces/default/volumesnapshots/snapshot-1": newSnapshot(test.ready),
+			apiPrefix + "/volumesnapshotdatas/data-1":                    newSnapshotData(test.snapshotRefUID),
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			obj, ok := objects[r.URL.Path]
+			if r.Method != "GET" || !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+				return
+			}
+			json.NewEncoder(w).Encode(obj)
+		}))
+		snapshotClient, _, err := snapshotclient.NewClient(&rest.Config{Host: server.URL})
+		if err != nil {
+			t.Fatalf("error creating client: %v", err)
+		}
+		snapshotter := &fakeSnapshotter{}
+		p := NewSnapshotProvisioner(snapshotClient, map[string]volume.Snapshotter{volume.HostPathPluginName: snapshotter}, "identity")
+
+		pv, err := p.Provision(controller.VolumeOptions{
+			PVName: "pv-1",
+			PVC:    newClaim(test.annotations),
+		})
+		server.Close()
+
+		t.Logf("test case: %s", test.name)
+		if !test.expectErr && err != nil {
+			t.Errorf("expected no error but got: %v", err)
+		} else if test.expectErr && err == nil {
+			t.Errorf("expected error but got none")
+		}
+		if _, final := err.(*controller.FinalError); final != test.expectFinal {
+			t.Errorf("expected final error %v but got %v", test.expectFinal, err)
+		}
+		if snapshotter.restored != test.expectRestored {
+			t.Errorf("expected snapshot restored %v but got %v", test.expectRestored, snapshotter.restored)
+		}
+		if test.expectedHostDir != "" {
+			if pv == nil || pv.Spec.HostPath == nil || pv.Spec.HostPath.Path != test.expectedHostDir {
+				t.Errorf("expected hostPath volume %s but got %v", test.expectedHostDir, pv)
+			} else if pv.Annotations[provisionerIDAnn] != "identity" {
+				t.Errorf("expected identity annotation but got %v", pv.Annotations)
+			}
+		}
+	}
+}
+
+func newSnapshot(ready bool) *crdv1.VolumeSnapshot {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return &crdv1.VolumeSnapshot{
+		TypeMeta: metav1.TypeMeta{Kind: "VolumeSnapshot", APIVersion: crdv1.SchemeGroupVersion.String()},
+		Metadata: metav1.ObjectMeta{
+			Name:      "snapshot-1",
+			Namespace: "default",
+			UID:       "uid-1",
+		},
+		Spec: crdv1.VolumeSnapshotSpec{
+			VolumeSnapshotSource:   crdv1.VolumeSnapshotSource{PersistentVolumeClaimName: "claim-1"},
+			VolumeSnapshotDataName: "data-1",
+		},
+		Status: crdv1.VolumeSnapshotStatus{
+			Conditions: []crdv1.VolumeSnapshotCondition{
+				{Type: crdv1.VolumeSnapshotConditionReady, Status: status},
+			},
+		},
+	}
+}
+
+func newSnapshotData(snapshotUID string) *crdv1.VolumeSnapshotData {
+	return &crdv1.VolumeSnapshotData{
+		TypeMeta: metav1.TypeMeta{Kind: "VolumeSnapshotData", APIVersion: crdv1.SchemeGroupVersion.String()},
+		Metadata: metav1.ObjectMeta{
+			Name: "data-1",
+		},
+		Spec: crdv1.VolumeSnapshotDataSpec{
+			VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+				HostPath: &crdv1.HostPathVolumeSnapshotSource{Path: "/tmp/snapshot.tgz"},
+			},
+			VolumeSnapshotRef: &v1.ObjectReference{
+				Kind:      "VolumeSnapshot",
+				Namespace: "default",
+				Name:      "snapshot-1",
+				UID:       types.UID(snapshotUID),
+			},
+		},
+	}
+}
+
+func newClaim(annotations map[string]string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "restored-claim",
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi"),
+				},
+			},
+		},
+	}
+}
+
+type fakeSnapshotter struct {
+	volume.Snapshotter
+	restored bool
+}
+
+func (s *fakeSnapshotter) SnapshotRestore(data *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (*v1.PersistentVolumeSource, error) {
+	s.restored = true
+	return &v1.PersistentVolumeSource{
+		HostPath: &v1.HostPathVolumeSource{Path: "/tmp/restore/" + pvName},
+	}, nil
+}
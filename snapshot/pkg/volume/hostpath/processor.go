@@ -0,0 +1,137 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	crdv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/crd/v1"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// hostPathSnapshotter snapshots hostPath volumes into tarballs of their
+// directories, for testing on a single node. It must run on the node, with the
+// volumes' directories, snapshotDir & restoreDir at the same paths as on it.
+type hostPathSnapshotter struct {
+	// The directory to store snapshot tarballs in
+	snapshotDir string
+	// The directory to create restored volumes' directories in
+	restoreDir string
+}
+
+var _ volume.Snapshotter = &hostPathSnapshotter{}
+
+// NewSnapshotter returns a snapshotter of hostPath volumes that stores
+// snapshots in snapshotDir and restores them into directories in restoreDir
+func NewSnapshotter(snapshotDir, restoreDir string) volume.Snapshotter {
+	return &hostPathSnapshotter{
+		snapshotDir: snapshotDir,
+		restoreDir:  restoreDir,
+	}
+}
+
+// SnapshotCreate creates a tarball of the volume's directory
+func (h *hostPathSnapshotter) SnapshotCreate(pv *v1.PersistentVolume) (*crdv1.VolumeSnapshotDataSource, []crdv1.VolumeSnapshotCondition, error) {
+	if pv.Spec.HostPath == nil {
+		return nil, nil, fmt.Errorf("volume %q is not a hostPath volume", pv.Name)
+	}
+	if err := os.MkdirAll(h.snapshotDir, 0750); err != nil {
+		return nil, nil, err
+	}
+
+	file := path.Join(h.snapshotDir, string(uuid.NewUUID())+".tgz")
+	cmd := exec.Command("tar", "czf", file, "-C", pv.Spec.HostPath.Path, ".")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(file)
+		return nil, nil, fmt.Errorf("tar failed with error: %v, output: %s", err, out)
+	}
+	glog.Infof("Created snapshot %s of volume %q", file, pv.Name)
+
+	source := &crdv1.VolumeSnapshotDataSource{
+		HostPath: &crdv1.HostPathVolumeSnapshotSource{Path: file},
+	}
+	return source, volume.NewReadyConditions("Snapshot created successfully"), nil
+}
+
+// SnapshotDelete deletes the snapshot tarball
+func (h *hostPathSnapshotter) SnapshotDelete(source *crdv1.VolumeSnapshotDataSource, pv *v1.PersistentVolume) error {
+	if source.HostPath == nil {
+		return fmt.Errorf("invalid VolumeSnapshotDataSource: %v", source)
+	}
+	if err := os.Remove(source.HostPath.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// DescribeSnapshot checks that the snapshot tarball exists
+func (h *hostPathSnapshotter) DescribeSnapshot(data *crdv1.VolumeSnapshotData) ([]crdv1.VolumeSnapshotCondition, bool, error) {
+	if data.Spec.HostPath == nil {
+		return nil, false, fmt.Errorf("invalid VolumeSnapshotDataSource: %v", data.Spec.VolumeSnapshotDataSource)
+	}
+	if _, err := os.Stat(data.Spec.HostPath.Path); err != nil {
+		return nil, false, err
+	}
+	return volume.NewReadyConditions("Snapshot exists"), true, nil
+}
+
+// SnapshotRestore extracts the snapshot tarball into a new directory
+func (h *hostPathSnapshotter) SnapshotRestore(data *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (*v1.PersistentVolumeSource, error) {
+	if data.Spec.HostPath == nil {
+		return nil, fmt.Errorf("invalid VolumeSnapshotDataSource: %v", data.Spec.VolumeSnapshotDataSource)
+	}
+
+	dir := path.Join(h.restoreDir, pvName)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return nil, fmt.Errorf("the path %s already exists", dir)
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("tar", "xzf", data.Spec.HostPath.Path, "-C", dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("tar failed with error: %v, output: %s", err, out)
+	}
+	glog.Infof("Restored snapshot %s to %s", data.Spec.HostPath.Path, dir)
+
+	return &v1.PersistentVolumeSource{
+		HostPath: &v1.HostPathVolumeSource{Path: dir},
+	}, nil
+}
+
+// VolumeDelete deletes the directory of a restored volume
+func (h *hostPathSnapshotter) VolumeDelete(pv *v1.PersistentVolume) error {
+	if pv.Spec.HostPath == nil {
+		return fmt.Errorf("volume %q is not a hostPath volume", pv.Name)
+	}
+	// Never delete a directory this snapshotter didn't create
+	dir := filepath.Clean(pv.Spec.HostPath.Path)
+	if filepath.Dir(dir) != filepath.Clean(h.restoreDir) || filepath.Base(dir) != pv.Name {
+		return fmt.Errorf("volume %q's path %s was not restored by this snapshotter", pv.Name, dir)
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	crdv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/crd/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utiltesting "k8s.io/client-go/util/testing"
+)
+
+func TestSnapshotCreateRestoreDelete(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("hostPathSnapshotTest")
+	defer os.RemoveAll(tmpDir)
+
+	volumeDir := path.Join(tmpDir, "volume")
+	if err := os.MkdirAll(path.Join(volumeDir, "dir"), 0755); err != nil {
+		t.Fatalf("Error creating volume directory: %v", err)
+	}
+	if err := ioutil.WriteFile(path.Join(volumeDir, "dir", "file"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Error creating volume file: %v", err)
+	}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-1"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: volumeDir},
+			},
+		},
+	}
+	h := NewSnapshotter(path.Join(tmpDir, "snapshots"), path.Join(tmpDir, "restores"))
+
+	source, conditions, err := h.SnapshotCreate(pv)
+	if err != nil {
+		t.Fatalf("Error creating snapshot: %v", err)
+	}
+	if len(conditions) != 1 || conditions[0].Type != crdv1.VolumeSnapshotConditionReady {
+		t.Errorf("Expected Ready condition but got %v", conditions)
+	}
+
+	data := &crdv1.VolumeSnapshotData{Spec: crdv1.VolumeSnapshotDataSpec{VolumeSnapshotDataSource: *source}}
+	if _, ready, err := h.DescribeSnapshot(data); err != nil || !ready {
+		t.Errorf("Expected snapshot to be ready but got %v, error: %v", ready, err)
+	}
+
+	restoreSource, err := h.SnapshotRestore(data, &v1.PersistentVolumeClaim{}, "pv-2", nil)
+	if err != nil {
+		t.Fatalf("Error restoring snapshot: %v", err)
+	}
+	restored, err := ioutil.ReadFile(path.Join(restoreSource.HostPath.Path, "dir", "file"))
+	if err != nil || string(restored) != "data" {
+		t.Errorf("Expected restored file contents %q but got %q, error: %v", "data", restored, err)
+	}
+
+	// Only restored volumes can be deleted
+	if err = h.VolumeDelete(pv); err == nil {
+		t.Errorf("Expected error deleting a volume that wasn't restored")
+	}
+	restoredPV := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-2"},
+		Spec:       v1.PersistentVolumeSpec{PersistentVolumeSource: *restoreSource},
+	}
+	if err = h.VolumeDelete(restoredPV); err != nil {
+		t.Errorf("Error deleting restored volume: %v", err)
+	}
+	if _, err = os.Stat(restoreSource.HostPath.Path); !os.IsNotExist(err) {
+		t.Errorf("Expected restored volume's directory to be deleted")
+	}
+
+	if err = h.SnapshotDelete(source, pv); err != nil {
+		t.Errorf("Error deleting snapshot: %v", err)
+	}
+	if _, ready, err := h.DescribeSnapshot(data); err == nil || ready {
+		t.Errorf("Expected deleted snapshot not to be ready but got %v", ready)
+	}
+}
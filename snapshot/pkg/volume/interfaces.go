@@ -0,0 +1,91 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	crdv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/crd/v1"
+	"k8s.io/api/core/v1"
+)
+
+// Names of the snapshotters, by the type of volume they snapshot
+const (
+	HostPathPluginName = "hostPath"
+	RBDPluginName      = "rbd"
+)
+
+// Snapshotter is a volume plugin that creates, deletes, describes & restores
+// snapshots of one type of volume
+type Snapshotter interface {
+	// SnapshotCreate creates a snapshot of the given volume and returns where
+	// it is and its conditions, Ready if it can be restored already
+	SnapshotCreate(pv *v1.PersistentVolume) (*crdv1.VolumeSnapshotDataSource, []crdv1.VolumeSnapshotCondition, error)
+
+	// SnapshotDelete deletes the given snapshot. The volume it was taken from
+	// is nil if it no longer exists.
+	SnapshotDelete(source *crdv1.VolumeSnapshotDataSource, pv *v1.PersistentVolume) error
+
+	// DescribeSnapshot returns the conditions of the given snapshot and
+	// whether it is ready to be restored
+	DescribeSnapshot(data *crdv1.VolumeSnapshotData) ([]crdv1.VolumeSnapshotCondition, bool, error)
+
+	// SnapshotRestore creates a volume with the contents of the given snapshot
+	// for the given claim and returns the source of its PV
+	SnapshotRestore(data *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (*v1.PersistentVolumeSource, error)
+
+	// VolumeDelete deletes a volume created by SnapshotRestore
+	VolumeDelete(pv *v1.PersistentVolume) error
+}
+
+// GetPluginName returns the name of the snapshotter of the given volume, or ""
+// if none can snapshot it
+func GetPluginName(pv *v1.PersistentVolume) string {
+	switch {
+	case pv == nil:
+		return ""
+	case pv.Spec.HostPath != nil:
+		return HostPathPluginName
+	case pv.Spec.RBD != nil:
+		return RBDPluginName
+	}
+	return ""
+}
+
+// GetSnapshotDataPluginName returns the name of the snapshotter of the given
+// snapshot, or "" if none can handle it
+func GetSnapshotDataPluginName(source *crdv1.VolumeSnapshotDataSource) string {
+	switch {
+	case source == nil:
+		return ""
+	case source.HostPath != nil:
+		return HostPathPluginName
+	case source.RBD != nil:
+		return RBDPluginName
+	}
+	return ""
+}
+
+// NewReadyConditions returns the conditions of a snapshot that has been
+// created and is ready to be restored
+func NewReadyConditions(message string) []crdv1.VolumeSnapshotCondition {
+	return []crdv1.VolumeSnapshotCondition{
+		{
+			Type:    crdv1.VolumeSnapshotConditionReady,
+			Status:  v1.ConditionTrue,
+			Message: message,
+		},
+	}
+}
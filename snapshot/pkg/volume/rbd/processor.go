@@ -0,0 +1,234 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/util"
+	crdv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/crd/v1"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+)
+
+// rbdSnapshotter snapshots RBD volumes with "rbd snap" and restores them by
+// copying the snapshot to a new image, which doesn't depend on the snapshot.
+// It runs rbd as the volume's rados user, with the key in its secret.
+type rbdSnapshotter struct {
+	client kubernetes.Interface
+}
+
+var _ volume.Snapshotter = &rbdSnapshotter{}
+
+// imageExistsStr is in the output of rbd cp when the destination exists
+const imageExistsStr = "File exists"
+
+// NewSnapshotter returns a snapshotter of RBD volumes that gets rados users'
+// keys from secrets with the given client
+func NewSnapshotter(client kubernetes.Interface) volume.Snapshotter {
+	return &rbdSnapshotter{client: client}
+}
+
+// SnapshotCreate creates a snapshot of the volume's image
+func (r *rbdSnapshotter) SnapshotCreate(pv *v1.PersistentVolume) (*crdv1.VolumeSnapshotDataSource, []crdv1.VolumeSnapshotCondition, error) {
+	rbd := pv.Spec.RBD
+	if rbd == nil {
+		return nil, nil, fmt.Errorf("volume %q is not an RBD volume", pv.Name)
+	}
+	if rbd.SecretRef == nil || pv.Spec.ClaimRef == nil {
+		return nil, nil, fmt.Errorf("volume %q has no secret or claim to find its secret's namespace", pv.Name)
+	}
+
+	source := &crdv1.RBDVolumeSnapshotSource{
+		CephMonitors:    rbd.CephMonitors,
+		RBDPool:         rbd.RBDPool,
+		RBDImage:        rbd.RBDImage,
+		RBDSnapshot:     "kubernetes-dynamic-snapshot-" + string(uuid.NewUUID()),
+		RadosUser:       rbd.RadosUser,
+		SecretNamespace: pv.Spec.ClaimRef.Namespace,
+		SecretName:      rbd.SecretRef.Name,
+	}
+	if source.RBDPool == "" {
+		source.RBDPool = "rbd"
+	}
+	if source.RadosUser == "" {
+		source.RadosUser = "admin"
+	}
+	if _, err := r.rbd(source, "snap", "create", imageSpec(source)+"@"+source.RBDSnapshot); err != nil {
+		return nil, nil, err
+	}
+	glog.Infof("Created snapshot %s@%s of volume %q", imageSpec(source), source.RBDSnapshot, pv.Name)
+
+	return &crdv1.VolumeSnapshotDataSource{RBD: source}, volume.NewReadyConditions("Snapshot created successfully"), nil
+}
+
+// SnapshotDelete deletes the snapshot of the image
+func (r *rbdSnapshotter) SnapshotDelete(source *crdv1.VolumeSnapshotDataSource, pv *v1.PersistentVolume) error {
+	if source.RBD == nil {
+		return fmt.Errorf("invalid VolumeSnapshotDataSource: %v", source)
+	}
+	_, err := r.rbd(source.RBD, "snap", "rm", imageSpec(source.RBD)+"@"+source.RBD.RBDSnapshot)
+	return err
+}
+
+// DescribeSnapshot checks that the snapshot is in the image's snapshot list
+func (r *rbdSnapshotter) DescribeSnapshot(data *crdv1.VolumeSnapshotData) ([]crdv1.VolumeSnapshotCondition, bool, error) {
+	source := data.Spec.RBD
+	if source == nil {
+		return nil, false, fmt.Errorf("invalid VolumeSnapshotDataSource: %v", data.Spec.VolumeSnapshotDataSource)
+	}
+	output, err := r.rbd(source, "snap", "ls", imageSpec(source))
+	if err != nil {
+		return nil, false, err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		for _, field := range strings.Fields(line) {
+			if field == source.RBDSnapshot {
+				return volume.NewReadyConditions("Snapshot exists"), true, nil
+			}
+		}
+	}
+	return nil, false, fmt.Errorf("snapshot %s@%s not found", imageSpec(source), source.RBDSnapshot)
+}
+
+// SnapshotRestore copies the snapshot to a new image in the same pool, grown
+// to the requested size if the snapshot is smaller
+func (r *rbdSnapshotter) SnapshotRestore(data *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (*v1.PersistentVolumeSource, error) {
+	source := data.Spec.RBD
+	if source == nil {
+		return nil, fmt.Errorf("invalid VolumeSnapshotDataSource: %v", data.Spec.VolumeSnapshotDataSource)
+	}
+	// The secret is referenced by the PV by name only, so it must be in the
+	// claim's namespace
+	if pvc.Namespace != source.SecretNamespace {
+		return nil, fmt.Errorf("snapshot's secret is in namespace %q, not the claim's namespace %q", source.SecretNamespace, pvc.Namespace)
+	}
+
+	// Name the image after the PV, like the provisioners' assets, so a retry
+	// reuses the image an earlier attempt copied
+	image := "kubernetes-dynamic-" + pvName
+	dst := source.RBDPool + "/" + image
+	if _, err := r.rbd(source, "cp", imageSpec(source)+"@"+source.RBDSnapshot, dst); err != nil {
+		if !strings.Contains(err.Error(), imageExistsStr) {
+			return nil, err
+		}
+		glog.Infof("Image %s already exists, reusing it", dst)
+	}
+	capacity := pvc.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	// convert to MB that rbd defaults on
+	sz := util.RoundUpSize(capacity.Value(), 1024*1024)
+	if err := r.growImage(source, dst, sz); err != nil {
+		r.rbd(source, "rm", dst)
+		return nil, err
+	}
+	glog.Infof("Restored snapshot %s@%s to %s", imageSpec(source), source.RBDSnapshot, dst)
+
+	return &v1.PersistentVolumeSource{
+		RBD: &v1.RBDVolumeSource{
+			CephMonitors: source.CephMonitors,
+			RBDImage:     image,
+			RBDPool:      source.RBDPool,
+			RadosUser:    source.RadosUser,
+			SecretRef:    &v1.LocalObjectReference{Name: source.SecretName},
+		},
+	}, nil
+}
+
+// growImage resizes the given image to the given size in MB if it is smaller
+func (r *rbdSnapshotter) growImage(source *crdv1.RBDVolumeSnapshotSource, image string, sz int64) error {
+	output, err := r.rbd(source, "info", image, "--format", "json")
+	if err != nil {
+		return err
+	}
+	info := struct {
+		Size int64 `json:"size"`
+	}{}
+	if err = json.Unmarshal(output, &info); err != nil {
+		return fmt.Errorf("error decoding image %s info: %v", image, err)
+	}
+	if sz*1024*1024 <= info.Size {
+		return nil
+	}
+	_, err = r.rbd(source, "resize", image, "--size", fmt.Sprintf("%d", sz))
+	return err
+}
+
+// VolumeDelete deletes the image of a restored volume
+func (r *rbdSnapshotter) VolumeDelete(pv *v1.PersistentVolume) error {
+	rbd := pv.Spec.RBD
+	if rbd == nil {
+		return fmt.Errorf("volume %q is not an RBD volume", pv.Name)
+	}
+	if rbd.SecretRef == nil || pv.Spec.ClaimRef == nil {
+		return fmt.Errorf("volume %q has no secret or claim to find its secret's namespace", pv.Name)
+	}
+	source := &crdv1.RBDVolumeSnapshotSource{
+		CephMonitors:    rbd.CephMonitors,
+		RBDPool:         rbd.RBDPool,
+		RBDImage:        rbd.RBDImage,
+		RadosUser:       rbd.RadosUser,
+		SecretNamespace: pv.Spec.ClaimRef.Namespace,
+		SecretName:      rbd.SecretRef.Name,
+	}
+	_, err := r.rbd(source, "rm", imageSpec(source))
+	return err
+}
+
+// rbd runs the rbd command with the given arguments as the source's rados
+// user, trying each monitor until it succeeds
+func (r *rbdSnapshotter) rbd(source *crdv1.RBDVolumeSnapshotSource, args ...string) ([]byte, error) {
+	if len(source.CephMonitors) == 0 {
+		return nil, fmt.Errorf("missing Ceph monitors")
+	}
+	secret, err := r.client.Core().Secrets(source.SecretNamespace).Get(source.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %v", source.SecretNamespace, source.SecretName, err)
+	}
+	key, ok := secret.Data["key"]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no key", source.SecretNamespace, source.SecretName)
+	}
+
+	var output []byte
+	l := len(source.CephMonitors)
+	// pick a mon randomly
+	start := rand.Int() % l
+	// iterate all monitors until the command succeeds.
+	for i := start; i < start+l; i++ {
+		mon := source.CephMonitors[i%l]
+		glog.V(4).Infof("rbd: %s using mon %s, id %s", strings.Join(args, " "), mon, source.RadosUser)
+		cmd := exec.Command("rbd", append(args, "--id", source.RadosUser, "-m", mon, "--key="+string(key))...)
+		output, err = cmd.CombinedOutput()
+		if err == nil {
+			return output, nil
+		}
+		glog.Warningf("rbd: %s failed using mon %s, output: %s", strings.Join(args, " "), mon, output)
+	}
+	return nil, fmt.Errorf("rbd %s failed: %v, command output: %s", strings.Join(args, " "), err, output)
+}
+
+func imageSpec(source *crdv1.RBDVolumeSnapshotSource) string {
+	return source.RBDPool + "/" + source.RBDImage
+}
@@ -91,7 +91,11 @@ elif [ "$TEST_SUITE" = "everything-else" ]; then
 	make gluster/glusterfs
 	make iscsi/targetd
 	make test-iscsi/targetd
+	make migration
+	make test-migration
 	make nfs-client
+	make snapshot
+	make test-snapshot
 elif [ "$TEST_SUITE" = "local-volume" ]; then
 	make local-volume/provisioner
 	make test-local-volume/provisioner